@@ -1,16 +1,17 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
-
-	// "os/signal"
-	// "syscall"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/emarifer/search-engine/db"
 	"github.com/emarifer/search-engine/internal/handlers"
+	"github.com/emarifer/search-engine/internal/search"
 	"github.com/emarifer/search-engine/internal/services"
 	"github.com/emarifer/search-engine/internal/utils"
 	"github.com/gofiber/fiber/v2"
@@ -36,30 +37,90 @@ func main() {
 	})
 	app.Static("/", "./assets")
 	app.Use(logger.New())
-	app.Use(compress.New())
+	app.Use(compress.New(compress.Config{
+		// The progress events are streamed as they happen
+		Next: func(c *fiber.Ctx) bool {
+			return c.Path() == "/events"
+		},
+	}))
 
 	// Init database
 	db.InitDB()
 
+	// The running crawls are cancelled when the server is shut down
+	ctx, stop := signal.NotifyContext(
+		context.Background(), os.Interrupt, syscall.SIGTERM,
+	)
+	defer stop()
+
 	// Dependency injection
 	as := services.NewAdminServices(services.User{}, db.GetDB())
 	ah := handlers.NewAuthHandler(&as)
 	ss := services.NewSearchSettingsServices(
 		services.SearchSettings{}, db.GetDB(),
 	)
-	sh := handlers.NewSettingsHandler(&ss)
+	us := services.NewUrlServices(services.CrawledUrl{}, db.GetDB())
+	sdh := handlers.NewSeedsHandler(&us)
+	rs := services.NewCrawlRuleServices(services.CrawlRule{}, db.GetDB())
+	rh := handlers.NewRulesHandler(&rs)
 	si := services.NewSearchIndexServices(services.SearchIndex{}, db.GetDB())
 	sch := handlers.NewSearchHandler(&si)
 
-	handlers.SetRoutes(app, ah, sh, sch)
-
-	us := services.NewUrlServices(services.CrawledUrl{}, db.GetDB())
 	is := services.NewSearchIndexServices(services.SearchIndex{}, db.GetDB())
-	utils.StartCronJobs(&ss, &us, &is)
-
-	log.Println("🚀 Starting server and listening at port", port)
-
-	log.Fatal(app.Listen(port))
+	sms := services.NewSitemapServices(services.Sitemap{}, db.GetDB())
+	fs := services.NewFeedServices(services.Feed{}, db.GetDB())
+	events := search.NewEventBus()
+	engine := search.NewEngine(
+		&ss, &us, &sms, &fs, &is, &rs, search.NewHttpFetcher(0), events,
+	)
+	uh := handlers.NewUrlsHandler(&us, engine)
+	eh := handlers.NewEventsHandler(events)
+	js := services.NewJobServices(services.Job{}, db.GetDB())
+	if err := js.AbortRunning(ctx); err != nil {
+		log.Println(err)
+	}
+	jobs := search.NewJobs(ctx, engine, &js)
+	jh := handlers.NewJobsHandler(jobs, &js)
+
+	// The jobs are scheduled as set in the settings,
+	// and rescheduled when the settings are changed
+	settings, err := ss.Get(ctx)
+	if err != nil {
+		log.Printf("the settings could not be read, using the default schedules: %s\n", err)
+	}
+	c, err := utils.StartCronJobs(jobs, settings)
+	if err != nil {
+		log.Fatalf("🔥 failed to schedule the jobs: %s\n", err)
+	}
+	// So is the anonymous access to the search API (none,
+	// if the settings could not be read)
+	access := handlers.NewAnonymousAccess(settings)
+	sh := handlers.NewSettingsHandler(&ss, &us, c, access)
+
+	sth := handlers.NewStatsHandler(&us, &is)
+	ks := services.NewApiKeyServices(services.ApiKey{}, db.GetDB())
+	kh := handlers.NewApiKeysHandler(&ks, utils.NewRateLimiter(), access)
+
+	handlers.SetRoutes(app, ah, sh, rh, sdh, uh, eh, jh, sth, sch, kh)
+
+	// Start our server and listen for a shutdown
+	go func() {
+		log.Println("🚀 Starting server and listening at port", port)
+
+		if err := app.Listen(port); err != nil {
+			log.Panic(err)
+		}
+	}()
+
+	<-ctx.Done() // Block the main thread until interupted
+	log.Println("Shutting down server")
+	events.Close() // Ends the event streams, which would block the shutdown
+	app.Shutdown()
+
+	// Wait for the running jobs to store their partial results
+	<-c.Stop().Done()
+	jobs.Wait()
+	log.Println("🏁 Running jobs have finished")
 }
 
 // 🧬 ⚡️ 🚀 🏁
@@ -88,22 +149,14 @@ https://mkyong.com/web/how-to-pretty-print-json-output-in-curl/
 
 curl -v -X POST http://localhost:5500/search -d '{ "term": "partner" }' -H "content-type: application/json" | python3 -m json.tool
 
-*/
-
-/* APPLICATION SHUTDOWN CONFIGURATION ALTERNATIVE:
-// Start our server and listen for a shutdown
-go func() {
-	if err := app.Listen(port); err != nil {
-		log.Panic(err)
-	}
-}()
+curl -v "http://localhost:5500/api/v1/search?q=partner&page=1&size=10" | python3 -m json.tool
 
-c := make(chan os.Signal, 1)
-signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+*/
 
-<-c // Block the main thread until interupted
-app.Shutdown()
-log.Println("Shutting down server")
+/* GRACEFUL SHUTDOWN:
+https://pkg.go.dev/os/signal#NotifyContext
+https://docs.gofiber.io/api/app#shutdown
+https://pkg.go.dev/github.com/robfig/cron/v3#Cron.Stop
 */
 
 /* `GOPLS` SERVER CRASH WITH `D-E/TEMPLE` EXTENSION IN VSCODE:
@@ -27,12 +27,24 @@ func InitDB() {
 		log.Fatalln("🔥 failed to enable uuid-ossp extension")
 	}
 
+	// The terms stored more than once by overlapping index
+	// runs are merged before their value is made unique
+	if err := mergeDuplicatedTerms(dbConn); err != nil {
+		log.Fatalf("🔥 failed to merge the duplicated terms: %s\n", err)
+	}
+
 	// Make migrations
 	err = dbConn.AutoMigrate(
 		&services.User{},
 		&services.SearchSettings{},
 		&services.CrawledUrl{},
 		&services.SearchIndex{},
+		&services.Sitemap{},
+		&services.Feed{},
+		&services.CrawlRule{},
+		&services.CrawlAttempt{},
+		&services.Job{},
+		&services.ApiKey{},
 	)
 	if err != nil {
 		log.Fatalf("🔥 failed to migrate: %s\n", err)
@@ -41,6 +53,39 @@ func InitDB() {
 	log.Println("🚀 connected successfully to the database")
 }
 
+// mergeDuplicatedTerms moves the urls of each duplicated term
+// of the index to its oldest copy, and deletes the other ones.
+func mergeDuplicatedTerms(db *gorm.DB) error {
+	migrator := db.Migrator()
+	if !migrator.HasTable(&services.SearchIndex{}) ||
+		migrator.HasIndex(&services.SearchIndex{}, "Value") {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		copies := `SELECT id, first_value(id) OVER (
+			PARTITION BY value ORDER BY created_at, id
+		) AS keep FROM search_index`
+
+		if err := tx.Exec(`INSERT INTO token_urls (search_index_id, crawled_url_id)
+			SELECT DISTINCT c.keep, t.crawled_url_id
+			FROM token_urls t JOIN (` + copies + `) c ON c.id = t.search_index_id
+			WHERE c.id <> c.keep
+			ON CONFLICT DO NOTHING`).Error; err != nil {
+			return err
+		}
+		if err := tx.Exec(`DELETE FROM token_urls WHERE search_index_id IN (
+			SELECT id FROM (` + copies + `) c WHERE c.id <> c.keep
+		)`).Error; err != nil {
+			return err
+		}
+
+		return tx.Exec(`DELETE FROM search_index WHERE id IN (
+			SELECT id FROM (` + copies + `) c WHERE c.id <> c.keep
+		)`).Error
+	})
+}
+
 func GetDB() *gorm.DB {
 	return dbConn
 }
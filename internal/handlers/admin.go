@@ -1,13 +1,18 @@
 package handlers
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"fmt"
+	"html"
 	"os"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/emarifer/search-engine/internal/handlers/dto"
+	"github.com/emarifer/search-engine/internal/search"
 	"github.com/emarifer/search-engine/internal/services"
 	"github.com/emarifer/search-engine/internal/utils"
 	"github.com/emarifer/search-engine/views"
@@ -120,24 +125,117 @@ func (ah *AuthHandler) createAdminHandler(c *fiber.Ctx) error {
 /********** Handlers for Dashboard Views **********/
 
 type SettingsService interface {
-	Get() (services.SearchSettings, error)
-	Upadate(amount uint, searchOn, addNew bool) error
+	Get(ctx context.Context) (services.SearchSettings, error)
+	Upadate(ctx context.Context, input services.SearchSettings) error
 }
 
-func NewSettingsHandler(ss SettingsService) SettingsHandler {
+type CrawlStatsService interface {
+	CountFailures(ctx context.Context) ([]services.FailureCount, error)
+}
+
+type ScheduleService interface {
+	Reload(settings services.SearchSettings) error
+	Next(name string) time.Time
+}
+
+type AccessService interface {
+	Reload(settings services.SearchSettings)
+}
+
+func NewSettingsHandler(
+	ss SettingsService, cs CrawlStatsService, sc ScheduleService, ac AccessService,
+) SettingsHandler {
 
 	return SettingsHandler{
 		SearchConfig: ss,
+		CrawlStats:   cs,
+		Schedules:    sc,
+		Access:       ac,
 	}
 }
 
 type SettingsHandler struct {
 	SearchConfig SettingsService
+	CrawlStats   CrawlStatsService
+	Schedules    ScheduleService
+	Access       AccessService
+}
+
+// scheduleTimeFormat is how the times of the next runs are shown.
+const scheduleTimeFormat = "Mon 2006-01-02 15:04 MST"
+
+// nextRunsPreview describes the next runs of a schedule, which are
+// computed in the time zone of the server, where the jobs are run.
+func nextRunsPreview(spec string) (string, error) {
+	runs, err := utils.NextRuns(spec, time.Now(), 3)
+	if err != nil {
+		return "", err
+	}
+	if len(runs) == 0 {
+		return "never runs", nil
+	}
+
+	times := make([]string, len(runs))
+	for i, r := range runs {
+		times[i] = r.Format(scheduleTimeFormat)
+	}
+
+	return "next runs at " + strings.Join(times, ", "), nil
+}
+
+// settingsInput returns the settings sent by the admin, if they are valid.
+func settingsInput(settings dto.SettingsFormDto) (services.SearchSettings, error) {
+	if settings.Amount == 0 {
+		return services.SearchSettings{}, errors.New("amount cannot be empty")
+	}
+
+	if settings.CrawlTimeout == 0 || settings.RequestTimeout == 0 {
+		return services.SearchSettings{}, errors.New("timeouts cannot be empty")
+	}
+
+	if settings.MaxBodySize == 0 || settings.MaxDomDepth == 0 ||
+		settings.MaxLinks == 0 {
+		return services.SearchSettings{}, errors.New("limits cannot be empty")
+	}
+
+	for _, spec := range []string{settings.CrawlSchedule, settings.IndexSchedule} {
+		if _, err := utils.ParseSchedule(spec); err != nil {
+			return services.SearchSettings{}, err
+		}
+	}
+
+	if settings.AnonymousOn && settings.AnonymousRate == 0 {
+		return services.SearchSettings{}, errors.New("the anonymous rate limit cannot be empty")
+	}
+
+	return services.SearchSettings{
+		Amount:         settings.Amount,
+		SearchOn:       settings.SearchOn,
+		AddNew:         settings.AddNew,
+		CrawlTimeout:   settings.CrawlTimeout,
+		RequestTimeout: settings.RequestTimeout,
+		MaxBodySize:    settings.MaxBodySize,
+		MaxDomDepth:    settings.MaxDomDepth,
+		MaxLinks:       settings.MaxLinks,
+		ScopeOn:        settings.ScopeOn,
+		CrawlSchedule:  strings.TrimSpace(settings.CrawlSchedule),
+		IndexSchedule:  strings.TrimSpace(settings.IndexSchedule),
+		AnonymousOn:    settings.AnonymousOn,
+		AnonymousRate:  settings.AnonymousRate,
+	}, nil
 }
 
 func (sh *SettingsHandler) dashboardHandler(c *fiber.Ctx) error {
 
-	settings, err := sh.SearchConfig.Get()
+	settings, err := sh.SearchConfig.Get(c.UserContext())
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	counts, err := sh.CrawlStats.CountFailures(c.UserContext())
 	if err != nil {
 
 		return c.
@@ -145,9 +243,34 @@ func (sh *SettingsHandler) dashboardHandler(c *fiber.Ctx) error {
 			SendString("✖&nbsp;&nbsp; something went wrong")
 	}
 
-	amount := strconv.FormatUint(uint64(settings.Amount), 10)
+	failures := make([]views.FailureCount, len(counts))
+	for i, fc := range counts {
+		failures[i] = views.FailureCount{
+			Label: fc.Kind.Label(),
+			Count: strconv.FormatInt(fc.Count, 10),
+		}
+	}
 
-	return Render(c, views.Home(amount, settings.SearchOn, settings.AddNew))
+	crawlSchedule := cmp.Or(settings.CrawlSchedule, services.DefaultCrawlSchedule)
+	indexSchedule := cmp.Or(settings.IndexSchedule, services.DefaultIndexSchedule)
+
+	return Render(c, views.Home(views.SettingsForm{
+		Amount:         strconv.FormatUint(uint64(settings.Amount), 10),
+		CrawlTimeout:   strconv.FormatUint(uint64(settings.CrawlTimeout), 10),
+		RequestTimeout: strconv.FormatUint(uint64(settings.RequestTimeout), 10),
+		MaxBodySize:    strconv.FormatUint(uint64(settings.MaxBodySize), 10),
+		MaxDomDepth:    strconv.FormatUint(uint64(settings.MaxDomDepth), 10),
+		MaxLinks:       strconv.FormatUint(uint64(settings.MaxLinks), 10),
+		SearchOn:       settings.SearchOn,
+		AddNew:         settings.AddNew,
+		ScopeOn:        settings.ScopeOn,
+		AnonymousOn:    settings.AnonymousOn,
+		AnonymousRate:  strconv.FormatUint(uint64(settings.AnonymousRate), 10),
+		CrawlSchedule:  crawlSchedule,
+		IndexSchedule:  indexSchedule,
+		CrawlNext:      sh.Schedules.Next(search.JobCrawl).Format(scheduleTimeFormat),
+		IndexNext:      sh.Schedules.Next(search.JobIndex).Format(scheduleTimeFormat),
+	}, failures))
 }
 
 func (sh *SettingsHandler) dashboardPostHandler(c *fiber.Ctx) error {
@@ -161,33 +284,108 @@ func (sh *SettingsHandler) dashboardPostHandler(c *fiber.Ctx) error {
 			SendString("✖&nbsp;&nbsp; something went wrong")
 	}
 
-	if settings.Amount == 0 {
+	input, err := settingsInput(settings)
+	if err != nil {
 
 		return c.
 			Status(fiber.StatusBadRequest).
-			SendString("✖&nbsp;&nbsp; amount cannot be empty")
+			SendString("✖&nbsp;&nbsp; " + html.EscapeString(err.Error()))
 	}
 
-	err := sh.SearchConfig.Upadate(
-		settings.Amount, settings.SearchOn, settings.AddNew,
-	)
-	if err != nil {
+	if err := sh.SearchConfig.Upadate(c.UserContext(), input); err != nil {
 
 		return c.
 			Status(fiber.StatusInternalServerError).
 			SendString("✖&nbsp;&nbsp; something went wrong")
 	}
 
+	// The new schedules are used from now on
+	if err := sh.Schedules.Reload(input); err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; " + err.Error())
+	}
+	// And so is the anonymous access to the search API
+	sh.Access.Reload(input)
+
 	return c.Status(fiber.StatusSeeOther).Redirect("/")
 }
 
-func (ah *AuthHandler) authMiddleware(c *fiber.Ctx) error {
+// schedulePreviewHandler previews the next runs of the
+// schedule of a job while it is edited in the dashboard.
+func (sh *SettingsHandler) schedulePreviewHandler(c *fiber.Ctx) error {
+	spec := c.Query(c.Params("job") + "-schedule")
+
+	preview, err := nextRunsPreview(spec)
+	if err != nil {
+
+		// Shown in place of the preview, not as an error of the form
+		return c.
+			Status(fiber.StatusOK).
+			SendString("✖&nbsp;&nbsp; " + html.EscapeString(err.Error()))
+	}
+
+	return c.SendString(preview)
+}
+
+/********** Handlers for the Settings API **********/
+
+func (sh *SettingsHandler) apiSettingsHandler(c *fiber.Ctx) error {
+	settings, err := sh.SearchConfig.Get(c.UserContext())
+	if err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not get the settings")
+	}
+
+	return apiOK(c, fiber.StatusOK, "Settings", settings)
+}
+
+func (sh *SettingsHandler) apiSettingsPutHandler(c *fiber.Ctx) error {
+	settings := dto.SettingsFormDto{}
+	if err := c.BodyParser(&settings); err != nil {
+
+		return apiFail(c, fiber.StatusBadRequest, "Invalid input")
+	}
+
+	input, err := settingsInput(settings)
+	if err != nil {
+
+		return apiFail(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.UserContext()
+	if err := sh.SearchConfig.Upadate(ctx, input); err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not update the settings")
+	}
+
+	// The new schedules are used from now on
+	if err := sh.Schedules.Reload(input); err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, err.Error())
+	}
+	// And so is the anonymous access to the search API
+	sh.Access.Reload(input)
+
+	saved, err := sh.SearchConfig.Get(ctx)
+	if err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not get the settings")
+	}
+
+	return apiOK(c, fiber.StatusOK, "Settings updated", saved)
+}
+
+/********** Middlewares of the Admin Routes **********/
+
+// hasSession reports whether the request has a valid session cookie.
+func hasSession(c *fiber.Ctx) bool {
 
 	// Get the cookie by name
 	cookie := c.Cookies("admin")
 	if cookie == "" {
-
-		return c.Status(fiber.StatusSeeOther).Redirect("/login")
+		return false
 	}
 
 	// Parse the cookie & check for errors
@@ -199,19 +397,33 @@ func (ah *AuthHandler) authMiddleware(c *fiber.Ctx) error {
 		},
 	)
 	if err != nil {
-
-		return c.Status(fiber.StatusSeeOther).Redirect("/login")
+		return false
 	}
 
 	// Parse the custom claims & check jwt is valid
 	_, ok := token.Claims.(*utils.AuthClaims)
-	if ok && token.Valid {
+
+	return ok && token.Valid
+}
+
+func (ah *AuthHandler) authMiddleware(c *fiber.Ctx) error {
+	if hasSession(c) {
 		return c.Next()
 	}
 
 	return c.Status(fiber.StatusSeeOther).Redirect("/login")
 }
 
+// apiAuthMiddleware is the `authMiddleware` of the API,
+// which fails instead of redirecting to the login page.
+func (ah *AuthHandler) apiAuthMiddleware(c *fiber.Ctx) error {
+	if hasSession(c) {
+		return c.Next()
+	}
+
+	return apiFail(c, fiber.StatusUnauthorized, "Unauthorized")
+}
+
 /*
 Printing Struct Variables in Golang:
 https://www.geeksforgeeks.org/printing-struct-variables-in-golang/
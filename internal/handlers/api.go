@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"strings"
+
+	"github.com/emarifer/search-engine/internal/handlers/dto"
+	"github.com/emarifer/search-engine/internal/search"
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/etag"
+)
+
+/********** Envelope and Routes of the API **********/
+
+// apiPrefix is the path of the current version of the API.
+const apiPrefix = "/api/v1"
+
+// apiError is the error of a failed request to the API.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiResponse is the envelope of every response of the API.
+type apiResponse struct {
+	Success bool      `json:"success"`
+	Message string    `json:"message"`
+	Data    any       `json:"data"`
+	Error   *apiError `json:"error,omitempty"`
+}
+
+// apiErrorCodes are the codes of the errors of the API, by status,
+// which the clients can rely on instead of the messages.
+var apiErrorCodes = map[int]string{
+	fiber.StatusBadRequest:          "invalid_input",
+	fiber.StatusUnauthorized:        "unauthorized",
+	fiber.StatusNotFound:            "not_found",
+	fiber.StatusConflict:            "conflict",
+	fiber.StatusTooManyRequests:     "rate_limited",
+	fiber.StatusInternalServerError: "internal_error",
+}
+
+// apiOK responds with the data of a successful request.
+func apiOK(c *fiber.Ctx, status int, message string, data any) error {
+
+	return c.Status(status).JSON(apiResponse{
+		Success: true,
+		Message: message,
+		Data:    data,
+	})
+}
+
+// apiFail responds with the error of a failed request,
+// which is never cached, since it may be transient.
+func apiFail(c *fiber.Ctx, status int, message string) error {
+	code, ok := apiErrorCodes[status]
+	if !ok {
+		code = apiErrorCodes[fiber.StatusInternalServerError]
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	return c.Status(status).JSON(apiResponse{
+		Success: false,
+		Message: message,
+		Data:    nil,
+		Error:   &apiError{Code: code, Message: message},
+	})
+}
+
+// apiParam is a query parameter of a route of the API.
+type apiParam struct {
+	Name        string
+	Type        string // "string", "integer" or "boolean"
+	Description string
+	Required    bool
+}
+
+// apiRoute is a route of the API, from which both
+// the router and the OpenAPI document are built.
+type apiRoute struct {
+	Method   string
+	Path     string // In Fiber syntax, relative to `apiPrefix`
+	Summary  string
+	Tag      string
+	Admin    bool // Only for the admins, who are logged in
+	Limited  bool // Within the limits of the API key, or the anonymous ones
+	Query    []apiParam
+	Body     any // Example of the body of the request, if any
+	Data     any // Example of the `data` of a successful response
+	Status   int // Of a successful response
+	Errors   []int
+	Handlers []fiber.Handler
+}
+
+// status returns the status of a successful response of the route.
+func (r apiRoute) status() int {
+	if r.Status == 0 {
+		return fiber.StatusOK
+	}
+
+	return r.Status
+}
+
+// pathParams returns the names of the parameters of the path of the route.
+func (r apiRoute) pathParams() []string {
+	params := []string{}
+	for _, segment := range strings.Split(r.Path, "/") {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			params = append(params, name)
+		}
+	}
+
+	return params
+}
+
+// apiRoutes returns the routes of the API, served by the given handlers.
+func apiRoutes(
+	sh SettingsHandler,
+	uh UrlsHandler,
+	jh JobsHandler,
+	sth StatsHandler,
+	sch SearchHandler,
+) []apiRoute {
+	urlQuery := []apiParam{
+		{Name: "status", Type: "string", Description: "success, failed, pending or paused"},
+		{Name: "host", Type: "string", Description: "Host of the urls"},
+		{Name: "indexed", Type: "string", Description: "yes or no"},
+		{Name: "code", Type: "integer", Description: "Response code of the last crawl"},
+		{Name: "sort", Type: "string", Description: "url, lastTested, responseCode or duration"},
+		{Name: "order", Type: "string", Description: "asc or desc"},
+		{Name: "page", Type: "integer", Description: "From 1"},
+	}
+
+	return []apiRoute{
+		{
+			Method:  fiber.MethodGet,
+			Path:    "/search",
+			Summary: "Search the indexed urls, the most relevant first",
+			Tag:     "search",
+			Query: []apiParam{
+				{Name: "q", Type: "string", Description: "Query", Required: true},
+				{Name: "page", Type: "integer", Description: "From 1"},
+				{Name: "size", Type: "integer", Description: "Results per page, up to 50"},
+				{Name: "type", Type: "string", Description: `schema.org type of the results, e.g. "Article"`},
+				{Name: "noCache", Type: "boolean", Description: "Skip the cache of the results"},
+			},
+			Limited:  true,
+			Data:     apiSearchPage{Results: []apiSearchResult{}},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusInternalServerError},
+			Handlers: []fiber.Handler{etag.New(), newSearchCache(), sch.apiSearchHandler},
+		},
+		{
+			Method:  fiber.MethodGet,
+			Path:    "/suggest",
+			Summary: "Suggest a similar query, for the misspelled ones",
+			Tag:     "search",
+			Query: []apiParam{
+				{Name: "q", Type: "string", Description: "Query", Required: true},
+			},
+			Limited:  true,
+			Data:     apiSuggestion{},
+			Errors:   []int{fiber.StatusBadRequest},
+			Handlers: []fiber.Handler{sch.apiSuggestHandler},
+		},
+		{
+			Method:   fiber.MethodGet,
+			Path:     "/urls",
+			Summary:  "List the crawled urls",
+			Tag:      "urls",
+			Admin:    true,
+			Query:    urlQuery,
+			Data:     services.UrlPage{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusInternalServerError},
+			Handlers: []fiber.Handler{uh.apiUrlsHandler},
+		},
+		{
+			Method:   fiber.MethodGet,
+			Path:     "/urls/:id",
+			Summary:  "Get a url, with its terms and its crawl history",
+			Tag:      "urls",
+			Admin:    true,
+			Data:     apiUrlDetail{},
+			Errors:   []int{fiber.StatusNotFound, fiber.StatusInternalServerError},
+			Handlers: []fiber.Handler{uh.apiUrlHandler},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/urls/:id/recrawl",
+			Summary: "Crawl a url again, now, to index it again in the next index run",
+			Tag:     "urls",
+			Admin:   true,
+			Data:    services.CrawledUrl{},
+			Errors: []int{
+				fiber.StatusBadRequest, fiber.StatusNotFound, fiber.StatusInternalServerError,
+			},
+			Handlers: []fiber.Handler{uh.apiUrlRecrawlHandler},
+		},
+		{
+			Method:   fiber.MethodPost,
+			Path:     "/urls/:id/reindex",
+			Summary:  "Index a url again in the next index run",
+			Tag:      "urls",
+			Admin:    true,
+			Status:   fiber.StatusAccepted,
+			Errors:   []int{fiber.StatusInternalServerError},
+			Handlers: []fiber.Handler{uh.apiUrlReindexHandler},
+		},
+		{
+			Method:   fiber.MethodDelete,
+			Path:     "/urls/:id",
+			Summary:  "Delete a url",
+			Tag:      "urls",
+			Admin:    true,
+			Errors:   []int{fiber.StatusInternalServerError},
+			Handlers: []fiber.Handler{uh.apiUrlDeleteHandler},
+		},
+		{
+			Method:   fiber.MethodGet,
+			Path:     "/settings",
+			Summary:  "Get the settings of the engine",
+			Tag:      "settings",
+			Admin:    true,
+			Data:     services.SearchSettings{},
+			Errors:   []int{fiber.StatusInternalServerError},
+			Handlers: []fiber.Handler{sh.apiSettingsHandler},
+		},
+		{
+			Method:  fiber.MethodPut,
+			Path:    "/settings",
+			Summary: "Change the settings of the engine",
+			Tag:     "settings",
+			Admin:   true,
+			Body: dto.SettingsFormDto{
+				Amount:         100,
+				SearchOn:       true,
+				CrawlTimeout:   50,
+				RequestTimeout: 10,
+				MaxBodySize:    10,
+				MaxDomDepth:    512,
+				MaxLinks:       1000,
+				CrawlSchedule:  services.DefaultCrawlSchedule,
+				IndexSchedule:  services.DefaultIndexSchedule,
+				AnonymousOn:    true,
+				AnonymousRate:  10,
+			},
+			Data:     services.SearchSettings{},
+			Errors:   []int{fiber.StatusBadRequest, fiber.StatusInternalServerError},
+			Handlers: []fiber.Handler{sh.apiSettingsPutHandler},
+		},
+		{
+			Method:  fiber.MethodGet,
+			Path:    "/jobs",
+			Summary: "List the latest runs of the jobs",
+			Tag:     "jobs",
+			Admin:   true,
+			Query: []apiParam{
+				{Name: "limit", Type: "integer", Description: "Runs listed, up to 100"},
+			},
+			Data:     []services.Job{},
+			Errors:   []int{fiber.StatusInternalServerError},
+			Handlers: []fiber.Handler{jh.apiJobsHandler},
+		},
+		{
+			Method:  fiber.MethodPost,
+			Path:    "/jobs/:name",
+			Summary: "Run a job (" + search.JobCrawl + ", " + search.JobIndex + " or " + search.JobFeeds + ") now",
+			Tag:     "jobs",
+			Admin:   true,
+			Status:  fiber.StatusAccepted,
+			Data:    apiJobRef{},
+			Errors: []int{
+				fiber.StatusNotFound, fiber.StatusConflict, fiber.StatusInternalServerError,
+			},
+			Handlers: []fiber.Handler{jh.apiJobRunHandler},
+		},
+		{
+			Method:   fiber.MethodGet,
+			Path:     "/stats",
+			Summary:  "Get the stats of the crawl and the index",
+			Tag:      "stats",
+			Admin:    true,
+			Data:     crawlStats{},
+			Errors:   []int{fiber.StatusInternalServerError},
+			Handlers: []fiber.Handler{sth.apiStatsHandler},
+		},
+	}
+}
+
+// setApiRoutes sets the routes of the API and its OpenAPI document.
+func setApiRoutes(app *fiber.App, ah AuthHandler, kh ApiKeysHandler, routes []apiRoute) {
+	api := app.Group(apiPrefix)
+	for _, r := range routes {
+		handlers := r.Handlers
+		if r.Admin {
+			handlers = append([]fiber.Handler{ah.apiAuthMiddleware}, handlers...)
+		}
+		if r.Limited { // Before the cache, so that the cached responses count too
+			handlers = append([]fiber.Handler{kh.apiKeyMiddleware}, handlers...)
+		}
+		api.Add(r.Method, r.Path, handlers...)
+	}
+	api.Get("/openapi.json", openApiHandler(routes))
+
+	// The unknown routes of the API are answered as its errors
+	api.Use(func(c *fiber.Ctx) error {
+
+		return apiFail(c, fiber.StatusNotFound, "Not Found")
+	})
+}
+
+/* OPENAPI SPECIFICATION:
+https://spec.openapis.org/oas/v3.0.3
+https://docs.gofiber.io/guide/grouping
+*/
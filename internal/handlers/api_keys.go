@@ -0,0 +1,248 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/handlers/dto"
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/emarifer/search-engine/views"
+	"github.com/gofiber/fiber/v2"
+)
+
+/********** Handlers for API Keys Views **********/
+
+type ApiKeyService interface {
+	GetAll(ctx context.Context) ([]services.ApiKey, error)
+	GetByKey(ctx context.Context, key string) (services.ApiKey, error)
+	Save(ctx context.Context, input *services.ApiKey) error
+	Revoke(ctx context.Context, id uint) error
+	RecordUsage(ctx context.Context, id uint, at time.Time) (bool, error)
+}
+
+type RateLimiter interface {
+	Allow(client string, perMinute, burst int) (bool, time.Duration)
+}
+
+// AnonymousAccess is the access to the search API without
+// a key, as set in the settings, which can be changed live.
+type AnonymousAccess struct {
+	mu        sync.RWMutex
+	allowed   bool
+	perMinute int
+}
+
+func NewAnonymousAccess(settings services.SearchSettings) *AnonymousAccess {
+	a := &AnonymousAccess{}
+	a.Reload(settings)
+
+	return a
+}
+
+// Reload applies the changed settings to the next requests.
+func (a *AnonymousAccess) Reload(settings services.SearchSettings) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.allowed = settings.AnonymousOn
+	a.perMinute = int(settings.AnonymousRate)
+}
+
+// limits returns whether the access is allowed, and its rate limit.
+func (a *AnonymousAccess) limits() (bool, int) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.allowed, a.perMinute
+}
+
+func NewApiKeysHandler(
+	ks ApiKeyService, rl RateLimiter, aa *AnonymousAccess,
+) ApiKeysHandler {
+
+	return ApiKeysHandler{
+		Keys:      ks,
+		Limiter:   rl,
+		Anonymous: aa,
+	}
+}
+
+type ApiKeysHandler struct {
+	Keys      ApiKeyService
+	Limiter   RateLimiter
+	Anonymous *AnonymousAccess
+}
+
+// apiKeyHeader is the header of the requests that carries the API key.
+const apiKeyHeader = "X-API-Key"
+
+// renderApiKeys renders the page of the keys, along with
+// the key just created, which is never shown again.
+func (kh *ApiKeysHandler) renderApiKeys(c *fiber.Ctx, created string) error {
+	keys, err := kh.Keys.GetAll(c.UserContext())
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	today := time.Now().Format(time.DateOnly)
+	rows := make([]views.ApiKeyRow, len(keys))
+	for i, k := range keys {
+		usageToday := 0
+		if k.UsageDay == today {
+			usageToday = k.UsageToday
+		}
+		quota := "unlimited"
+		if k.DailyQuota > 0 {
+			quota = strconv.Itoa(k.DailyQuota)
+		}
+
+		rows[i] = views.ApiKeyRow{
+			ID:         strconv.FormatUint(uint64(k.ID), 10),
+			Name:       k.Name,
+			Prefix:     k.Prefix,
+			RateLimit:  strconv.Itoa(k.RateLimit),
+			Burst:      strconv.Itoa(k.Burst),
+			DailyQuota: quota,
+			UsageToday: strconv.Itoa(usageToday),
+			UsageCount: strconv.FormatInt(k.UsageCount, 10),
+			LastUsedAt: formatTime(k.LastUsedAt),
+			Revoked:    k.RevokedAt != nil,
+		}
+	}
+
+	return Render(c, views.ApiKeys(rows, created))
+}
+
+func (kh *ApiKeysHandler) apiKeysHandler(c *fiber.Ctx) error {
+
+	return kh.renderApiKeys(c, "")
+}
+
+func (kh *ApiKeysHandler) apiKeysPostHandler(c *fiber.Ctx) error {
+	form := dto.ApiKeyFormDto{}
+	if err := c.BodyParser(&form); err != nil {
+
+		return c.
+			Status(fiber.StatusBadRequest).
+			SendString("✖&nbsp;&nbsp; the limits must be numbers")
+	}
+
+	apiKey, key, err := services.NewApiKey(
+		form.Name, form.RateLimit, form.Burst, form.DailyQuota,
+	)
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusBadRequest).
+			SendString("✖&nbsp;&nbsp; " + err.Error())
+	}
+
+	if err := kh.Keys.Save(c.UserContext(), &apiKey); err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	return kh.renderApiKeys(c, key)
+}
+
+func (kh *ApiKeysHandler) apiKeyRevokeHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusBadRequest).
+			SendString("✖&nbsp;&nbsp; invalid api key")
+	}
+
+	if err := kh.Keys.Revoke(c.UserContext(), uint(id)); err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	return c.Status(fiber.StatusSeeOther).Redirect("/api-keys")
+}
+
+/********** Middleware of the Search API **********/
+
+// tooManyRequests fails a request over the limits,
+// telling the client when it can make it again.
+func tooManyRequests(c *fiber.Ctx, wait time.Duration, message string) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+
+	return apiFail(c, fiber.StatusTooManyRequests, message)
+}
+
+// untilTomorrow returns the time left until the next day of the server.
+func untilTomorrow(now time.Time) time.Duration {
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+
+	return tomorrow.Sub(now)
+}
+
+// apiKeyMiddleware limits the requests to the search API: those with a
+// key, to its rate limit and daily quota, and those without one, to the
+// anonymous rate limit of each client (IP), if they are allowed at all.
+func (kh *ApiKeysHandler) apiKeyMiddleware(c *fiber.Ctx) error {
+	key := c.Get(apiKeyHeader)
+	if key == "" {
+		allowed, perMinute := kh.Anonymous.limits()
+		if !allowed {
+
+			return apiFail(c, fiber.StatusUnauthorized, "An API key is required")
+		}
+
+		if ok, wait := kh.Limiter.Allow("ip:"+c.IP(), perMinute, perMinute); !ok {
+
+			return tooManyRequests(c, wait, "Rate limit exceeded")
+		}
+
+		return c.Next()
+	}
+
+	ctx := c.UserContext()
+	apiKey, err := kh.Keys.GetByKey(ctx, key)
+	if errors.Is(err, services.ErrApiKeyNotFound) {
+
+		return apiFail(c, fiber.StatusUnauthorized, "Invalid API key")
+	}
+	if err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not check the API key")
+	}
+
+	client := "key:" + strconv.FormatUint(uint64(apiKey.ID), 10)
+	if ok, wait := kh.Limiter.Allow(client, apiKey.RateLimit, apiKey.Burst); !ok {
+
+		return tooManyRequests(c, wait, "Rate limit exceeded")
+	}
+
+	now := time.Now()
+	withinQuota, err := kh.Keys.RecordUsage(ctx, apiKey.ID, now)
+	if err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not check the API key")
+	}
+	if !withinQuota {
+
+		return tooManyRequests(c, untilTomorrow(now), "Daily quota exceeded")
+	}
+
+	return c.Next()
+}
+
+/* RATE LIMITING:
+https://www.rfc-editor.org/rfc/rfc6585#section-4
+https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Retry-After
+https://docs.gofiber.io/api/ctx#ip
+*/
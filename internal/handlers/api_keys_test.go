@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/emarifer/search-engine/internal/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeApiKeys struct {
+	keys  map[string]services.ApiKey
+	usage map[uint]int
+}
+
+func (f *fakeApiKeys) GetAll(ctx context.Context) ([]services.ApiKey, error) {
+	keys := []services.ApiKey{}
+	for _, k := range f.keys {
+		keys = append(keys, k)
+	}
+
+	return keys, nil
+}
+
+func (f *fakeApiKeys) GetByKey(ctx context.Context, key string) (services.ApiKey, error) {
+	k, ok := f.keys[key]
+	if !ok || k.RevokedAt != nil {
+		return services.ApiKey{}, services.ErrApiKeyNotFound
+	}
+
+	return k, nil
+}
+
+func (f *fakeApiKeys) Save(ctx context.Context, input *services.ApiKey) error {
+	input.ID = uint(len(f.keys) + 1)
+	f.keys[input.Prefix] = *input
+
+	return nil
+}
+
+func (f *fakeApiKeys) Revoke(ctx context.Context, id uint) error { return nil }
+
+func (f *fakeApiKeys) RecordUsage(ctx context.Context, id uint, at time.Time) (bool, error) {
+	for _, k := range f.keys {
+		if k.ID == id && k.DailyQuota > 0 && f.usage[id] >= k.DailyQuota {
+			return false, nil
+		}
+	}
+	f.usage[id]++
+
+	return true, nil
+}
+
+// newKeysHandler returns the handler limiting the search API with
+// fake keys, and the anonymous access allowed with the given rate.
+func newKeysHandler(anonymousRate uint) (ApiKeysHandler, *fakeApiKeys) {
+	keys := &fakeApiKeys{
+		keys: map[string]services.ApiKey{
+			"se_limited": {ID: 1, RateLimit: 1, Burst: 2},
+			"se_quota":   {ID: 2, RateLimit: 60, Burst: 10, DailyQuota: 1},
+		},
+		usage: map[uint]int{},
+	}
+	access := NewAnonymousAccess(services.SearchSettings{
+		AnonymousOn: true, AnonymousRate: anonymousRate,
+	})
+
+	return NewApiKeysHandler(keys, utils.NewRateLimiter(), access), keys
+}
+
+// sendWithKey sends a request to the search API with the given key, if any.
+func sendWithKey(t *testing.T, app *fiber.App, key string) (*fiberResponse, string) {
+	t.Helper()
+
+	req := httptest.NewRequest("GET", "/api/v1/search?q=go", nil)
+	if key != "" {
+		req.Header.Set(apiKeyHeader, key)
+	}
+	res, err := app.Test(req)
+	require.NoError(t, err)
+
+	var decoded any
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&decoded))
+
+	return &fiberResponse{status: res.StatusCode, body: decoded}, res.Header.Get(fiber.HeaderRetryAfter)
+}
+
+func TestApiKeyMiddleware(t *testing.T) {
+	kh, keys := newKeysHandler(2)
+	app, _ := newApiApp(kh)
+	doc := getDocument(t, app)
+
+	// The anonymous clients have their own limit
+	for range 2 {
+		res, _ := sendWithKey(t, app, "")
+		assert.Equal(t, fiber.StatusOK, res.status)
+	}
+	res, retryAfter := sendWithKey(t, app, "")
+	assert.Equal(t, fiber.StatusTooManyRequests, res.status)
+	assert.Equal(t, "rate_limited", child(res.body, "error", "code"))
+	assert.Equal(t, "30", retryAfter)
+	validateResponse(t, doc, "/search", "get", res)
+
+	// And so does each key, which is not limited by the anonymous one
+	for range 2 {
+		res, _ := sendWithKey(t, app, "se_limited")
+		assert.Equal(t, fiber.StatusOK, res.status)
+	}
+	res, retryAfter = sendWithKey(t, app, "se_limited")
+	assert.Equal(t, fiber.StatusTooManyRequests, res.status)
+	assert.Equal(t, "60", retryAfter)
+	assert.Equal(t, 2, keys.usage[1], "the limited requests are not counted")
+
+	// Until the quota of the day is exceeded
+	res, _ = sendWithKey(t, app, "se_quota")
+	assert.Equal(t, fiber.StatusOK, res.status)
+	res, retryAfter = sendWithKey(t, app, "se_quota")
+	assert.Equal(t, fiber.StatusTooManyRequests, res.status)
+	assert.Equal(t, "Daily quota exceeded", child(res.body, "message"))
+	seconds, err := strconv.Atoi(retryAfter)
+	require.NoError(t, err)
+	assert.True(t, seconds > 0 && seconds <= 25*60*60, retryAfter)
+
+	// The unknown keys are rejected
+	res, _ = sendWithKey(t, app, "se_nope")
+	assert.Equal(t, fiber.StatusUnauthorized, res.status)
+	validateResponse(t, doc, "/search", "get", res)
+}
+
+func TestApiKeyMiddlewareAnonymousOff(t *testing.T) {
+	t.Setenv("SECRET_KEY", "secret")
+	kh, _ := newKeysHandler(10)
+	app, routes := newApiApp(kh)
+
+	// The anonymous access is disabled as soon as the settings are changed
+	var put apiRoute
+	for _, r := range routes {
+		if r.Method == fiber.MethodPut && r.Path == "/settings" {
+			put = r
+		}
+	}
+	body := map[string]any{}
+	b, err := json.Marshal(put.Body)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, &body))
+	body["anonymousOn"] = false
+
+	res := send(t, app, "PUT", "/api/v1/settings", body, true)
+	require.Equal(t, fiber.StatusOK, res.status)
+
+	res, _ = sendWithKey(t, app, "")
+	assert.Equal(t, fiber.StatusUnauthorized, res.status)
+	assert.Equal(t, "unauthorized", child(res.body, "error", "code"))
+
+	res, _ = sendWithKey(t, app, "se_quota")
+	assert.Equal(t, fiber.StatusOK, res.status)
+}
@@ -5,8 +5,49 @@ type LoginFormDto struct {
 	Passsword string `form:"password"`
 }
 
+// SettingsFormDto is also the body of the settings API.
 type SettingsFormDto struct {
-	Amount   uint `form:"amount"`
-	SearchOn bool `form:"search-on"`
-	AddNew   bool `form:"add-new"`
+	Amount         uint   `form:"amount" json:"amount"`
+	SearchOn       bool   `form:"search-on" json:"searchOn"`
+	AddNew         bool   `form:"add-new" json:"addNew"`
+	CrawlTimeout   uint   `form:"crawl-timeout" json:"crawlTimeout"`
+	RequestTimeout uint   `form:"request-timeout" json:"requestTimeout"`
+	MaxBodySize    uint   `form:"max-body-size" json:"maxBodySize"`
+	MaxDomDepth    uint   `form:"max-dom-depth" json:"maxDomDepth"`
+	MaxLinks       uint   `form:"max-links" json:"maxLinks"`
+	ScopeOn        bool   `form:"scope-on" json:"scopeOn"`
+	CrawlSchedule  string `form:"crawl-schedule" json:"crawlSchedule"`
+	IndexSchedule  string `form:"index-schedule" json:"indexSchedule"`
+	AnonymousOn    bool   `form:"anonymous-on" json:"anonymousOn"`
+	AnonymousRate  uint   `form:"anonymous-rate" json:"anonymousRate"`
+}
+
+type RuleFormDto struct {
+	Kind    string `form:"kind"`
+	Pattern string `form:"pattern"`
+	Action  string `form:"action"` // "allow" or "deny"
+}
+
+type SearchQueryDto struct {
+	Query string `query:"q"`
+	Page  int    `query:"page"`
+	Size  int    `query:"size"` // Only used by the search API
+	Type  string `query:"type"` // Only used by the search API
+}
+
+type UrlQueryDto struct {
+	Status  string `query:"status"`
+	Host    string `query:"host"`
+	Indexed string `query:"indexed"` // "yes", "no" or empty
+	Code    int    `query:"code"`
+	Sort    string `query:"sort"`
+	Order   string `query:"order"` // "asc" or "desc"
+	Page    int    `query:"page"`
+}
+
+type ApiKeyFormDto struct {
+	Name       string `form:"name"`
+	RateLimit  int    `form:"rate-limit"`
+	Burst      int    `form:"burst"`
+	DailyQuota int    `form:"daily-quota"`
 }
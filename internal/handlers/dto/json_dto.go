@@ -2,4 +2,5 @@ package dto
 
 type SearchJsonDto struct {
 	Term string `json:"term"`
+	Type string `json:"type"` // schema.org type of the results, e.g. "Article"
 }
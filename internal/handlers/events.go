@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/search"
+	"github.com/emarifer/search-engine/views"
+	"github.com/gofiber/fiber/v2"
+)
+
+/********** Handlers for the Progress of the Engine **********/
+
+type EventSource interface {
+	Subscribe(buffer int) (<-chan search.Event, func())
+}
+
+func NewEventsHandler(es EventSource) EventsHandler {
+
+	return EventsHandler{
+		Events: es,
+	}
+}
+
+type EventsHandler struct {
+	Events EventSource
+}
+
+const (
+	eventsBuffer      = 256              // Events kept for a slow browser
+	eventsHeartbeat   = 15 * time.Second // Keeps the stream open behind proxies
+	eventsMessageName = "progress"       // Name of the SSE messages in the dashboard
+)
+
+// eventRow returns the line of the dashboard that shows the event.
+func eventRow(ev search.Event) views.EventRow {
+	row := views.EventRow{
+		Time:    ev.Time.Format(time.TimeOnly),
+		Run:     ev.Run,
+		Kind:    strings.ReplaceAll(string(ev.Kind), "_", " "),
+		Url:     ev.Url,
+		Message: ev.Message,
+		Failed:  ev.Kind == search.EventUrlFailed,
+	}
+
+	switch ev.Kind {
+	case search.EventRunStarted:
+		row.Message = fmt.Sprintf("%d urls queued", ev.Count)
+	case search.EventUrlFetched:
+		row.Message = fmt.Sprintf("%d", ev.Count)
+	case search.EventUrlFailed:
+		if ev.Count != 0 {
+			row.Message = fmt.Sprintf("%d %s", ev.Count, ev.Message)
+		}
+	case search.EventUrlsAdded:
+		row.Message = fmt.Sprintf("%d new urls %s", ev.Count, ev.Message)
+	}
+
+	return row
+}
+
+// eventsHandler streams the events of the engine as
+// Server-Sent Events, until the browser leaves the dashboard
+// or the server is shut down.
+func (eh *EventsHandler) eventsHandler(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	events, unsubscribe := eh.Events.Subscribe(eventsBuffer)
+
+	// ↓ See NOTE-02 below ↓
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+
+		heartbeat := time.NewTicker(eventsHeartbeat)
+		defer heartbeat.Stop()
+
+		// Tells the browser that the stream is open
+		fmt.Fprint(w, ": connected\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		var html bytes.Buffer
+		for {
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					return
+				}
+
+				html.Reset()
+				err := views.EventLine(eventRow(ev)).
+					Render(context.Background(), &html)
+				if err != nil {
+					log.Println("the event could not be rendered:", err)
+					continue
+				}
+				fmt.Fprintf(w, "event: %s\n", eventsMessageName)
+				for _, line := range strings.Split(html.String(), "\n") {
+					fmt.Fprintf(w, "data: %s\n", line)
+				}
+				fmt.Fprint(w, "\n")
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			}
+
+			// Fails when the browser has closed the connection
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+	})
+
+	return nil
+}
+
+/* NOTE-02.- SERVER-SENT EVENTS WITH FIBER:
+https://github.com/gofiber/recipes/tree/master/sse
+https://developer.mozilla.org/en-US/docs/Web/API/Server-sent_events/Using_server-sent_events
+https://v1.htmx.org/attributes/hx-sse/
+*/
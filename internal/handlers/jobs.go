@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/search"
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/emarifer/search-engine/views"
+	"github.com/gofiber/fiber/v2"
+)
+
+/********** Handlers for the Jobs of the Engine **********/
+
+type JobRunner interface {
+	Start(name, trigger string) error
+	IsRunning(name string) bool
+}
+
+type JobHistoryService interface {
+	GetRecent(ctx context.Context, limit int) ([]services.Job, error)
+}
+
+func NewJobsHandler(jr JobRunner, js JobHistoryService) JobsHandler {
+
+	return JobsHandler{
+		Runner:  jr,
+		History: js,
+	}
+}
+
+type JobsHandler struct {
+	Runner  JobRunner
+	History JobHistoryService
+}
+
+// jobsShown is the number of runs shown in the history of the dashboard.
+const jobsShown = 20
+
+// jobButtons are the jobs that the admin can run from the dashboard.
+var jobButtons = []views.JobButton{
+	{Name: search.JobCrawl, Label: "Crawl now"},
+	{Name: search.JobIndex, Label: "Index now"},
+}
+
+// jobCounts sums up what the run did.
+func jobCounts(j services.Job) string {
+	counts := []string{}
+	add := func(n int, what string) {
+		if n != 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, what))
+		}
+	}
+	add(j.Queued, "queued")
+	add(j.Fetched, "fetched")
+	add(j.Failed, "failed")
+	add(j.Added, "added")
+	add(j.Indexed, "indexed")
+
+	return strings.Join(counts, ", ")
+}
+
+// renderJobs renders the jobs panel of the dashboard.
+func (jh *JobsHandler) renderJobs(c *fiber.Ctx) error {
+	jobs, err := jh.History.GetRecent(c.UserContext(), jobsShown)
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	buttons := make([]views.JobButton, len(jobButtons))
+	for i, b := range jobButtons {
+		b.Running = jh.Runner.IsRunning(b.Name)
+		buttons[i] = b
+	}
+
+	rows := make([]views.JobRow, len(jobs))
+	for i, j := range jobs {
+		duration := "-"
+		if j.FinishedAt != nil {
+			duration = j.Duration.Round(time.Millisecond).String()
+		}
+		rows[i] = views.JobRow{
+			Name:      j.Name,
+			Trigger:   j.Trigger,
+			Status:    string(j.Status),
+			StartedAt: j.StartedAt.Format(time.DateTime),
+			Duration:  duration,
+			Counts:    jobCounts(j),
+			Error:     j.Error,
+		}
+	}
+
+	return Render(c, views.JobsPanel(buttons, rows))
+}
+
+// startJobError returns the status and the message
+// of an error starting a run of a job.
+func startJobError(err error) (int, string) {
+	switch {
+	case errors.Is(err, search.ErrJobRunning):
+		return fiber.StatusConflict, err.Error()
+	case errors.Is(err, search.ErrJobUnknown):
+		return fiber.StatusNotFound, err.Error()
+	}
+
+	return fiber.StatusInternalServerError, "something went wrong"
+}
+
+func (jh *JobsHandler) jobsHandler(c *fiber.Ctx) error {
+
+	return jh.renderJobs(c)
+}
+
+func (jh *JobsHandler) jobRunHandler(c *fiber.Ctx) error {
+	if err := jh.Runner.Start(c.Params("name"), services.TriggerManual); err != nil {
+		status, message := startJobError(err)
+
+		return c.
+			Status(status).
+			SendString("✖&nbsp;&nbsp; " + message)
+	}
+
+	return jh.renderJobs(c)
+}
+
+/********** Jobs API **********/
+
+func (jh *JobsHandler) apiJobsHandler(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", jobsShown)
+	if limit < 1 || limit > 100 {
+		limit = jobsShown
+	}
+
+	jobs, err := jh.History.GetRecent(c.UserContext(), limit)
+	if err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not get the jobs")
+	}
+
+	return apiOK(c, fiber.StatusOK, "Latest jobs", jobs)
+}
+
+// apiJobRef is the job whose run was started.
+type apiJobRef struct {
+	Name string `json:"name"`
+}
+
+func (jh *JobsHandler) apiJobRunHandler(c *fiber.Ctx) error {
+	name := c.Params("name")
+
+	if err := jh.Runner.Start(name, services.TriggerManual); err != nil {
+		status, message := startJobError(err)
+
+		return apiFail(c, status, message)
+	}
+
+	return apiOK(c, fiber.StatusAccepted, "Job started", apiJobRef{Name: name})
+}
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"gorm.io/gorm"
+)
+
+/********** OpenAPI Document of the API **********/
+
+// schema is a JSON schema, as used by OpenAPI 3.0.
+type schema = map[string]any
+
+// knownSchemas are the schemas of the types that are encoded by
+// their own `MarshalJSON`, instead of by their fields.
+var knownSchemas = map[reflect.Type]func() schema{
+	reflect.TypeFor[time.Time](): func() schema {
+		return schema{"type": "string", "format": "date-time"}
+	},
+	reflect.TypeFor[gorm.DeletedAt](): func() schema {
+		return schema{"type": "string", "format": "date-time", "nullable": true}
+	},
+}
+
+// schemaBuilder builds the schemas of the Go types as they are
+// encoded to JSON, with the structs as reusable components.
+type schemaBuilder struct {
+	components map[string]schema
+	types      map[string]reflect.Type
+}
+
+func newSchemaBuilder() *schemaBuilder {
+
+	return &schemaBuilder{
+		components: map[string]schema{},
+		types:      map[string]reflect.Type{},
+	}
+}
+
+// schemaOf returns the schema of the values of the type.
+func (b *schemaBuilder) schemaOf(t reflect.Type) schema {
+	if known, ok := knownSchemas[t]; ok {
+		return known()
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := b.schemaOf(t.Elem())
+		if _, ok := s["$ref"]; ok {
+			return schema{"allOf": []any{s}, "nullable": true}
+		}
+		s["nullable"] = true
+
+		return s
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return schema{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return schema{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return schema{"type": "string", "format": "byte"}
+		}
+
+		// The nil slices are encoded as null
+		return schema{"type": "array", "items": b.schemaOf(t.Elem()), "nullable": true}
+	case reflect.Map:
+		return schema{
+			"type":                 "object",
+			"additionalProperties": b.schemaOf(t.Elem()),
+			"nullable":             true,
+		}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return b.structSchema(t)
+		}
+
+		return b.component(t)
+	}
+
+	return schema{} // Any value
+}
+
+// component returns the reference to the schema of
+// the struct, which is added to the components once.
+func (b *schemaBuilder) component(t reflect.Type) schema {
+	name := strings.ToUpper(t.Name()[:1]) + t.Name()[1:]
+	ref := schema{"$ref": "#/components/schemas/" + name}
+
+	if other, ok := b.types[name]; ok {
+		if other != t {
+			panic("two types have the same schema name: " + name)
+		}
+
+		return ref
+	}
+	b.types[name] = t
+	b.components[name] = b.structSchema(t)
+
+	return ref
+}
+
+// structSchema returns the schema of the struct, whose
+// embedded structs are flattened, as `encoding/json` does.
+func (b *schemaBuilder) structSchema(t reflect.Type) schema {
+	properties := schema{}
+	required := []string{}
+
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for _, f := range reflect.VisibleFields(t) {
+			if !f.IsExported() || len(f.Index) > 1 {
+				continue
+			}
+
+			tag := f.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name, options, _ := strings.Cut(tag, ",")
+			if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+				addFields(f.Type)
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+
+			properties[name] = b.schemaOf(f.Type)
+			if !slices.Contains(strings.Split(options, ","), "omitempty") {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	s := schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+
+	return s
+}
+
+// envelope returns the schema of a response of the API with the given data.
+func envelope(data schema) schema {
+
+	return schema{
+		"type":     "object",
+		"required": []string{"success", "message", "data"},
+		"properties": schema{
+			"success": schema{"type": "boolean"},
+			"message": schema{"type": "string"},
+			"data":    data,
+		},
+	}
+}
+
+// dataSchema returns the schema of the data of a response, given an example.
+func (b *schemaBuilder) dataSchema(example any) schema {
+	if example == nil {
+		return schema{"nullable": true}
+	}
+
+	return b.schemaOf(reflect.TypeOf(example))
+}
+
+// openApiPath returns the path of the route in OpenAPI syntax.
+func openApiPath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, s := range segments {
+		if name, ok := strings.CutPrefix(s, ":"); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+// openApiDocument returns the OpenAPI 3 document of the routes of the API.
+func openApiDocument(routes []apiRoute) schema {
+	b := newSchemaBuilder()
+
+	codes := []string{}
+	for _, code := range apiErrorCodes {
+		codes = append(codes, code)
+	}
+	slices.Sort(codes)
+
+	errorResponse := schema{
+		"description": "Error",
+		"content": schema{
+			fiber.MIMEApplicationJSON: schema{
+				"schema": schema{"$ref": "#/components/schemas/ErrorResponse"},
+			},
+		},
+	}
+	tooManyResponse := schema{
+		"description": "Rate limit or daily quota exceeded",
+		"headers": schema{
+			fiber.HeaderRetryAfter: schema{
+				"description": "Seconds until the request can be made again",
+				"schema":      schema{"type": "integer"},
+			},
+		},
+		"content": errorResponse["content"],
+	}
+
+	paths := schema{}
+	for _, r := range routes {
+		path := openApiPath(r.Path)
+		item, ok := paths[path].(schema)
+		if !ok {
+			item = schema{}
+			paths[path] = item
+		}
+
+		parameters := []any{}
+		for _, name := range r.pathParams() {
+			parameters = append(parameters, schema{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   schema{"type": "string"},
+			})
+		}
+		for _, p := range r.Query {
+			parameters = append(parameters, schema{
+				"name":        p.Name,
+				"in":          "query",
+				"description": p.Description,
+				"required":    p.Required,
+				"schema":      schema{"type": p.Type},
+			})
+		}
+
+		responses := schema{
+			strconv.Itoa(r.status()): schema{
+				"description": http.StatusText(r.status()),
+				"content": schema{
+					fiber.MIMEApplicationJSON: schema{"schema": envelope(b.dataSchema(r.Data))},
+				},
+			},
+		}
+		failures := r.Errors
+		if r.Admin {
+			failures = append([]int{fiber.StatusUnauthorized}, failures...)
+		}
+		if r.Limited {
+			failures = append([]int{fiber.StatusUnauthorized}, failures...)
+		}
+		for _, status := range failures {
+			responses[strconv.Itoa(status)] = errorResponse
+		}
+		if r.Limited {
+			responses[strconv.Itoa(fiber.StatusTooManyRequests)] = tooManyResponse
+		}
+
+		operation := schema{
+			"summary":    r.Summary,
+			"tags":       []string{r.Tag},
+			"parameters": parameters,
+			"responses":  responses,
+		}
+		if r.Body != nil {
+			operation["requestBody"] = schema{
+				"required": true,
+				"content": schema{
+					fiber.MIMEApplicationJSON: schema{
+						"schema":  b.schemaOf(reflect.TypeOf(r.Body)),
+						"example": r.Body,
+					},
+				},
+			}
+		}
+		if r.Admin {
+			operation["security"] = []any{schema{"adminSession": []string{}}}
+		}
+		if r.Limited { // The key is optional while the anonymous access is allowed
+			operation["security"] = []any{schema{"apiKey": []string{}}, schema{}}
+		}
+		item[strings.ToLower(r.Method)] = operation
+	}
+
+	b.components["ApiError"] = schema{
+		"type":     "object",
+		"required": []string{"code", "message"},
+		"properties": schema{
+			"code":    schema{"type": "string", "enum": codes},
+			"message": schema{"type": "string"},
+		},
+	}
+	b.components["ErrorResponse"] = envelope(schema{"nullable": true})
+	errorProperties := b.components["ErrorResponse"]["properties"].(schema)
+	errorProperties["error"] = schema{"$ref": "#/components/schemas/ApiError"}
+	b.components["ErrorResponse"]["required"] = []string{"success", "message", "data", "error"}
+
+	return schema{
+		"openapi": "3.0.3",
+		"info": schema{
+			"title":       "Search Engine API",
+			"version":     "1.0.0",
+			"description": "Every response is wrapped in a `{success, message, data}` envelope, along with an `error` when it fails.",
+		},
+		"servers": []any{schema{"url": apiPrefix}},
+		"paths":   paths,
+		"components": schema{
+			"schemas": b.components,
+			"securitySchemes": schema{
+				"adminSession": schema{
+					"type":        "apiKey",
+					"in":          "cookie",
+					"name":        "admin",
+					"description": "Session of an admin, set when logging in to the dashboard",
+				},
+				"apiKey": schema{
+					"type":        "apiKey",
+					"in":          "header",
+					"name":        apiKeyHeader,
+					"description": "Key created in the dashboard, which sets its rate limit and daily quota",
+				},
+			},
+		},
+	}
+}
+
+// openApiHandler serves the OpenAPI document of the routes, which
+// is built once, since the routes do not change while running.
+func openApiHandler(routes []apiRoute) fiber.Handler {
+	document, err := json.Marshal(openApiDocument(routes))
+	if err != nil {
+		panic("the OpenAPI document cannot be encoded: " + err.Error())
+	}
+
+	return func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+
+		return c.Send(document)
+	}
+}
@@ -0,0 +1,404 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/search"
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/emarifer/search-engine/internal/utils"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testTime = time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+type fakeUrls struct{}
+
+func (fakeUrls) url() services.CrawledUrl {
+	return services.CrawledUrl{
+		ID:           "1",
+		Url:          "https://go.dev/",
+		Success:      true,
+		ResponseCode: 200,
+		PageTitle:    "The Go Programming Language",
+		StructuredData: services.StructuredData{
+			Types:     []string{"WebSite"},
+			OpenGraph: map[string]string{"og:title": "Go"},
+			JsonLd:    []map[string]any{{"@type": "WebSite"}},
+		},
+		LastTested: &testTime,
+		Indexed:    true,
+		CreatedAt:  testTime,
+	}
+}
+
+func (f fakeUrls) List(ctx context.Context, filter services.UrlFilter) (services.UrlPage, error) {
+	return services.UrlPage{Urls: []services.CrawledUrl{f.url()}, Total: 1, Page: 1, PageSize: 50}, nil
+}
+
+func (f fakeUrls) GetById(ctx context.Context, id string) (services.CrawledUrl, error) {
+	if id != "1" {
+		return services.CrawledUrl{}, errors.New("record not found")
+	}
+
+	return f.url(), nil
+}
+
+func (fakeUrls) GetTerms(ctx context.Context, id string) ([]string, error) {
+	return []string{"go", "program"}, nil
+}
+
+func (fakeUrls) GetAttempts(ctx context.Context, id string) ([]services.CrawlAttempt, error) {
+	return []services.CrawlAttempt{{
+		ID: 1, CrawledUrlID: id, Success: true, ResponseCode: 200, CrawledAt: testTime,
+	}}, nil
+}
+
+func (fakeUrls) MarkForReindex(ctx context.Context, id string) error { return nil }
+
+func (fakeUrls) Delete(ctx context.Context, id string) error { return nil }
+
+func (fakeUrls) Recrawl(ctx context.Context, urls []services.CrawledUrl) error { return nil }
+
+type fakeSettings struct {
+	settings services.SearchSettings
+}
+
+func (f *fakeSettings) Get(ctx context.Context) (services.SearchSettings, error) {
+	return f.settings, nil
+}
+
+func (f *fakeSettings) Upadate(ctx context.Context, input services.SearchSettings) error {
+	input.ID = f.settings.ID
+	f.settings = input
+
+	return nil
+}
+
+func (f *fakeSettings) Reload(settings services.SearchSettings) error { return nil }
+
+func (f *fakeSettings) Next(name string) time.Time { return testTime }
+
+type fakeJobs struct{}
+
+func (fakeJobs) Start(name, trigger string) error {
+	if name != search.JobCrawl {
+		return search.ErrJobUnknown
+	}
+
+	return nil
+}
+
+func (fakeJobs) IsRunning(name string) bool { return false }
+
+func (fakeJobs) GetRecent(ctx context.Context, limit int) ([]services.Job, error) {
+	return []services.Job{{
+		ID: 1, Name: search.JobCrawl, Status: services.JobSucceeded,
+		StartedAt: testTime, FinishedAt: &testTime,
+	}}, nil
+}
+
+type fakeUrlStats struct{}
+
+func (fakeUrlStats) GetStats(ctx context.Context) (services.UrlStats, error) {
+	return services.UrlStats{Total: 1, Crawled: 1}, nil
+}
+
+func (fakeUrlStats) GetTopDomains(ctx context.Context, limit int) ([]services.DomainCount, error) {
+	return []services.DomainCount{{Host: "go.dev", Count: 1}}, nil
+}
+
+func (fakeUrlStats) GetResponseCodes(ctx context.Context) ([]services.CodeCount, error) {
+	return []services.CodeCount{{Code: 200, Count: 1}}, nil
+}
+
+func (fakeUrlStats) GetGrowth(ctx context.Context, days int) ([]services.GrowthPoint, error) {
+	return []services.GrowthPoint{{Day: testTime, Added: 1, Total: 1}}, nil
+}
+
+type fakeIndexStats struct{}
+
+func (fakeIndexStats) GetStats(ctx context.Context) (services.IndexStats, error) {
+	return services.IndexStats{Documents: 1, Terms: 2}, nil
+}
+
+// newApiApp returns the app with all the routes, served by fake
+// services, with the search API limited by the given handler.
+func newApiApp(kh ApiKeysHandler) (*fiber.App, []apiRoute) {
+	settings := &fakeSettings{settings: services.SearchSettings{ID: 1, Amount: 100}}
+	sh := NewSettingsHandler(settings, nil, settings, kh.Anonymous)
+	uh := NewUrlsHandler(fakeUrls{}, fakeUrls{})
+	jh := NewJobsHandler(fakeJobs{}, fakeJobs{})
+	sth := NewStatsHandler(fakeUrlStats{}, fakeIndexStats{})
+	sch := NewSearchHandler(&fakeSearch{})
+
+	app := fiber.New()
+	SetRoutes(
+		app, NewAuthHandler(nil), sh, RulesHandler{}, SeedsHandler{},
+		uh, EventsHandler{}, jh, sth, sch, kh,
+	)
+
+	return app, apiRoutes(sh, uh, jh, sth, sch)
+}
+
+// getDocument returns the OpenAPI document served by the app, as JSON values.
+func getDocument(t *testing.T, app *fiber.App) map[string]any {
+	res, err := app.Test(httptest.NewRequest("GET", "/api/v1/openapi.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, res.StatusCode)
+
+	doc := map[string]any{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&doc))
+
+	return doc
+}
+
+// child returns the value at the given keys of nested JSON objects.
+func child(v any, keys ...string) any {
+	for _, k := range keys {
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil
+		}
+		v = m[k]
+	}
+
+	return v
+}
+
+// validate checks that the JSON value matches the schema of the document,
+// with the subset of JSON schema that `openApiDocument` generates.
+func validate(doc map[string]any, s map[string]any, v any, at string) error {
+	if ref, ok := s["$ref"].(string); ok {
+		keys := strings.Split(strings.TrimPrefix(ref, "#/"), "/")
+		resolved, ok := child(doc, keys...).(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %s not found", at, ref)
+		}
+		s = resolved
+	}
+	if v == nil {
+		if s["nullable"] == true {
+			return nil
+		}
+
+		return fmt.Errorf("%s: null is not nullable", at)
+	}
+	allOf, _ := s["allOf"].([]any)
+	for _, sub := range allOf {
+		if err := validate(doc, sub.(map[string]any), v, at); err != nil {
+			return err
+		}
+	}
+
+	switch s["type"] {
+	case "object":
+		m, ok := v.(map[string]any)
+		if !ok {
+			return fmt.Errorf("%s: %v is not an object", at, v)
+		}
+		properties, _ := s["properties"].(map[string]any)
+		required, _ := s["required"].([]any)
+		for _, name := range required {
+			if _, ok := m[name.(string)]; !ok {
+				return fmt.Errorf("%s: %s is missing", at, name)
+			}
+		}
+		for name, value := range m {
+			ps, ok := properties[name].(map[string]any)
+			if !ok {
+				ps, ok = s["additionalProperties"].(map[string]any)
+			}
+			if !ok {
+				return fmt.Errorf("%s: %s is not documented", at, name)
+			}
+			if err := validate(doc, ps, value, at+"."+name); err != nil {
+				return err
+			}
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			return fmt.Errorf("%s: %v is not an array", at, v)
+		}
+		for i, item := range items {
+			err := validate(doc, s["items"].(map[string]any), item, at+"["+strconv.Itoa(i)+"]")
+			if err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: %v is not a string", at, v)
+		}
+		if enum, ok := s["enum"].([]any); ok && !slices.Contains(enum, any(str)) {
+			return fmt.Errorf("%s: %q is not one of %v", at, str, enum)
+		}
+	case "integer":
+		if n, ok := v.(float64); !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s: %v is not an integer", at, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: %v is not a number", at, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: %v is not a boolean", at, v)
+		}
+	}
+
+	return nil
+}
+
+// validateResponse checks that the response matches
+// the one documented for its route and status.
+func validateResponse(
+	t *testing.T, doc map[string]any, path, method string, res *fiberResponse,
+) {
+	t.Helper()
+
+	s, ok := child(
+		doc, "paths", path, method, "responses", strconv.Itoa(res.status),
+		"content", fiber.MIMEApplicationJSON, "schema",
+	).(map[string]any)
+	if !assert.True(t, ok, "%s %s: status %d is not documented", method, path, res.status) {
+		return
+	}
+	assert.NoError(t, validate(doc, s, res.body, method+" "+path))
+}
+
+type fiberResponse struct {
+	status int
+	body   any
+}
+
+// send sends a request to the app, logged in as an admin or not.
+func send(t *testing.T, app *fiber.App, method, target string, body any, admin bool) *fiberResponse {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		require.NoError(t, err)
+		reader = bytes.NewReader(b)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if admin {
+		token, err := utils.CreateNewAuthToken("1", "admin@example.com", true)
+		require.NoError(t, err)
+		req.Header.Set(fiber.HeaderCookie, "admin="+token)
+	}
+
+	res, err := app.Test(req)
+	require.NoError(t, err)
+
+	var decoded any
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&decoded), target)
+
+	return &fiberResponse{status: res.StatusCode, body: decoded}
+}
+
+func TestOpenApiRoutes(t *testing.T) {
+	kh, _ := newKeysHandler(100)
+	app, routes := newApiApp(kh)
+	doc := getDocument(t, app)
+
+	assert.Equal(t, "3.0.3", doc["openapi"])
+
+	documented := 0
+	for _, item := range doc["paths"].(map[string]any) {
+		documented += len(item.(map[string]any))
+	}
+	assert.Equal(t, len(routes), documented)
+
+	// Every route of the API is documented
+	registered := 0
+	for _, r := range app.GetRoutes(true) {
+		path, ok := strings.CutPrefix(r.Path, apiPrefix)
+		if !ok || r.Method == fiber.MethodHead || path == "/openapi.json" {
+			continue
+		}
+		registered++
+
+		operation := child(doc, "paths", openApiPath(path), strings.ToLower(r.Method))
+		assert.NotNil(t, operation, "%s %s is not documented", r.Method, r.Path)
+	}
+	assert.Equal(t, documented, registered)
+}
+
+func TestOpenApiResponses(t *testing.T) {
+	t.Setenv("SECRET_KEY", "secret")
+	kh, _ := newKeysHandler(100)
+	app, routes := newApiApp(kh)
+	doc := getDocument(t, app)
+
+	// Each route responds as documented, when it succeeds
+	// and, if it is for the admins, when it is not logged in
+	for _, r := range routes {
+		path := openApiPath(r.Path)
+		method := strings.ToLower(r.Method)
+
+		target := strings.NewReplacer(":id", "1", ":name", search.JobCrawl).Replace(r.Path)
+		values := url.Values{}
+		for _, p := range r.Query {
+			if p.Required {
+				values.Set(p.Name, "go programming")
+			}
+		}
+		target = apiPrefix + target + "?" + values.Encode()
+
+		res := send(t, app, r.Method, target, r.Body, true)
+		assert.Equal(t, r.status(), res.status, "%s %s", r.Method, target)
+		assert.Equal(t, true, child(res.body, "success"), "%s %s", r.Method, target)
+		validateResponse(t, doc, path, method, res)
+
+		if r.Admin {
+			res := send(t, app, r.Method, target, r.Body, false)
+			assert.Equal(t, fiber.StatusUnauthorized, res.status, "%s %s", r.Method, target)
+			validateResponse(t, doc, path, method, res)
+		}
+	}
+
+	// The errors are documented too
+	tests := []struct {
+		method, path, target string
+		body                 any
+		status               int
+		code                 string
+	}{
+		{"get", "/search", "/api/v1/search?q=", nil, fiber.StatusBadRequest, "invalid_input"},
+		{"get", "/suggest", "/api/v1/suggest", nil, fiber.StatusBadRequest, "invalid_input"},
+		{"get", "/urls/{id}", "/api/v1/urls/2", nil, fiber.StatusNotFound, "not_found"},
+		{"post", "/urls/{id}/recrawl", "/api/v1/urls/2/recrawl", nil, fiber.StatusNotFound, "not_found"},
+		{"put", "/settings", "/api/v1/settings", map[string]any{"amount": 0}, fiber.StatusBadRequest, "invalid_input"},
+		{"post", "/jobs/{name}", "/api/v1/jobs/nope", nil, fiber.StatusNotFound, "not_found"},
+	}
+	for _, tt := range tests {
+		res := send(t, app, strings.ToUpper(tt.method), tt.target, tt.body, true)
+		assert.Equal(t, tt.status, res.status, tt.target)
+		assert.Equal(t, tt.code, child(res.body, "error", "code"), tt.target)
+		validateResponse(t, doc, tt.path, tt.method, res)
+	}
+
+	// The unknown routes of the API fail as it does
+	res := send(t, app, "GET", "/api/v1/nope", nil, true)
+	assert.Equal(t, fiber.StatusNotFound, res.status)
+	assert.Equal(t, "not_found", child(res.body, "error", "code"))
+}
@@ -3,18 +3,26 @@ package handlers
 import (
 	"fmt"
 	"strings"
-	"time"
 
 	"github.com/a-h/templ"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/adaptor"
-	"github.com/gofiber/fiber/v2/middleware/cache"
 )
 
 // SetRoutes sets the routes in the application and
 // associates them with their respective handlers
 func SetRoutes(
-	app *fiber.App, ah AuthHandler, sh SettingsHandler, sch SearchHandler,
+	app *fiber.App,
+	ah AuthHandler,
+	sh SettingsHandler,
+	rh RulesHandler,
+	sdh SeedsHandler,
+	uh UrlsHandler,
+	eh EventsHandler,
+	jh JobsHandler,
+	sth StatsHandler,
+	sch SearchHandler,
+	kh ApiKeysHandler,
 ) {
 	// ↓ health checker route ↓
 	app.Get("/health-checker", healthCheckerHandler)
@@ -26,20 +34,42 @@ func SetRoutes(
 	// ↓ Admin routes ↓
 	app.Get("/", ah.authMiddleware, sh.dashboardHandler)
 	app.Post("/", ah.authMiddleware, sh.dashboardPostHandler)
+	app.Get("/schedule/:job", ah.authMiddleware, sh.schedulePreviewHandler)
 	app.Post("/logout", ah.logoutHandler)
+	app.Get("/rules", ah.authMiddleware, rh.rulesHandler)
+	app.Post("/rules", ah.authMiddleware, rh.rulesPostHandler)
+	app.Delete("/rules/:id", ah.authMiddleware, rh.ruleDeleteHandler)
+	app.Get("/seeds", ah.authMiddleware, sdh.seedsHandler)
+	app.Post("/seeds", ah.authMiddleware, sdh.seedsPostHandler)
+	app.Post("/seeds/:id/pause", ah.authMiddleware, sdh.seedPauseHandler(true))
+	app.Post("/seeds/:id/resume", ah.authMiddleware, sdh.seedPauseHandler(false))
+	app.Delete("/seeds/:id", ah.authMiddleware, sdh.seedDeleteHandler)
+	app.Get("/urls", ah.authMiddleware, uh.urlsHandler)
+	app.Get("/urls/:id", ah.authMiddleware, uh.urlDetailHandler)
+	app.Post("/urls/:id/recrawl", ah.authMiddleware, uh.urlRecrawlHandler)
+	app.Post("/urls/:id/reindex", ah.authMiddleware, uh.urlReindexHandler)
+	app.Delete("/urls/:id", ah.authMiddleware, uh.urlDeleteHandler)
+	app.Get("/events", ah.authMiddleware, eh.eventsHandler)
+	app.Get("/stats", ah.authMiddleware, sth.statsHandler)
+	app.Get("/jobs", ah.authMiddleware, jh.jobsHandler)
+	app.Post("/jobs/:name", ah.authMiddleware, jh.jobRunHandler)
+	app.Get("/api-keys", ah.authMiddleware, kh.apiKeysHandler)
+	app.Post("/api-keys", ah.authMiddleware, kh.apiKeysPostHandler)
+	app.Delete("/api-keys/:id", ah.authMiddleware, kh.apiKeyRevokeHandler)
+
+	// ↓ Admin API routes, kept for the existing clients (see `/api/v1/jobs`) ↓
+	app.Get("/api/jobs", ah.authMiddleware, jh.apiJobsHandler)
+	app.Post("/api/jobs/:name", ah.authMiddleware, jh.apiJobRunHandler)
 
 	// ↓ Create admin route [secret route] ↓
 	app.Post("/create", ah.createAdminHandler)
 
-	// ↓ Search route ↓
-	app.Post("/search", sch.searchHandler)
-	app.Use("/search", cache.New(cache.Config{
-		Next: func(c *fiber.Ctx) bool {
-			return c.Query("noCache") == "true"
-		},
-		Expiration:   30 * time.Minute,
-		CacheControl: true,
-	}))
+	// ↓ Search routes ↓
+	app.Get("/search", sch.searchPageHandler)
+	app.Post("/search", kh.apiKeyMiddleware, sch.searchHandler) // Kept for the existing clients
+
+	// ↓ API routes (see `apiRoutes`), documented at /api/v1/openapi.json ↓
+	setApiRoutes(app, ah, kh, apiRoutes(sh, uh, jh, sth, sch))
 
 	/* TODO: ↓ Fallback Page ↓ */
 	app.Get("/*", func(c *fiber.Ctx) error {
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/emarifer/search-engine/internal/handlers/dto"
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/emarifer/search-engine/views"
+	"github.com/gofiber/fiber/v2"
+)
+
+/********** Handlers for Crawl Rules Views **********/
+
+type RuleService interface {
+	GetAll(ctx context.Context) ([]services.CrawlRule, error)
+	Save(ctx context.Context, input *services.CrawlRule) error
+	Delete(ctx context.Context, id uint) error
+}
+
+func NewRulesHandler(rs RuleService) RulesHandler {
+
+	return RulesHandler{
+		Rules: rs,
+	}
+}
+
+type RulesHandler struct {
+	Rules RuleService
+}
+
+func (rh *RulesHandler) rulesHandler(c *fiber.Ctx) error {
+	rules, err := rh.Rules.GetAll(c.UserContext())
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	rows := make([]views.RuleRow, len(rules))
+	for i, r := range rules {
+		rows[i] = views.RuleRow{
+			ID:      strconv.FormatUint(uint64(r.ID), 10),
+			Kind:    string(r.Kind),
+			Pattern: r.Pattern,
+			Allow:   r.Allow,
+		}
+	}
+
+	return Render(c, views.Rules(rows))
+}
+
+func (rh *RulesHandler) rulesPostHandler(c *fiber.Ctx) error {
+	form := dto.RuleFormDto{}
+	if err := c.BodyParser(&form); err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	if form.Action != "allow" && form.Action != "deny" {
+
+		return c.
+			Status(fiber.StatusBadRequest).
+			SendString("✖&nbsp;&nbsp; the action must be allow or deny")
+	}
+
+	rule, err := services.NewCrawlRule(
+		services.RuleKind(form.Kind),
+		strings.Trim(form.Pattern, " "),
+		form.Action == "allow",
+	)
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusBadRequest).
+			SendString("✖&nbsp;&nbsp; " + err.Error())
+	}
+
+	err = rh.Rules.Save(c.UserContext(), &rule)
+	if errors.Is(err, services.ErrInvalidRule) {
+
+		return c.
+			Status(fiber.StatusBadRequest).
+			SendString("✖&nbsp;&nbsp; " + err.Error())
+	}
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	return c.Status(fiber.StatusSeeOther).Redirect("/rules")
+}
+
+func (rh *RulesHandler) ruleDeleteHandler(c *fiber.Ctx) error {
+	id, err := strconv.ParseUint(c.Params("id"), 10, 64)
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusBadRequest).
+			SendString("✖&nbsp;&nbsp; invalid rule")
+	}
+
+	if err := rh.Rules.Delete(c.UserContext(), uint(id)); err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	return c.Status(fiber.StatusSeeOther).Redirect("/rules")
+}
@@ -1,15 +1,34 @@
 package handlers
 
 import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/emarifer/search-engine/internal/handlers/dto"
+	"github.com/emarifer/search-engine/internal/search"
 	"github.com/emarifer/search-engine/internal/services"
+	"github.com/emarifer/search-engine/views"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cache"
 )
 
 /********** Handlers for Search endpoint **********/
 
 type SearchService interface {
-	SearchFullText(v string) ([]services.CrawledUrl, error)
+	SearchFullText(
+		ctx context.Context, v string, opts services.SearchOptions,
+	) ([]services.CrawledUrl, error)
+	SearchRanked(
+		ctx context.Context, terms []string, opts services.SearchOptions, page, pageSize int,
+	) (services.SearchPage, error)
+	GetTermCandidates(ctx context.Context, term string, limit int) ([]string, error)
 }
 
 func NewSearchHandler(s SearchService) SearchHandler {
@@ -27,34 +46,359 @@ func (sh *SearchHandler) searchHandler(c *fiber.Ctx) error {
 	search := dto.SearchJsonDto{}
 	err := c.BodyParser(&search)
 	if err != nil || search.Term == "" {
-		c.Status(fiber.StatusBadRequest)
-		c.Append("content-type", "application/json")
 
-		return c.JSON(fiber.Map{
-			"success": false,
-			"message": "Invalid input",
-			"data":    nil,
+		return apiFail(c, fiber.StatusBadRequest, "Invalid input")
+	}
+
+	data, err := sh.Search.SearchFullText(
+		c.UserContext(), search.Term, services.SearchOptions{SchemaType: search.Type},
+	)
+	if err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not find a response")
+	}
+
+	return apiOK(c, fiber.StatusOK, "Search results", data)
+}
+
+/********** Handlers for the Public Search Views **********/
+
+const (
+	searchPageSize   = 10
+	maxSearchPage    = 50  // Deeper pages are not useful, and are slow
+	snippetLength    = 240 // Bytes of the snippet of each result
+	searchCandidates = 500 // Terms looked at to suggest another query
+)
+
+// searchHref returns the link to a page of the results of the query.
+func searchHref(query string, page int) string {
+	values := url.Values{}
+	values.Set("q", query)
+	if page > 1 {
+		values.Set("page", strconv.Itoa(page))
+	}
+
+	return "/search?" + values.Encode()
+}
+
+// pageLinks returns the links to the pages around the current one.
+func pageLinks(query string, page, pages int) []views.PageLink {
+	first := max(1, page-2)
+	last := min(pages, first+4)
+	first = max(1, last-4)
+
+	links := []views.PageLink{}
+	for p := first; p <= last; p++ {
+		links = append(links, views.PageLink{
+			Label:   strconv.Itoa(p),
+			Href:    searchHref(query, p),
+			Current: p == page,
 		})
 	}
 
-	data, err := sh.Search.SearchFullText(search.Term)
+	return links
+}
+
+// resultSnippet returns the description of the result if it contains
+// the terms, or else the excerpt of its content where they are found.
+// Only the description is shown of the pages that asked not to keep
+// a copy of their text.
+func resultSnippet(r services.SearchResult, terms []string) []views.SnippetPart {
+	hasMatch := func(parts []search.SnippetPart) bool {
+		return slices.ContainsFunc(parts, func(p search.SnippetPart) bool {
+			return p.Match
+		})
+	}
+
+	parts := search.Snippet(r.PageDescription, terms, snippetLength)
+	if !hasMatch(parts) && !r.NoArchive {
+		content := search.Snippet(r.Content, terms, snippetLength)
+		if hasMatch(content) || parts == nil {
+			parts = content
+		}
+	}
+
+	snippet := make([]views.SnippetPart, len(parts))
+	for i, p := range parts {
+		snippet[i] = views.SnippetPart{Text: p.Text, Match: p.Match}
+	}
+
+	return snippet
+}
+
+// suggestQuery returns the query with its words replaced by the most
+// similar terms of the index, if any, to suggest it when nothing is found.
+func (sh *SearchHandler) suggestQuery(ctx context.Context, query string) string {
+	words := strings.Fields(strings.ToLower(query))
+
+	suggested := false
+	for i, w := range words {
+		terms := search.QueryTerms(w)
+		if len(terms) != 1 {
+			continue
+		}
+
+		candidates, err := sh.Search.GetTermCandidates(ctx, terms[0], searchCandidates)
+		if err != nil {
+			log.Println("something went wrong suggesting a query:", err)
+
+			return ""
+		}
+		if closest, ok := search.ClosestTerm(terms[0], candidates); ok {
+			words[i] = closest
+			suggested = true
+		}
+	}
+	if !suggested {
+		return ""
+	}
+
+	return strings.Join(words, " ")
+}
+
+// searchPageHandler is the public search page, which
+// is rendered on the server and works without JavaScript.
+func (sh *SearchHandler) searchPageHandler(c *fiber.Ctx) error {
+	query := dto.SearchQueryDto{}
+	if err := c.QueryParser(&query); err != nil {
+
+		return c.
+			Status(fiber.StatusBadRequest).
+			SendString("✖&nbsp;&nbsp; invalid search")
+	}
+	query.Query = strings.TrimSpace(query.Query)
+	query.Page = min(max(query.Page, 1), maxSearchPage)
+
+	results := views.SearchResults{Query: query.Query}
+	if query.Query == "" {
+		return Render(c, views.Search(results))
+	}
+
+	terms := search.QueryTerms(query.Query)
+	page, err := sh.Search.SearchRanked(
+		c.UserContext(), terms, services.SearchOptions{}, query.Page, searchPageSize,
+	)
 	if err != nil {
-		c.Status(fiber.StatusInternalServerError)
-		c.Append("content-type", "application/json")
 
-		return c.JSON(fiber.Map{
-			"success": false,
-			"message": "Could not find a response",
-			"data":    nil,
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	// Past the last page, the results start again
+	if len(page.Results) == 0 && query.Page > 1 {
+		return c.Redirect(searchHref(query.Query, 1))
+	}
+
+	for _, r := range page.Results {
+		results.Rows = append(results.Rows, views.SearchResultRow{
+			Url:     r.Url,
+			Title:   cmp.Or(strings.TrimSpace(r.PageTitle), r.Url),
+			Snippet: resultSnippet(r, terms),
 		})
 	}
 
-	c.Status(fiber.StatusOK)
-	c.Append("content-type", "application/json")
+	pages := min(page.Pages(), maxSearchPage)
+	results.Total = strconv.FormatInt(page.Total, 10)
+	results.Pages = pageLinks(query.Query, page.Page, pages)
+	if page.Page > 1 {
+		results.PrevHref = searchHref(query.Query, page.Page-1)
+	}
+	if page.Page < pages {
+		results.NextHref = searchHref(query.Query, page.Page+1)
+	}
 
-	return c.JSON(fiber.Map{
-		"success": true,
-		"message": "Search results",
-		"data":    data,
+	if len(results.Rows) == 0 {
+		results.Suggestion = sh.suggestQuery(c.UserContext(), query.Query)
+		results.SuggestUrl = searchHref(results.Suggestion, 1)
+	}
+
+	return Render(c, views.Search(results))
+}
+
+/********** Handlers for the Search API **********/
+
+const (
+	maxApiPageSize        = 50
+	searchCacheExpiration = 5 * time.Minute
+	searchCacheMaxBytes   = 32 << 20 // The oldest responses are evicted first
+)
+
+// apiSearch is a search of the API, normalized so that
+// the queries with the same terms share their results.
+type apiSearch struct {
+	Terms []string
+	Type  string
+	Page  int
+	Size  int
+}
+
+// apiSearchPage is a page of the results of the search API.
+type apiSearchPage struct {
+	Terms   []string          `json:"terms"` // As they were searched
+	Page    int               `json:"page"`
+	Size    int               `json:"size"`
+	Pages   int               `json:"pages"`
+	Total   int64             `json:"total"`
+	Results []apiSearchResult `json:"results"`
+}
+
+// apiSearchResult is a result of the search API.
+type apiSearchResult struct {
+	Url         string `json:"url"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Snippet     string `json:"snippet"`
+	Score       int    `json:"score"`
+}
+
+// parseApiSearch returns the normalized search of the request.
+func parseApiSearch(c *fiber.Ctx) (apiSearch, error) {
+	query := dto.SearchQueryDto{}
+	if err := c.QueryParser(&query); err != nil {
+		return apiSearch{}, err
+	}
+
+	terms := search.QueryTerms(query.Query)
+	slices.Sort(terms)
+
+	size := searchPageSize
+	if query.Size > 0 {
+		size = min(query.Size, maxApiPageSize)
+	}
+
+	return apiSearch{
+		Terms: terms,
+		Type:  strings.TrimSpace(query.Type),
+		Page:  min(max(query.Page, 1), maxSearchPage),
+		Size:  size,
+	}, nil
+}
+
+// key returns the key of the cached results of the search.
+func (s apiSearch) key() string {
+	values := url.Values{}
+	values.Set("q", strings.Join(s.Terms, " "))
+	values.Set("type", s.Type)
+	values.Set("page", strconv.Itoa(s.Page))
+	values.Set("size", strconv.Itoa(s.Size))
+
+	return "/api/v1/search?" + values.Encode()
+}
+
+// searchCacheKey is the key of the cached response of the search API,
+// so that e.g. `?q=Go+Lang` and `?q=lang%20go&page=1` share it.
+func searchCacheKey(c *fiber.Ctx) string {
+	s, err := parseApiSearch(c)
+	if err != nil {
+		return c.OriginalURL() // It is not cached anyway
+	}
+
+	return s.key()
+}
+
+// newSearchCache returns the cache of the successful responses of the
+// search API, which is skipped with `?noCache=true` (a `Cache-Control:
+// no-cache` header, instead, refreshes the cached response).
+func newSearchCache() fiber.Handler {
+	cached := cache.New(cache.Config{
+		Next: func(c *fiber.Ctx) bool {
+			return c.Response().StatusCode() != fiber.StatusOK
+		},
+		Expiration:   searchCacheExpiration,
+		CacheControl: true,
+		KeyGenerator: searchCacheKey,
+		MaxBytes:     searchCacheMaxBytes,
+	})
+
+	return func(c *fiber.Ctx) error {
+		if c.QueryBool("noCache") {
+			return c.Next()
+		}
+
+		return cached(c)
+	}
+}
+
+// snippetText returns the snippet of a result as plain text.
+func snippetText(parts []views.SnippetPart) string {
+	var sb strings.Builder
+	for _, p := range parts {
+		sb.WriteString(p.Text)
+	}
+
+	return sb.String()
+}
+
+// apiSearchHandler is the search API, whose responses can be
+// cached, by the clients and by the server (see searchCacheKey).
+func (sh *SearchHandler) apiSearchHandler(c *fiber.Ctx) error {
+	s, err := parseApiSearch(c)
+	if err != nil || len(s.Terms) == 0 {
+
+		return apiFail(c, fiber.StatusBadRequest, "Invalid input")
+	}
+
+	page, err := sh.Search.SearchRanked(
+		c.UserContext(), s.Terms, services.SearchOptions{SchemaType: s.Type}, s.Page, s.Size,
+	)
+	if err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not find a response")
+	}
+
+	results := make([]apiSearchResult, len(page.Results))
+	for i, r := range page.Results {
+		results[i] = apiSearchResult{
+			Url:         r.Url,
+			Title:       cmp.Or(strings.TrimSpace(r.PageTitle), r.Url),
+			Description: r.PageDescription,
+			Snippet:     snippetText(resultSnippet(r, s.Terms)),
+			Score:       r.Score,
+		}
+	}
+
+	// The cache sets the remaining time of the cached responses
+	c.Set(
+		fiber.HeaderCacheControl,
+		fmt.Sprintf("public, max-age=%d", int(searchCacheExpiration.Seconds())),
+	)
+
+	return apiOK(c, fiber.StatusOK, "Search results", apiSearchPage{
+		Terms:   s.Terms,
+		Page:    page.Page,
+		Size:    page.PageSize,
+		Pages:   min(page.Pages(), maxSearchPage),
+		Total:   page.Total,
+		Results: results,
 	})
 }
+
+// apiSuggestion is a query similar to a misspelled one.
+type apiSuggestion struct {
+	Query      string `json:"query"`
+	Suggestion string `json:"suggestion"` // Empty if there is none
+	Href       string `json:"href"`       // Of the search page of the suggestion
+}
+
+func (sh *SearchHandler) apiSuggestHandler(c *fiber.Ctx) error {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+
+		return apiFail(c, fiber.StatusBadRequest, "Invalid input")
+	}
+
+	suggestion := apiSuggestion{Query: query}
+	suggestion.Suggestion = sh.suggestQuery(c.UserContext(), query)
+	if suggestion.Suggestion != "" {
+		suggestion.Href = searchHref(suggestion.Suggestion, 1)
+	}
+
+	return apiOK(c, fiber.StatusOK, "Suggested query", suggestion)
+}
+
+/* FIBER CACHE AND ETAG MIDDLEWARES:
+https://docs.gofiber.io/api/middleware/cache
+https://docs.gofiber.io/api/middleware/etag
+https://developer.mozilla.org/en-US/docs/Web/HTTP/Caching
+*/
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/etag"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeSearch struct {
+	calls int
+	terms []string
+	opts  services.SearchOptions
+}
+
+func (f *fakeSearch) SearchFullText(
+	ctx context.Context, v string, opts services.SearchOptions,
+) ([]services.CrawledUrl, error) {
+	return nil, nil
+}
+
+func (f *fakeSearch) SearchRanked(
+	ctx context.Context, terms []string, opts services.SearchOptions, page, pageSize int,
+) (services.SearchPage, error) {
+	f.calls++
+	f.terms, f.opts = terms, opts
+
+	return services.SearchPage{
+		Results: []services.SearchResult{{
+			Url:             "https://go.dev/",
+			PageDescription: "The Go programming language",
+			Score:           1,
+		}},
+		Total:    1,
+		Page:     page,
+		PageSize: pageSize,
+	}, nil
+}
+
+func (f *fakeSearch) GetTermCandidates(ctx context.Context, term string, limit int) ([]string, error) {
+	return nil, nil
+}
+
+func newSearchApp(s SearchService) *fiber.App {
+	sch := NewSearchHandler(s)
+	app := fiber.New()
+	app.Get("/api/v1/search", etag.New(), newSearchCache(), sch.apiSearchHandler)
+
+	return app
+}
+
+func TestApiSearchCache(t *testing.T) {
+	fake := &fakeSearch{}
+	app := newSearchApp(fake)
+
+	res, err := app.Test(httptest.NewRequest("GET", "/api/v1/search?q=Programming+GO&size=500", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusOK, res.StatusCode)
+	assert.Equal(t, "miss", res.Header.Get("X-Cache"))
+	assert.Equal(t, "public, max-age=300", res.Header.Get(fiber.HeaderCacheControl))
+	etag := res.Header.Get(fiber.HeaderETag)
+	assert.NotEmpty(t, etag)
+
+	body := struct {
+		Success bool
+		Data    struct {
+			Terms   []string
+			Size    int
+			Results []apiSearchResult
+		}
+	}{}
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	assert.True(t, body.Success)
+	assert.Equal(t, []string{"go", "program"}, body.Data.Terms)
+	assert.Equal(t, maxApiPageSize, body.Data.Size)
+	assert.Equal(t, []apiSearchResult{{
+		Url:         "https://go.dev/",
+		Title:       "https://go.dev/",
+		Description: "The Go programming language",
+		Snippet:     "The Go programming language",
+		Score:       1,
+	}}, body.Data.Results)
+
+	// The same terms, in another order, are served from the cache
+	res, err = app.Test(httptest.NewRequest("GET", "/api/v1/search?q=go%20programs&page=1&size=50", nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "hit", res.Header.Get("X-Cache"))
+	assert.Equal(t, etag, res.Header.Get(fiber.HeaderETag))
+	assert.Equal(t, 1, fake.calls)
+
+	req := httptest.NewRequest("GET", "/api/v1/search?q=go+programming&size=50", nil)
+	req.Header.Set(fiber.HeaderIfNoneMatch, etag)
+	res, err = app.Test(req)
+	assert.NoError(t, err)
+	assert.Equal(t, fiber.StatusNotModified, res.StatusCode)
+
+	// Other pages, types or sizes are different searches
+	for _, q := range []string{
+		"q=go+programming&size=50&page=2",
+		"q=go+programming&size=50&type=Article",
+		"q=go+programming",
+		"q=go+programming&size=50&noCache=true",
+	} {
+		res, err = app.Test(httptest.NewRequest("GET", "/api/v1/search?"+q, nil))
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusOK, res.StatusCode, q)
+	}
+	assert.Equal(t, 5, fake.calls)
+}
+
+func TestApiSearchInvalid(t *testing.T) {
+	fake := &fakeSearch{}
+	app := newSearchApp(fake)
+
+	for _, q := range []string{"", "q=", "q=the+and", "q=go&page=x"} {
+		for range 2 {
+			res, err := app.Test(httptest.NewRequest("GET", "/api/v1/search?"+q, nil))
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusBadRequest, res.StatusCode, q)
+			assert.Equal(t, "no-store", res.Header.Get(fiber.HeaderCacheControl), q)
+			assert.Empty(t, res.Header.Get(fiber.HeaderETag), q)
+		}
+	}
+	assert.Zero(t, fake.calls)
+}
+
+func TestResultSnippet(t *testing.T) {
+	r := services.SearchResult{
+		PageDescription: "All about pets",
+		Content:         "Gophers dig burrows in the garden",
+	}
+
+	// The excerpt of the content is shown if the description does not match
+	assert.Equal(t, "Gophers dig burrows in the garden", snippetText(resultSnippet(r, []string{"gopher"})))
+
+	// unless the page asked not to keep a copy of its text
+	r.NoArchive = true
+	assert.Equal(t, "All about pets", snippetText(resultSnippet(r, []string{"gopher"})))
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/emarifer/search-engine/views"
+	"github.com/gofiber/fiber/v2"
+)
+
+/********** Handlers for Seed Urls Views **********/
+
+type SeedService interface {
+	SaveSeed(ctx context.Context, rawUrl string) (bool, error)
+	GetSeeds(ctx context.Context) ([]services.CrawledUrl, error)
+	SetPaused(ctx context.Context, id string, paused bool) error
+	Delete(ctx context.Context, id string) error
+}
+
+func NewSeedsHandler(ss SeedService) SeedsHandler {
+
+	return SeedsHandler{
+		Seeds: ss,
+	}
+}
+
+type SeedsHandler struct {
+	Seeds SeedService
+}
+
+// renderSeeds renders the list of seeds with the result of an import.
+func (sh *SeedsHandler) renderSeeds(c *fiber.Ctx, result views.SeedImport) error {
+	seeds, err := sh.Seeds.GetSeeds(c.UserContext())
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	rows := make([]views.SeedRow, len(seeds))
+	for i, s := range seeds {
+		rows[i] = views.SeedRow{
+			ID:     s.ID,
+			Url:    s.Url,
+			Status: seedStatus(s),
+			Paused: s.Paused,
+		}
+	}
+
+	return Render(c, views.Seeds(rows, result))
+}
+
+// seedStatus returns the state of the last crawl of the seed.
+func seedStatus(s services.CrawledUrl) string {
+	switch {
+	case s.LastTested == nil:
+		return "not crawled yet"
+	case s.Success:
+		return fmt.Sprintf("crawled %s", s.LastTested.Format(time.DateTime))
+	case s.FailureReason != "":
+		return fmt.Sprintf("failed: %s", s.FailureReason)
+	}
+
+	return "failed"
+}
+
+func (sh *SeedsHandler) seedsHandler(c *fiber.Ctx) error {
+
+	return sh.renderSeeds(c, views.SeedImport{})
+}
+
+func (sh *SeedsHandler) seedsPostHandler(c *fiber.Ctx) error {
+	// The urls can be pasted, uploaded in a file or both
+	lists := []io.Reader{strings.NewReader(c.FormValue("urls"))}
+	if form, err := c.MultipartForm(); err == nil {
+		for _, file := range form.File["file"] {
+			f, err := file.Open()
+			if err != nil {
+
+				return c.
+					Status(fiber.StatusBadRequest).
+					SendString("✖&nbsp;&nbsp; the file could not be read")
+			}
+			defer f.Close()
+			lists = append(lists, strings.NewReader("\n"), f)
+		}
+	}
+
+	urls, err := services.ParseSeedList(io.MultiReader(lists...))
+	if err != nil && len(urls) == 0 {
+
+		return c.
+			Status(fiber.StatusBadRequest).
+			SendString("✖&nbsp;&nbsp; " + err.Error())
+	}
+	if len(urls) == 0 {
+
+		return c.
+			Status(fiber.StatusBadRequest).
+			SendString("✖&nbsp;&nbsp; there are no urls to add")
+	}
+
+	result := views.SeedImport{Done: true}
+	if err != nil {
+		result.Warning = err.Error()
+	}
+	for _, u := range urls {
+		if _, err := services.NormalizeUrl(u); err != nil {
+			result.Invalid = append(result.Invalid, fmt.Sprintf("%s (%s)", u, err))
+
+			continue
+		}
+
+		added, err := sh.Seeds.SaveSeed(c.UserContext(), u)
+		if err != nil {
+
+			return c.
+				Status(fiber.StatusInternalServerError).
+				SendString("✖&nbsp;&nbsp; something went wrong")
+		}
+		if added {
+			result.Added++
+		} else {
+			result.Existing++
+		}
+	}
+
+	return sh.renderSeeds(c, result)
+}
+
+// seedPauseHandler returns the handler that pauses or resumes a seed.
+func (sh *SeedsHandler) seedPauseHandler(paused bool) fiber.Handler {
+
+	return func(c *fiber.Ctx) error {
+		err := sh.Seeds.SetPaused(c.UserContext(), c.Params("id"), paused)
+		if err != nil {
+
+			return c.
+				Status(fiber.StatusInternalServerError).
+				SendString("✖&nbsp;&nbsp; something went wrong")
+		}
+
+		return c.Status(fiber.StatusSeeOther).Redirect("/seeds")
+	}
+}
+
+func (sh *SeedsHandler) seedDeleteHandler(c *fiber.Ctx) error {
+	if err := sh.Seeds.Delete(c.UserContext(), c.Params("id")); err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	return c.Status(fiber.StatusSeeOther).Redirect("/seeds")
+}
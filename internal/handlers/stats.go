@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/emarifer/search-engine/views"
+	"github.com/gofiber/fiber/v2"
+)
+
+/********** Handlers for Crawl Stats Views **********/
+
+type UrlStatsService interface {
+	GetStats(ctx context.Context) (services.UrlStats, error)
+	GetTopDomains(ctx context.Context, limit int) ([]services.DomainCount, error)
+	GetResponseCodes(ctx context.Context) ([]services.CodeCount, error)
+	GetGrowth(ctx context.Context, days int) ([]services.GrowthPoint, error)
+}
+
+type IndexStatsService interface {
+	GetStats(ctx context.Context) (services.IndexStats, error)
+}
+
+func NewStatsHandler(us UrlStatsService, is IndexStatsService) StatsHandler {
+
+	return StatsHandler{
+		Urls:  us,
+		Index: is,
+	}
+}
+
+type StatsHandler struct {
+	Urls  UrlStatsService
+	Index IndexStatsService
+}
+
+const (
+	topDomains = 10
+	growthDays = 30
+)
+
+func formatCount(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
+
+// crawlStats are all the stats of the crawl and the index.
+type crawlStats struct {
+	Urls          services.UrlStats      `json:"urls"`
+	Index         services.IndexStats    `json:"index"`
+	TopDomains    []services.DomainCount `json:"topDomains"`
+	ResponseCodes []services.CodeCount   `json:"responseCodes"`
+	Growth        []services.GrowthPoint `json:"growth"`
+}
+
+// getStats returns the stats shown on the stats page.
+func (sth *StatsHandler) getStats(ctx context.Context) (crawlStats, error) {
+	var (
+		stats crawlStats
+		err   error
+	)
+
+	if stats.Urls, err = sth.Urls.GetStats(ctx); err != nil {
+		return stats, err
+	}
+	if stats.Index, err = sth.Index.GetStats(ctx); err != nil {
+		return stats, err
+	}
+	if stats.TopDomains, err = sth.Urls.GetTopDomains(ctx, topDomains); err != nil {
+		return stats, err
+	}
+	if stats.ResponseCodes, err = sth.Urls.GetResponseCodes(ctx); err != nil {
+		return stats, err
+	}
+	if stats.Growth, err = sth.Urls.GetGrowth(ctx, growthDays); err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+func (sth *StatsHandler) statsHandler(c *fiber.Ctx) error {
+	stats, err := sth.getStats(c.UserContext())
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	totals := []views.StatCard{
+		{Label: "Total urls", Value: formatCount(stats.Urls.Total)},
+		{Label: "Crawled", Value: formatCount(stats.Urls.Crawled)},
+		{Label: "Pending", Value: formatCount(stats.Urls.Pending)},
+		{Label: "Failed", Value: formatCount(stats.Urls.Failed)},
+		{Label: "Indexed documents", Value: formatCount(stats.Index.Documents)},
+		{Label: "Vocabulary size", Value: formatCount(stats.Index.Terms)},
+		{Label: "Avg crawl duration", Value: stats.Urls.AvgDuration.Round(time.Millisecond).String()},
+	}
+
+	domainBars := make([]views.StatBar, len(stats.TopDomains))
+	for i, d := range stats.TopDomains {
+		domainBars[i] = views.StatBar{Label: d.Host, Count: d.Count, Max: stats.TopDomains[0].Count}
+	}
+
+	var maxCode int64
+	for _, cc := range stats.ResponseCodes {
+		maxCode = max(maxCode, cc.Count)
+	}
+	codeBars := make([]views.StatBar, len(stats.ResponseCodes))
+	for i, cc := range stats.ResponseCodes {
+		label := strconv.Itoa(cc.Code)
+		if cc.Code == 0 {
+			label = "no response"
+		}
+		codeBars[i] = views.StatBar{Label: label, Count: cc.Count, Max: maxCode}
+	}
+
+	var maxTotal int64
+	if len(stats.Growth) > 0 {
+		maxTotal = stats.Growth[len(stats.Growth)-1].Total
+	}
+	growthBars := make([]views.StatBar, len(stats.Growth))
+	for i, g := range stats.Growth {
+		growthBars[i] = views.StatBar{
+			Label: g.Day.Format("Jan 02"),
+			Count: g.Total,
+			Max:   maxTotal,
+			Note:  "+" + formatCount(g.Added),
+		}
+	}
+
+	return Render(c, views.Stats(totals, domainBars, codeBars, growthBars))
+}
+
+func (sth *StatsHandler) apiStatsHandler(c *fiber.Ctx) error {
+	stats, err := sth.getStats(c.UserContext())
+	if err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not get the stats")
+	}
+
+	return apiOK(c, fiber.StatusOK, "Crawl stats", stats)
+}
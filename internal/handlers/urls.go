@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/handlers/dto"
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/emarifer/search-engine/views"
+	"github.com/gofiber/fiber/v2"
+)
+
+/********** Handlers for Crawled Urls Views **********/
+
+type UrlBrowserService interface {
+	List(ctx context.Context, f services.UrlFilter) (services.UrlPage, error)
+	GetById(ctx context.Context, id string) (services.CrawledUrl, error)
+	GetTerms(ctx context.Context, id string) ([]string, error)
+	GetAttempts(ctx context.Context, id string) ([]services.CrawlAttempt, error)
+	MarkForReindex(ctx context.Context, id string) error
+	Delete(ctx context.Context, id string) error
+}
+
+type Recrawler interface {
+	Recrawl(ctx context.Context, urls []services.CrawledUrl) error
+}
+
+func NewUrlsHandler(us UrlBrowserService, r Recrawler) UrlsHandler {
+
+	return UrlsHandler{
+		Urls:      us,
+		Recrawler: r,
+	}
+}
+
+type UrlsHandler struct {
+	Urls      UrlBrowserService
+	Recrawler Recrawler
+}
+
+// urlsHref returns the link to the list of urls with the
+// given query, changed by the given values (e.g. the page).
+func urlsHref(query dto.UrlQueryDto, changes map[string]string) string {
+	values := url.Values{}
+	set := func(key, value string) {
+		if value != "" && value != "0" {
+			values.Set(key, value)
+		}
+	}
+	set("status", query.Status)
+	set("host", query.Host)
+	set("indexed", query.Indexed)
+	set("code", strconv.Itoa(query.Code))
+	set("sort", query.Sort)
+	set("order", query.Order)
+	set("page", strconv.Itoa(query.Page))
+	for key, value := range changes {
+		values.Del(key)
+		set(key, value)
+	}
+
+	if len(values) == 0 {
+		return "/urls"
+	}
+
+	return "/urls?" + values.Encode()
+}
+
+// urlStatus returns the state of the last crawl of the url.
+func urlStatus(u services.CrawledUrl) string {
+	switch {
+	case u.Paused:
+		return services.StatusPaused
+	case u.LastTested == nil:
+		return services.StatusPending
+	case u.Success:
+		return services.StatusSuccess
+	}
+
+	return services.StatusFailed
+}
+
+// urlFilter returns the filter of the list of urls of the query.
+func urlFilter(query dto.UrlQueryDto) services.UrlFilter {
+	filter := services.UrlFilter{
+		Status:       query.Status,
+		Host:         query.Host,
+		ResponseCode: query.Code,
+		Sort:         query.Sort,
+		Desc:         query.Order == "desc",
+		Page:         query.Page,
+	}
+	if query.Indexed != "" {
+		indexed := query.Indexed == "yes"
+		filter.Indexed = &indexed
+	}
+
+	return filter
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+
+	return t.Format(time.DateTime)
+}
+
+func (uh *UrlsHandler) urlsHandler(c *fiber.Ctx) error {
+	query := dto.UrlQueryDto{}
+	if err := c.QueryParser(&query); err != nil {
+
+		return c.
+			Status(fiber.StatusBadRequest).
+			SendString("✖&nbsp;&nbsp; invalid filters")
+	}
+
+	page, err := uh.Urls.List(c.UserContext(), urlFilter(query))
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	rows := make([]views.UrlRow, len(page.Urls))
+	for i, u := range page.Urls {
+		rows[i] = views.UrlRow{
+			ID:           u.ID,
+			Url:          u.Url,
+			Status:       urlStatus(u),
+			ResponseCode: strconv.Itoa(u.ResponseCode),
+			Indexed:      u.Indexed,
+			LastTested:   formatTime(u.LastTested),
+			Duration:     u.CrawlDuration.Round(time.Millisecond).String(),
+		}
+	}
+
+	// Each column is sorted ascending first, then descending
+	sortHrefs := map[string]string{}
+	for _, column := range []string{"url", "lastTested", "responseCode", "duration"} {
+		order := "asc"
+		if query.Sort == column && query.Order != "desc" {
+			order = "desc"
+		}
+		sortHrefs[column] = urlsHref(
+			query, map[string]string{"sort": column, "order": order, "page": ""},
+		)
+	}
+
+	list := views.UrlList{
+		Status:    query.Status,
+		Host:      query.Host,
+		Indexed:   query.Indexed,
+		Code:      c.Query("code"),
+		Rows:      rows,
+		Total:     strconv.FormatInt(page.Total, 10),
+		Page:      strconv.Itoa(page.Page),
+		Pages:     strconv.Itoa(max(page.Pages(), 1)),
+		SortHrefs: sortHrefs,
+	}
+	if page.Page > 1 {
+		list.PrevHref = urlsHref(query, map[string]string{"page": strconv.Itoa(page.Page - 1)})
+	}
+	if page.Page < page.Pages() {
+		list.NextHref = urlsHref(query, map[string]string{"page": strconv.Itoa(page.Page + 1)})
+	}
+
+	return Render(c, views.Urls(list))
+}
+
+func (uh *UrlsHandler) urlDetailHandler(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+	id := c.Params("id")
+
+	u, err := uh.Urls.GetById(ctx, id)
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusNotFound).
+			SendString("✖&nbsp;&nbsp; url not found")
+	}
+
+	terms, err := uh.Urls.GetTerms(ctx, id)
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	attempts, err := uh.Urls.GetAttempts(ctx, id)
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	history := make([]views.AttemptRow, len(attempts))
+	for i, a := range attempts {
+		result := "success"
+		if !a.Success {
+			result = fmt.Sprintf("%s: %s", a.FailureKind.Label(), a.FailureReason)
+		}
+		history[i] = views.AttemptRow{
+			CrawledAt:    a.CrawledAt.Format(time.DateTime),
+			Result:       result,
+			ResponseCode: strconv.Itoa(a.ResponseCode),
+			Duration:     a.CrawlDuration.Round(time.Millisecond).String(),
+		}
+	}
+
+	messages := map[string]string{
+		"recrawl": "The url has been crawled again, and will be indexed again in the next index run.",
+		"reindex": "The url will be indexed again in the next index run.",
+	}
+
+	return Render(c, views.UrlDetail(views.UrlInfo{
+		ID:              u.ID,
+		Url:             u.Url,
+		Status:          urlStatus(u),
+		ResponseCode:    strconv.Itoa(u.ResponseCode),
+		FailureReason:   u.FailureReason,
+		PageTitle:       u.PageTitle,
+		PageDescription: u.PageDescription,
+		Headings:        u.Headings,
+		ContentType:     u.ContentType,
+		Duration:        u.CrawlDuration.Round(time.Millisecond).String(),
+		LastTested:      formatTime(u.LastTested),
+		NextCrawlAt:     formatTime(u.NextCrawlAt),
+		Indexed:         u.Indexed,
+		Outlinks:        u.Outlinks,
+		Terms:           terms,
+		History:         history,
+		Message:         messages[c.Query("done")],
+	}))
+}
+
+func (uh *UrlsHandler) urlRecrawlHandler(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+	id := c.Params("id")
+
+	u, err := uh.Urls.GetById(ctx, id)
+	if err != nil {
+
+		return c.
+			Status(fiber.StatusNotFound).
+			SendString("✖&nbsp;&nbsp; url not found")
+	}
+
+	if err := uh.Recrawler.Recrawl(ctx, []services.CrawledUrl{u}); err != nil {
+
+		return c.
+			Status(fiber.StatusBadRequest).
+			SendString("✖&nbsp;&nbsp; " + err.Error())
+	}
+
+	return c.Status(fiber.StatusSeeOther).Redirect("/urls/" + id + "?done=recrawl")
+}
+
+func (uh *UrlsHandler) urlReindexHandler(c *fiber.Ctx) error {
+	id := c.Params("id")
+
+	if err := uh.Urls.MarkForReindex(c.UserContext(), id); err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	return c.Status(fiber.StatusSeeOther).Redirect("/urls/" + id + "?done=reindex")
+}
+
+func (uh *UrlsHandler) urlDeleteHandler(c *fiber.Ctx) error {
+	if err := uh.Urls.Delete(c.UserContext(), c.Params("id")); err != nil {
+
+		return c.
+			Status(fiber.StatusInternalServerError).
+			SendString("✖&nbsp;&nbsp; something went wrong")
+	}
+
+	return c.Status(fiber.StatusSeeOther).Redirect("/urls")
+}
+
+/********** Handlers for the Urls API **********/
+
+// apiUrlDetail is a url, with its terms and its crawl history.
+type apiUrlDetail struct {
+	Url      services.CrawledUrl     `json:"url"`
+	Status   string                  `json:"status"`
+	Terms    []string                `json:"terms"`
+	Attempts []services.CrawlAttempt `json:"attempts"`
+}
+
+func (uh *UrlsHandler) apiUrlsHandler(c *fiber.Ctx) error {
+	query := dto.UrlQueryDto{}
+	if err := c.QueryParser(&query); err != nil {
+
+		return apiFail(c, fiber.StatusBadRequest, "Invalid filters")
+	}
+
+	page, err := uh.Urls.List(c.UserContext(), urlFilter(query))
+	if err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not list the urls")
+	}
+
+	return apiOK(c, fiber.StatusOK, "Crawled urls", page)
+}
+
+func (uh *UrlsHandler) apiUrlHandler(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+	id := c.Params("id")
+
+	u, err := uh.Urls.GetById(ctx, id)
+	if err != nil {
+
+		return apiFail(c, fiber.StatusNotFound, "Url not found")
+	}
+
+	terms, err := uh.Urls.GetTerms(ctx, id)
+	if err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not get the terms of the url")
+	}
+
+	attempts, err := uh.Urls.GetAttempts(ctx, id)
+	if err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not get the crawl history of the url")
+	}
+
+	return apiOK(c, fiber.StatusOK, "Crawled url", apiUrlDetail{
+		Url:      u,
+		Status:   urlStatus(u),
+		Terms:    terms,
+		Attempts: attempts,
+	})
+}
+
+func (uh *UrlsHandler) apiUrlRecrawlHandler(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+	id := c.Params("id")
+
+	u, err := uh.Urls.GetById(ctx, id)
+	if err != nil {
+
+		return apiFail(c, fiber.StatusNotFound, "Url not found")
+	}
+
+	if err := uh.Recrawler.Recrawl(ctx, []services.CrawledUrl{u}); err != nil {
+
+		return apiFail(c, fiber.StatusBadRequest, err.Error())
+	}
+
+	// The url as it was stored by the crawl
+	u, err = uh.Urls.GetById(ctx, id)
+	if err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not get the url")
+	}
+
+	return apiOK(c, fiber.StatusOK, "Url crawled again", u)
+}
+
+func (uh *UrlsHandler) apiUrlReindexHandler(c *fiber.Ctx) error {
+	if err := uh.Urls.MarkForReindex(c.UserContext(), c.Params("id")); err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not mark the url for reindexing")
+	}
+
+	return apiOK(c, fiber.StatusAccepted, "Url will be indexed again in the next index run", nil)
+}
+
+func (uh *UrlsHandler) apiUrlDeleteHandler(c *fiber.Ctx) error {
+	if err := uh.Urls.Delete(c.UserContext(), c.Params("id")); err != nil {
+
+		return apiFail(c, fiber.StatusInternalServerError, "Could not delete the url")
+	}
+
+	return apiOK(c, fiber.StatusOK, "Url deleted", nil)
+}
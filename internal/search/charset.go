@@ -0,0 +1,33 @@
+package search
+
+import (
+	"bufio"
+	"io"
+
+	"golang.org/x/net/html/charset"
+)
+
+// charsetSniffLen is the number of bytes inspected to detect the charset,
+// as the `<meta charset>` must be within the first 1024 bytes of the page.
+const charsetSniffLen = 1024
+
+// decodeBody returns a reader of the body transcoded to UTF-8 and the name
+// of the detected charset. The charset is detected, in order of precedence,
+// from the byte order mark (BOM), the charset parameter of the Content-Type
+// header and the `<meta charset>` (or http-equiv) element of the page.
+// Without any of them, the content is assumed to be UTF-8 if it is valid
+// UTF-8, or Windows-1252 otherwise.
+func decodeBody(body io.Reader, contentType string) (io.Reader, string) {
+	br := bufio.NewReaderSize(body, charsetSniffLen)
+
+	// A body shorter than `charsetSniffLen` is returned entirely
+	peek, _ := br.Peek(charsetSniffLen)
+	enc, name, _ := charset.DetermineEncoding(peek, contentType)
+
+	return enc.NewDecoder().Reader(br), name
+}
+
+/* CHARACTER ENCODING DETECTION:
+https://html.spec.whatwg.org/multipage/parsing.html#determining-the-character-encoding
+https://pkg.go.dev/golang.org/x/net/html/charset#DetermineEncoding
+*/
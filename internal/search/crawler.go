@@ -1,22 +1,27 @@
 package search
 
 import (
+	"context"
+	"errors"
 	"io"
 	"log"
 	"math/rand"
-	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
+	"github.com/emarifer/search-engine/internal/services"
 	"golang.org/x/net/html"
 )
 
 type CrawlData struct {
 	ID           string
 	Url          string
+	FinalUrl     string // Url reached after following the redirects
+	Redirects    []RedirectHop
 	Success      bool
 	ResponseCode int
+	Failure      *CrawlFailure // Why the crawl was not successful
 	CrawlBody    ParsedBody
 }
 
@@ -25,6 +30,14 @@ type ParsedBody struct {
 	PageTitle       string
 	PageDescription string
 	Headings        string
+	CanonicalUrl    string
+	ContentType     string // MIME type of the document, without parameters
+	Content         string // Visible text of the document
+	Truncated       bool   // The document exceeded the limits and was cut
+	Fingerprint     Fingerprint
+	Robots          RobotsDirectives
+	Feeds           []string // RSS and Atom feeds announced by the page
+	StructuredData  services.StructuredData
 	Links           Links
 }
 
@@ -53,41 +66,41 @@ func randomUserAgent() string {
 	return userAgents[rand.Intn(len(userAgents))]
 }
 
-// makeRequest creates the request to the given url
-// and sets a random user agent for that request.
-// Returns an http response reference or an error.
-func makeRequest(url string) (*http.Response, error) {
-	client := http.Client{
-		Timeout: 10 * time.Second,
-	}
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Set("User-Agent", randomUserAgent())
-	if err != nil {
-		return nil, err
-	}
-	res, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	return res, nil
-}
-
-func runCrawl(id, inputUrl string) CrawlData {
+// runCrawl fetches the url with the given fetcher, following
+// its redirects, and parses the document it points to
+// within the given limits.
+func runCrawl(
+	ctx context.Context, f Fetcher, limits Limits, id, inputUrl string,
+) CrawlData {
 	// resp, err := http.Get(inputUrl)
-	resp, err := makeRequest(inputUrl)
-	baseUrl, _ := url.Parse(inputUrl)
+	resp, finalUrl, redirects, err := fetchFollowingRedirects(ctx, f, inputUrl, nil)
 	if err != nil || resp == nil {
 		log.Printf("something went wrong fetch the body: %s\n", err)
 
+		responseCode := 0
+		if len(redirects) > 0 {
+			responseCode = redirects[len(redirects)-1].StatusCode
+		}
+
+		// There is no final url when the redirects could not be followed
+		if errors.Is(err, ErrTooManyRedirects) ||
+			errors.Is(err, ErrRedirectLoop) {
+			finalUrl = ""
+		}
+
 		return CrawlData{
 			ID:           id,
 			Url:          inputUrl,
+			FinalUrl:     finalUrl,
+			Redirects:    redirects,
 			Success:      false,
-			ResponseCode: 0,
+			ResponseCode: responseCode,
+			Failure:      errorFailure(err),
 			CrawlBody:    ParsedBody{},
 		}
 	}
 	defer resp.Body.Close()
+	baseUrl, _ := url.Parse(finalUrl)
 
 	// Check if response code is not 200
 	if resp.StatusCode != 200 {
@@ -96,54 +109,93 @@ func runCrawl(id, inputUrl string) CrawlData {
 		return CrawlData{
 			ID:           id,
 			Url:          inputUrl,
+			FinalUrl:     finalUrl,
+			Redirects:    redirects,
 			Success:      false,
 			ResponseCode: resp.StatusCode,
+			Failure:      statusFailure(resp),
 			CrawlBody:    ParsedBody{},
 		}
 	}
 
-	// Check the content type is text/html
+	// Check there is a handler for the content type of the document
 	contentType := resp.Header.Get("Content-Type")
-	if strings.HasPrefix(contentType, "text/html") {
-		// response is HTML
-		data, err := parseBody(resp.Body, baseUrl)
-		if err != nil {
-			log.Printf(
-				"something went wrong getting data from html body: %s\n", err,
-			)
-
-			return CrawlData{
-				ID:           id,
-				Url:          inputUrl,
-				Success:      false,
-				ResponseCode: resp.StatusCode,
-				CrawlBody:    ParsedBody{},
-			}
-		}
+	handler, ok := documentHandlerFor(contentType)
+	if !ok {
+		log.Printf("unsupported content type detected: %q\n", contentType)
 
 		return CrawlData{
 			ID:           id,
 			Url:          inputUrl,
-			Success:      true,
+			FinalUrl:     finalUrl,
+			Redirects:    redirects,
+			Success:      false,
 			ResponseCode: resp.StatusCode,
-			CrawlBody:    data,
+			Failure: permanentFailure(
+				services.FailureContentType,
+				"unsupported content type: %q", mediaType(contentType),
+			),
+			CrawlBody: ParsedBody{},
 		}
+	}
 
-	} else {
-		// response is not HTML
-		log.Println("non html response detected")
+	// Only the allowed size of the body is read, but
+	// the partial document is parsed (and indexed) anyway
+	var body io.Reader = resp.Body
+	var truncating *truncatingReader
+	if limits.MaxBodySize > 0 {
+		truncating = newTruncatingReader(resp.Body, limits.MaxBodySize)
+		body = truncating
+	}
+
+	data, err := handler(body, contentType, baseUrl, limits)
+	if err != nil {
+		log.Printf(
+			"something went wrong getting data from the document: %s\n", err,
+		)
 
 		return CrawlData{
 			ID:           id,
 			Url:          inputUrl,
+			FinalUrl:     finalUrl,
+			Redirects:    redirects,
 			Success:      false,
 			ResponseCode: resp.StatusCode,
-			CrawlBody:    ParsedBody{},
+			Failure: documentFailure(
+				err, truncating != nil && truncating.truncated,
+			),
+			CrawlBody: ParsedBody{},
 		}
 	}
+
+	// The robots directives can also be sent in the headers
+	data.Robots = data.Robots.merge(
+		parseRobotsHeader(resp.Header.Values("X-Robots-Tag")),
+	)
+	data.ContentType = mediaType(contentType)
+	data.Content = truncateText(data.Content, maxContentLength)
+
+	links, dropped := data.Links.limit(limits.MaxLinks)
+	data.Links = links
+	if dropped || truncating != nil && truncating.truncated {
+		log.Printf("document truncated: %s\n", finalUrl)
+		data.Truncated = true
+	}
+
+	return CrawlData{
+		ID:           id,
+		Url:          inputUrl,
+		FinalUrl:     finalUrl,
+		Redirects:    redirects,
+		Success:      true,
+		ResponseCode: resp.StatusCode,
+		CrawlBody:    data,
+	}
 }
 
-func parseBody(body io.Reader, baseUrl *url.URL) (ParsedBody, error) {
+func parseBody(
+	body io.Reader, baseUrl *url.URL, limits Limits,
+) (ParsedBody, error) {
 	doc, err := html.Parse(body)
 	if err != nil {
 		log.Printf("something went wrong parsing body: %s\n", err)
@@ -151,11 +203,20 @@ func parseBody(body io.Reader, baseUrl *url.URL) (ParsedBody, error) {
 		return ParsedBody{}, err
 	}
 
-	linksch, titlech, descch, headingsch :=
+	// The deepest nodes are dropped, so that they cannot
+	// overflow the recursive functions that follow
+	pruned := pruneDepth(doc, limits.MaxDomDepth)
+
+	linksch, titlech, descch, headingsch, canonicalch, textch, robotsch, feedsch, sdch :=
 		make(chan Links),
 		make(chan string),
 		make(chan string),
-		make(chan string)
+		make(chan string),
+		make(chan string),
+		make(chan string),
+		make(chan RobotsDirectives),
+		make(chan []string),
+		make(chan services.StructuredData)
 
 	// Record timings
 	start := time.Now()
@@ -169,7 +230,24 @@ func parseBody(body io.Reader, baseUrl *url.URL) (ParsedBody, error) {
 	// Get the h1 tags for the page
 	go getPageHeadings(doc, headingsch)
 
-	links, title, desc, headings := <-linksch, <-titlech, <-descch, <-headingsch
+	// Get the canonical url declared by the page
+	go getCanonicalUrl(doc, baseUrl, canonicalch)
+
+	// Get the visible text of the page
+	go getPageText(doc, textch)
+
+	// Get the `<meta name="robots">` directives
+	go getRobotsMeta(doc, robotsch)
+
+	// Get the feeds announced by the page
+	go getFeedLinks(doc, baseUrl, feedsch)
+
+	// Get the OpenGraph tags, JSON-LD blocks and microdata
+	go getStructuredData(doc, baseUrl, sdch)
+
+	links, title, desc, headings, canonical, text, robots, feeds, sd :=
+		<-linksch, <-titlech, <-descch, <-headingsch,
+		<-canonicalch, <-textch, <-robotsch, <-feedsch, <-sdch
 
 	// Record timings
 	end := time.Now()
@@ -180,6 +258,13 @@ func parseBody(body io.Reader, baseUrl *url.URL) (ParsedBody, error) {
 		PageTitle:       title,
 		PageDescription: desc,
 		Headings:        headings,
+		CanonicalUrl:    canonical,
+		Content:         text,
+		Fingerprint:     newFingerprint(text),
+		Robots:          robots,
+		Feeds:           feeds,
+		StructuredData:  sd,
+		Truncated:       pruned,
 		Links:           links,
 	}, nil
 }
@@ -204,9 +289,7 @@ func checkUrlKind(url *url.URL) bool {
 	return strings.HasPrefix(uStr, "#") ||
 		strings.HasPrefix(uStr, "mail") ||
 		strings.HasPrefix(uStr, "tel") ||
-		strings.HasPrefix(uStr, "javascript") ||
-		strings.HasSuffix(uStr, ".pdf") ||
-		strings.HasSuffix(uStr, ".md")
+		strings.HasPrefix(uStr, "javascript")
 }
 
 // getLinks does a Depth First Search (DFS) of the html tree structure.
@@ -219,45 +302,25 @@ func getLinks(n *html.Node, baseUrl *url.URL, lch chan Links) {
 		return
 	}
 
+	// Set of the urls already found, to not add the same url twice
+	seen := map[string]struct{}{}
+
 	// Recursive search for "a" tags in the DOM tree
 	var findLinks func(*html.Node)
 	findLinks = func(n *html.Node) {
 		// Check if the current node is an `html.ElementNode`
 		// and if it has a tag name of "a" (i.e., an anchor tag).
 		if n.Type == html.ElementNode && n.Data == "a" {
+			// Links marked with rel="nofollow" must not be followed
+			for _, attr := range n.Attr {
+				if attr.Key == "rel" && hasRelNoFollow(attr.Val) {
+					return
+				}
+			}
+
 			for _, attr := range n.Attr {
 				if attr.Key == "href" {
-					url, err := url.Parse(attr.Val)
-					// Check for errors or if url is:
-					// 1) a hashtag/anchor,
-					// 2) is mail link,
-					// 3) is a telephone link,
-					// 4)is a javascript link,
-					// 5) is a PDF or MD file
-					if err != nil || checkUrlKind(url) {
-						continue
-					}
-
-					// If url is absolute then test if internal or extend
-					// before append. Else add the baseUrl append as internal
-					if url.IsAbs() {
-						if isSameHost(url.String(), baseUrl.String()) {
-							links.Internal = append(
-								links.Internal, url.String(),
-							)
-						} else {
-							links.External = append(
-								links.External, url.String(),
-							)
-						}
-					} else {
-						// Check to see example:
-						// https://go.dev/src/net/url/example_test.go
-						rel := baseUrl.ResolveReference(url)
-						links.Internal = append(
-							links.Internal, rel.String(),
-						)
-					}
+					appendLink(&links, seen, baseUrl, attr.Val)
 				}
 			}
 		}
@@ -274,6 +337,96 @@ func getLinks(n *html.Node, baseUrl *url.URL, lch chan Links) {
 	lch <- links
 }
 
+// appendLink adds the href to the internal or external links,
+// unless it was already seen or is not a link to crawl.
+func appendLink(
+	links *Links, seen map[string]struct{}, baseUrl *url.URL, href string,
+) {
+	url, err := url.Parse(strings.TrimSpace(href))
+	// Check for errors or if url is:
+	// 1) a hashtag/anchor,
+	// 2) is mail link,
+	// 3) is a telephone link,
+	// 4) is a javascript link
+	if err != nil || checkUrlKind(url) {
+		return
+	}
+
+	// Resolve relative urls against the baseUrl (see example:
+	// https://go.dev/src/net/url/example_test.go) and
+	// normalize them so that equivalent forms are only
+	// added once
+	normalized, err := services.NormalizeUrl(
+		baseUrl.ResolveReference(url).String(),
+	)
+	if err != nil {
+		return
+	}
+	if _, ok := seen[normalized]; ok {
+		return
+	}
+	seen[normalized] = struct{}{}
+
+	// Test if the url is internal or external before append
+	if isSameHost(normalized, baseUrl.String()) {
+		links.Internal = append(links.Internal, normalized)
+	} else {
+		links.External = append(links.External, normalized)
+	}
+}
+
+// getCanonicalUrl looks for the `<link rel="canonical">` element and
+// sends its normalized href, or an empty string if the page does not
+// declare one or if it is the page itself.
+func getCanonicalUrl(n *html.Node, baseUrl *url.URL, cch chan string) {
+	canonical := ""
+	if n == nil {
+		cch <- canonical
+		return
+	}
+
+	var findCanonical func(*html.Node)
+	findCanonical = func(n *html.Node) {
+		if canonical != "" {
+			return
+		}
+
+		if n.Type == html.ElementNode && n.Data == "link" {
+			var rel, href string
+			for _, attr := range n.Attr {
+				if attr.Key == "rel" {
+					rel = strings.ToLower(strings.TrimSpace(attr.Val))
+				} else if attr.Key == "href" {
+					href = strings.TrimSpace(attr.Val)
+				}
+			}
+
+			if rel == "canonical" && href != "" {
+				if u, err := url.Parse(href); err == nil {
+					canonical, _ = services.NormalizeUrl(
+						baseUrl.ResolveReference(u).String(),
+					)
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			findCanonical(c)
+		}
+	}
+
+	// We call the previously defined function
+	findCanonical(n)
+
+	// A page pointing to itself is already canonical
+	if self, err := services.NormalizeUrl(baseUrl.String()); err == nil &&
+		self == canonical {
+		canonical = ""
+	}
+
+	cch <- canonical
+}
+
 func getPageData(n *html.Node, tch, dch chan string) {
 	title, desc := "", ""
 	if n == nil {
@@ -357,6 +510,46 @@ func getPageHeadings(n *html.Node, hch chan string) {
 	hch <- strings.TrimSuffix(headings.String(), ", ")
 }
 
+// getPageText concatenates the text nodes of the page,
+// skipping those that are not rendered, like scripts or styles.
+func getPageText(n *html.Node, tch chan string) {
+	if n == nil {
+		tch <- ""
+		return
+	}
+
+	var (
+		text     strings.Builder
+		findText func(*html.Node)
+	)
+
+	// Recursive search for text nodes
+	findText = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.Data {
+			case "script", "style", "noscript", "template", "head":
+				return
+			}
+		}
+
+		if n.Type == html.TextNode {
+			if t := strings.Join(strings.Fields(n.Data), " "); t != "" {
+				text.WriteString(t)
+				text.WriteString(" ")
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			findText(c)
+		}
+	}
+
+	// We call the previously defined function
+	findText(n)
+
+	tch <- strings.TrimSpace(text.String())
+}
+
 /* DEPTH-FIRST SEARCH ALGORITHM IN GO:
 https://reintech.io/blog/depth-first-search-algorithm-in-go
 https://www.tutorialspoint.com/golang-program-to-implement-depth-first-search
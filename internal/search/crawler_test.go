@@ -31,12 +31,12 @@ func TestParseBody(t *testing.T) {
 	expectedPageDesc := "Page Description"
 	expectedHeadings := "Heading 1"
 	expectedInternalLinks := []string{
-		"https://example.com", "https://example.com/internal",
+		"https://example.com/", "https://example.com/internal",
 	}
-	expectedExternalLinks := []string{"https://external.com"}
+	expectedExternalLinks := []string{"https://external.com/"}
 
 	// Call the function `parseBody`
-	result, err := parseBody(body, baseUrl)
+	result, err := parseBody(body, baseUrl, Limits{})
 
 	// Check for errors
 	if err != nil {
@@ -103,6 +103,9 @@ func TestGetLinks(t *testing.T) {
 				<a href="javascript:void(0)">JavaScript Link</a>
 				<a href="document.pdf">PDF Link</a>
 				<a href="document.md">MD Link</a>
+				<a href="/internal#top">Same Link With Fragment</a>
+				<a href="/sponsored" rel="sponsored nofollow">NoFollow Link</a>
+				<a href="HTTPS://External.com:443/?utm_source=x">Same External Link</a>
 			</body>
 		</html>
 	`))
@@ -110,10 +113,12 @@ func TestGetLinks(t *testing.T) {
 	baseUrl, _ := url.Parse("https://example.com")
 
 	expectedInternal := []string{
-		"https://example.com",
+		"https://example.com/",
 		"https://example.com/internal",
+		"https://example.com/document.pdf",
+		"https://example.com/document.md",
 	}
-	expectedExternal := []string{"https://external.com"}
+	expectedExternal := []string{"https://external.com/"}
 
 	linksch := make(chan Links)
 
@@ -188,6 +193,48 @@ func TestIsSameHost(t *testing.T) {
 	}
 }
 
+func TestGetCanonicalUrl(t *testing.T) {
+	// Define test cases
+	testCases := []struct {
+		html     string
+		expected string
+	}{
+		{
+			`<head><link rel="canonical" href="/original?b=2&a=1#top"></head>`,
+			"https://example.com/original?a=1&b=2",
+		},
+		{
+			`<head><link rel="canonical" href="https://EXAMPLE.com/page"></head>`,
+			"",
+		},
+		{`<head><link rel="stylesheet" href="/main.css"></head>`, ""},
+		{`<head><link rel="canonical" href="mailto:a@b.c"></head>`, ""},
+	}
+
+	baseUrl, _ := url.Parse("https://example.com/page")
+
+	// Iterate over test cases
+	for _, tc := range testCases {
+		doc, _ := html.Parse(strings.NewReader(tc.html))
+		canonicalch := make(chan string)
+
+		// Call the function `getCanonicalUrl`
+		go getCanonicalUrl(doc, baseUrl, canonicalch)
+
+		result := <-canonicalch
+
+		// Compare the result with the expected value
+		if result != tc.expected {
+			t.Errorf(
+				"For html '%s', expected '%s', but got '%s'",
+				tc.html,
+				tc.expected,
+				result,
+			)
+		}
+	}
+}
+
 func TestGetPageData(t *testing.T) {
 	// Create a sample HTML node
 	doc, _ := html.Parse(strings.NewReader(`
@@ -258,6 +305,80 @@ func TestGetPageHeadings(t *testing.T) {
 	}
 }
 
+func TestDecodeBody(t *testing.T) {
+	// UTF-16LE with BOM of "<title>Ñu</title>"
+	utf16Body := "\xff\xfe"
+	for _, r := range "<title>Ñu</title>" {
+		utf16Body += string([]byte{byte(r), byte(r >> 8)})
+	}
+
+	// Define test cases
+	testCases := []struct {
+		name          string
+		contentType   string
+		body          string
+		expectedTitle string
+	}{
+		{
+			"ISO-8859-1 from header",
+			"text/html; charset=ISO-8859-1",
+			"<html><head><title>Caf\xe9</title></head></html>",
+			"Café",
+		},
+		{
+			"Windows-1251 from meta charset",
+			"text/html",
+			`<html><head><meta charset="windows-1251">` +
+				"<title>\xcf\xf0\xe8\xe2\xe5\xf2</title></head></html>",
+			"Привет",
+		},
+		{
+			"Shift_JIS from meta http-equiv",
+			"text/html",
+			`<html><head><meta http-equiv="Content-Type" ` +
+				`content="text/html; charset=Shift_JIS">` +
+				"<title>\x93\xfa\x96\x7b</title></head></html>",
+			"日本",
+		},
+		{
+			"UTF-16LE from BOM, overriding the header",
+			"text/html; charset=ISO-8859-1",
+			utf16Body,
+			"Ñu",
+		},
+		{
+			"UTF-8 without declaration",
+			"text/html",
+			"<html><head><title>Ñandú</title></head></html>",
+			"Ñandú",
+		},
+	}
+
+	baseUrl, _ := url.Parse("https://example.com")
+
+	// Iterate over test cases
+	for _, tc := range testCases {
+		// Call the function `decodeBody` and parse the result
+		body, _ := decodeBody(strings.NewReader(tc.body), tc.contentType)
+		result, err := parseBody(body, baseUrl, Limits{})
+
+		// Check for errors
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+		}
+
+		// Compare the page title result with the expected value
+		if result.PageTitle != tc.expectedTitle {
+			t.Errorf(
+				"%s: expected page title '%s', but got '%s'",
+				tc.name,
+				tc.expectedTitle,
+				result.PageTitle,
+			)
+		}
+	}
+}
+
 /* 3 WAYS TO COMPARE SLICES:
 https://yourbasic.org/golang/compare-slices/
 https://go.dev/play/p/tzl9Z3ofn3W
@@ -0,0 +1,289 @@
+package search
+
+import (
+	"bufio"
+	"encoding/xml"
+	"io"
+	"mime"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// maxContentLength is the maximum number of characters
+	// of the document text that are stored and indexed.
+	maxContentLength = 20_000
+	// maxDocumentSize is the maximum number of bytes decompressed
+	// from all the streams of a PDF, and of the text taken from them.
+	maxDocumentSize = 10 << 20
+	// descriptionLength is the length of the description taken
+	// from the text of the documents that do not declare one.
+	descriptionLength = 160
+)
+
+// DocumentHandler parses a document of a given MIME type into the common
+// `ParsedBody` structure. It receives the full Content-Type header (e.g.
+// to get the charset), the url of the document, to resolve its links, and
+// the limits of the crawl. The body is already cut to the maximum size.
+type DocumentHandler func(
+	body io.Reader, contentType string, baseUrl *url.URL, limits Limits,
+) (ParsedBody, error)
+
+var (
+	handlersMu       sync.RWMutex
+	documentHandlers = map[string]DocumentHandler{
+		"text/html":             parseHtml,
+		"application/xhtml+xml": parseHtml,
+		"text/plain":            parsePlainText,
+		"text/markdown":         parseMarkdown,
+		"text/x-markdown":       parseMarkdown,
+		"application/pdf":       parsePdf,
+		"application/xml":       parseXml,
+		"text/xml":              parseXml,
+	}
+)
+
+// RegisterDocumentHandler adds (or replaces) the handler
+// of the documents of the given MIME type.
+func RegisterDocumentHandler(mimeType string, handler DocumentHandler) {
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+
+	documentHandlers[strings.ToLower(mimeType)] = handler
+}
+
+// documentHandlerFor returns the handler for the given Content-Type header.
+func documentHandlerFor(contentType string) (DocumentHandler, bool) {
+	handlersMu.RLock()
+	defer handlersMu.RUnlock()
+
+	handler, ok := documentHandlers[mediaType(contentType)]
+
+	return handler, ok
+}
+
+// mediaType returns the MIME type of a Content-Type header, without
+// parameters. Servers that do not send it are assumed to send HTML.
+func mediaType(contentType string) string {
+	if strings.TrimSpace(contentType) == "" {
+		return "text/html"
+	}
+
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt, _, _ = strings.Cut(contentType, ";")
+	}
+
+	return strings.ToLower(strings.TrimSpace(mt))
+}
+
+// truncateText cuts the text to the given number of characters.
+func truncateText(text string, length int) string {
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+
+	return string([]rune(text)[:length])
+}
+
+// excerpt returns the beginning of the text, cut at
+// a word boundary, to be used as a description.
+func excerpt(text string, length int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+
+	cut := string([]rune(text)[:length])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+
+	return cut + "…"
+}
+
+// parseHtml transcodes the page to UTF-8 and parses it.
+func parseHtml(
+	body io.Reader, contentType string, baseUrl *url.URL, limits Limits,
+) (ParsedBody, error) {
+	decoded, _ := decodeBody(body, contentType)
+
+	return parseBody(decoded, baseUrl, limits)
+}
+
+// parsePlainText uses the first line of the text as its title.
+func parsePlainText(
+	body io.Reader, contentType string, baseUrl *url.URL, limits Limits,
+) (ParsedBody, error) {
+	start := time.Now()
+
+	decoded, _ := decodeBody(body, contentType)
+	data, err := io.ReadAll(decoded)
+	if err != nil {
+		return ParsedBody{}, err
+	}
+	text := strings.TrimSpace(string(data))
+
+	title, _, _ := strings.Cut(text, "\n")
+
+	return ParsedBody{
+		CrawlTime:       time.Since(start),
+		PageTitle:       truncateText(strings.TrimSpace(title), descriptionLength),
+		PageDescription: excerpt(text, descriptionLength),
+		Content:         text,
+		Fingerprint:     newFingerprint(text),
+	}, nil
+}
+
+var (
+	mdHeadingRe  = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdLinkRe     = regexp.MustCompile(`!?\[([^\]]*)\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"]*")?\s*\)`)
+	mdAutoLinkRe = regexp.MustCompile(`<(https?://[^>\s]+)>`)
+	mdMarkupRe   = regexp.MustCompile("[*_`~]+|^\\s{0,3}(?:>|[-+*]|\\d+[.)])\\s+")
+)
+
+// parseMarkdown takes the first level-1 heading as the title, the
+// level-1 headings as the headings and the inline links and autolinks.
+func parseMarkdown(
+	body io.Reader, contentType string, baseUrl *url.URL, limits Limits,
+) (ParsedBody, error) {
+	start := time.Now()
+
+	decoded, _ := decodeBody(body, contentType)
+
+	var (
+		title    string
+		headings []string
+		text     strings.Builder
+		links    Links
+		seen     = map[string]struct{}{}
+		inCode   bool
+	)
+
+	scanner := bufio.NewScanner(decoded)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// Code blocks are kept as they are
+		if strings.HasPrefix(strings.TrimSpace(line), "```") {
+			inCode = !inCode
+			continue
+		}
+
+		if !inCode {
+			if m := mdHeadingRe.FindStringSubmatch(line); m != nil {
+				line = m[2]
+				if len(m[1]) == 1 {
+					heading := mdMarkupRe.ReplaceAllString(
+						mdLinkRe.ReplaceAllString(line, "$1"), "",
+					)
+					headings = append(headings, heading)
+					if title == "" {
+						title = heading
+					}
+				}
+			}
+
+			for _, m := range mdLinkRe.FindAllStringSubmatch(line, -1) {
+				if !strings.HasPrefix(m[0], "!") {
+					appendLink(&links, seen, baseUrl, m[2])
+				}
+			}
+			for _, m := range mdAutoLinkRe.FindAllStringSubmatch(line, -1) {
+				appendLink(&links, seen, baseUrl, m[1])
+			}
+
+			line = mdLinkRe.ReplaceAllString(line, "$1")
+			line = mdAutoLinkRe.ReplaceAllString(line, "$1")
+			line = mdMarkupRe.ReplaceAllString(line, "")
+		}
+
+		text.WriteString(line)
+		text.WriteString("\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return ParsedBody{}, err
+	}
+
+	content := strings.TrimSpace(text.String())
+	if title == "" {
+		title, _, _ = strings.Cut(content, "\n")
+	}
+
+	return ParsedBody{
+		CrawlTime:       time.Since(start),
+		PageTitle:       truncateText(strings.TrimSpace(title), descriptionLength),
+		PageDescription: excerpt(content, descriptionLength),
+		Headings:        strings.Join(headings, ", "),
+		Content:         content,
+		Fingerprint:     newFingerprint(content),
+		Links:           links,
+	}, nil
+}
+
+// parseXml concatenates the character data of the document
+// and takes the first `<title>` element as its title.
+func parseXml(
+	body io.Reader, contentType string, baseUrl *url.URL, limits Limits,
+) (ParsedBody, error) {
+	start := time.Now()
+
+	decoder := xml.NewDecoder(body)
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false
+
+	var (
+		title   string
+		inTitle bool
+		text    strings.Builder
+	)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return ParsedBody{}, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			inTitle = title == "" && strings.EqualFold(t.Name.Local, "title")
+		case xml.EndElement:
+			inTitle = false
+		case xml.CharData:
+			s := strings.Join(strings.Fields(string(t)), " ")
+			if s == "" {
+				continue
+			}
+			if inTitle {
+				title = s
+			}
+			text.WriteString(s)
+			text.WriteString(" ")
+		}
+	}
+
+	content := strings.TrimSpace(text.String())
+
+	return ParsedBody{
+		CrawlTime:       time.Since(start),
+		PageTitle:       truncateText(title, descriptionLength),
+		PageDescription: excerpt(content, descriptionLength),
+		Content:         content,
+		Fingerprint:     newFingerprint(content),
+	}, nil
+}
+
+/* MEDIA TYPES:
+https://developer.mozilla.org/en-US/docs/Web/HTTP/Basics_of_HTTP/MIME_types/Common_types
+https://datatracker.ietf.org/doc/html/rfc7763 (text/markdown)
+https://spec.commonmark.org/
+*/
@@ -0,0 +1,165 @@
+package search
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMediaType(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		expected    string
+	}{
+		{"", "text/html"},
+		{"text/html; charset=utf-8", "text/html"},
+		{"Application/PDF", "application/pdf"},
+		{"text/markdown;variant=GFM", "text/markdown"},
+		{"text/plain;;", "text/plain"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.contentType, func(st *testing.T) {
+			assert.Equal(st, tc.expected, mediaType(tc.contentType))
+		})
+	}
+}
+
+func TestDocumentHandlerFor(t *testing.T) {
+	_, ok := documentHandlerFor("image/png")
+	assert.False(t, ok)
+
+	RegisterDocumentHandler("Image/PNG", parsePlainText)
+	defer func() {
+		handlersMu.Lock()
+		delete(documentHandlers, "image/png")
+		handlersMu.Unlock()
+	}()
+
+	_, ok = documentHandlerFor("image/png")
+	assert.True(t, ok)
+	_, ok = documentHandlerFor("text/html; charset=iso-8859-1")
+	assert.True(t, ok)
+}
+
+func TestParsePlainText(t *testing.T) {
+	baseUrl, _ := url.Parse("https://example.com/notes.txt")
+	data, err := parsePlainText(
+		strings.NewReader("\n  Release notes\nVersion 2 fixes the crawler.\n"),
+		"text/plain", baseUrl, Limits{},
+	)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Release notes", data.PageTitle)
+	assert.Equal(t, "Release notes Version 2 fixes the crawler.", data.PageDescription)
+	assert.Equal(t, "Release notes\nVersion 2 fixes the crawler.", data.Content)
+}
+
+func TestParseMarkdown(t *testing.T) {
+	baseUrl, _ := url.Parse("https://example.com/docs/readme.md")
+	body := "Intro line\n" +
+		"# Search *Engine* #\n" +
+		"Read the [guide](user_guide.md \"Guide\") or <https://external.com/>.\n" +
+		"![logo](logo.png)\n" +
+		"```\n" +
+		"# not a heading [x](ignored.md)\n" +
+		"```\n" +
+		"## Install\n" +
+		"- Run `make`\n"
+
+	data, err := parseMarkdown(strings.NewReader(body), "text/markdown", baseUrl, Limits{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Search Engine", data.PageTitle)
+	assert.Equal(t, "Search Engine", data.Headings)
+	assert.Equal(t, []string{"https://example.com/docs/user_guide.md"}, data.Links.Internal)
+	assert.Equal(t, []string{"https://external.com/"}, data.Links.External)
+	assert.Contains(t, data.Content, "Read the guide or https://external.com/.")
+	assert.Contains(t, data.Content, "# not a heading [x](ignored.md)")
+	assert.Contains(t, data.Content, "Run make")
+}
+
+func TestParseXml(t *testing.T) {
+	baseUrl, _ := url.Parse("https://example.com/data.xml")
+	body := `<?xml version="1.0" encoding="ISO-8859-1"?>
+		<book><title>Caf` + "\xe9" + `</title><chapter>First  chapter</chapter></book>`
+
+	data, err := parseXml(strings.NewReader(body), "application/xml", baseUrl, Limits{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "Café", data.PageTitle)
+	assert.Equal(t, "Café First chapter", data.Content)
+}
+
+// buildPdf returns a minimal PDF with an info dictionary,
+// a compressed content stream and a font (which is skipped).
+func buildPdf(t *testing.T, title string) []byte {
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	fmt.Fprint(w, "BT /F1 12 Tf 72 712 Td (Annual \\(2024\\) report) Tj "+
+		"0 -14 Td [(Sales) -250 (grew)] TJ T* <FEFF00E900E9> Tj ET")
+	w.Close()
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	fmt.Fprintf(&pdf, "1 0 obj\n<< /Title %s >>\nendobj\n", title)
+	pdf.WriteString("2 0 obj\n<< /Type /Font /Length 9 >>\nstream\n(ignored)\nendstream\nendobj\n")
+	fmt.Fprintf(&pdf, "3 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", compressed.Len())
+	pdf.Write(compressed.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n%%EOF\n")
+
+	return pdf.Bytes()
+}
+
+func TestParsePdf(t *testing.T) {
+	baseUrl, _ := url.Parse("https://example.com/files/report.pdf")
+
+	data, err := parsePdf(
+		bytes.NewReader(buildPdf(t, "(Annual report)")), "application/pdf", baseUrl, Limits{},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "Annual report", data.PageTitle)
+	assert.Equal(t, "Annual (2024) report Sales grew éé", data.Content)
+	assert.NotContains(t, data.Content, "ignored")
+
+	// Without a title, the file name is used
+	data, err = parsePdf(
+		bytes.NewReader(buildPdf(t, "()")), "application/pdf", baseUrl, Limits{},
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, "report.pdf", data.PageTitle)
+}
+
+func TestParsePdfBomb(t *testing.T) {
+	baseUrl, _ := url.Parse("https://example.com/files/bomb.pdf")
+
+	// Each stream expands 1 MB of text from a few KB
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	fmt.Fprint(w, "BT (")
+	fmt.Fprint(w, strings.Repeat("a", 1<<20))
+	fmt.Fprint(w, ") Tj ET")
+	w.Close()
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	for i := range 200 {
+		fmt.Fprintf(&pdf, "%d 0 obj\n<< /Length %d /Filter /FlateDecode >>\nstream\n", i+1, compressed.Len())
+		pdf.Write(compressed.Bytes())
+		pdf.WriteString("\nendstream\nendobj\n")
+	}
+	pdf.WriteString("%%EOF\n")
+	assert.Less(t, pdf.Len(), 1<<20)
+
+	// Only the budget of the whole document is decompressed
+	data, err := parsePdf(bytes.NewReader(pdf.Bytes()), "application/pdf", baseUrl, Limits{})
+	assert.NoError(t, err)
+	assert.True(t, data.Truncated)
+	assert.LessOrEqual(t, len(data.Content), maxDocumentSize)
+	assert.Greater(t, len(data.Content), maxDocumentSize/2)
+}
@@ -1,50 +1,258 @@
 package search
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/emarifer/search-engine/internal/services"
 )
 
+/********** Stores used by the engine **********/
+
+type SettingsStore interface {
+	Get(ctx context.Context) (services.SearchSettings, error)
+}
+
+type UrlStore interface {
+	UpdateUrl(ctx context.Context, input services.CrawledUrl) error
+	UpdateFailedCrawl(ctx context.Context, input services.CrawledUrl) error
+	GetNextCrawlUrls(ctx context.Context, limit uint, scope services.CrawlScope) ([]services.CrawledUrl, error)
+	Save(ctx context.Context, input *services.CrawledUrl) error
+	SaveWithHints(ctx context.Context, input services.CrawledUrl) (services.CrawledUrl, bool, error)
+	FindOrCreate(ctx context.Context, rawUrl string) (services.CrawledUrl, error)
+	GetNotIndexed(ctx context.Context) ([]services.CrawledUrl, error)
+	SetIndexedTrue(ctx context.Context, urls []services.CrawledUrl, readAt time.Time) error
+	GetFingerprints(ctx context.Context) ([]services.CrawledUrl, error)
+	SetDuplicateOf(ctx context.Context, urls []services.CrawledUrl) error
+	SaveAttempt(ctx context.Context, input services.CrawlAttempt) error
+}
+
+type SitemapStore interface {
+	HasHost(ctx context.Context, host string) (bool, error)
+	Save(ctx context.Context, input *services.Sitemap) error
+	GetDue(ctx context.Context, before time.Time, limit uint) ([]services.Sitemap, error)
+	UpdateFetched(ctx context.Context, input services.Sitemap) error
+}
+
+type FeedStore interface {
+	Save(ctx context.Context, input *services.Feed) error
+	GetDue(ctx context.Context, before time.Time, limit uint) ([]services.Feed, error)
+	UpdatePolled(ctx context.Context, input services.Feed) error
+}
+
+type RuleStore interface {
+	GetAll(ctx context.Context) ([]services.CrawlRule, error)
+}
+
+type IndexStore interface {
+	Save(ctx context.Context, i map[string][]string, crUrls []services.CrawledUrl) error
+}
+
+// Engine crawls and indexes the urls of the stores. All the requests
+// are made with its `Fetcher`, so that it can run against test servers.
+type Engine struct {
+	Settings SettingsStore
+	Urls     UrlStore
+	Sitemaps SitemapStore
+	Feeds    FeedStore
+	Index    IndexStore
+	Rules    RuleStore
+	Fetcher  Fetcher
+	Events   *EventBus // Progress of the runs, if anyone listens
+}
+
+func NewEngine(
+	sss SettingsStore,
+	us UrlStore,
+	sms SitemapStore,
+	fs FeedStore,
+	sis IndexStore,
+	rs RuleStore,
+	f Fetcher,
+	events *EventBus,
+) *Engine {
+
+	return &Engine{
+		Settings: sss,
+		Urls:     us,
+		Sitemaps: sms,
+		Feeds:    fs,
+		Index:    sis,
+		Rules:    rs,
+		Fetcher:  f,
+		Events:   events,
+	}
+}
+
+const (
+	// persistTimeout is the time given to store the results
+	// of a run after it is cancelled or reaches its deadline.
+	persistTimeout = 30 * time.Second
+	// defaultCrawlTimeout and defaultRequestTimeout are used
+	// when the timeouts are not set in the settings.
+	defaultCrawlTimeout   = 50 * time.Minute
+	defaultRequestTimeout = defaultTimeout
+)
+
+// crawlTimeout returns the deadline of each crawl run.
+func crawlTimeout(settings services.SearchSettings) time.Duration {
+	if settings.CrawlTimeout == 0 {
+		return defaultCrawlTimeout
+	}
+
+	return time.Duration(settings.CrawlTimeout) * time.Minute
+}
+
+// requestTimeout returns the time limit of each request.
+func requestTimeout(settings services.SearchSettings) time.Duration {
+	if settings.RequestTimeout == 0 {
+		return defaultRequestTimeout
+	}
+
+	return time.Duration(settings.RequestTimeout) * time.Second
+}
+
+// crawlScope returns the rules that decide which urls are added and crawled.
+func (e *Engine) crawlScope(
+	ctx context.Context, settings services.SearchSettings,
+) (services.CrawlScope, error) {
+	rules, err := e.Rules.GetAll(ctx)
+	if err != nil {
+		return services.CrawlScope{}, err
+	}
+
+	scope := services.NewCrawlScope(rules, settings.ScopeOn)
+	if scope.ScopeOn && !scope.HasAllowRules() {
+		log.Println("scope mode is on, but there are no allow rules")
+	}
+
+	return scope, nil
+}
+
+// ErrSearchOff is returned by the runs that
+// are not made while the search is turned off.
+var ErrSearchOff = errors.New("search is turned off")
+
+// RunStats counts what a run of the engine did.
+type RunStats struct {
+	Queued  int // Urls to crawl or to index
+	Fetched int // Urls crawled successfully
+	Failed  int // Urls whose crawl failed
+	Added   int // New urls found
+	Indexed int // Urls added to the index
+}
+
 func loggerEndEngine(s time.Time) {
 	endEngine := time.Now()
 	log.Printf("🏁 Search engine crawl has finished at %v\n", endEngine.Sub(s))
 }
 
-func RunEngine(
-	sss *services.SearchSettingsServices, us *services.UrlServices,
-) {
+// RunEngine crawls the next urls until they are all crawled, the context
+// is cancelled or the deadline of the run is reached. The results of the
+// urls crawled so far are always stored, and the rest are left untested.
+func (e *Engine) RunEngine(ctx context.Context) (RunStats, error) {
 	startEngine := time.Now()
 	log.Println("🚀 Started search engine crawl…")
 
 	defer loggerEndEngine(startEngine)
 
 	// Get crawl settings from DB
-	_, err := sss.Get()
+	settings, err := e.Settings.Get(ctx)
 	if err != nil {
-		fmt.Printf("something went wrong getting the settings: %s\n", err)
-
-		return
+		return RunStats{}, fmt.Errorf("settings not found: %s", err)
 	}
 
 	// Check if search is turned on by checking settings
-	if !sss.SearchSettings.SearchOn {
+	if !settings.SearchOn {
 		fmt.Println("search is turned off")
 
-		return
+		return RunStats{}, ErrSearchOff
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, crawlTimeout(settings))
+	defer cancel()
+
+	scope, err := e.crawlScope(ctx, settings)
+	if err != nil {
+		return RunStats{}, fmt.Errorf("crawl rules not found: %s", err)
 	}
 
 	// Get next X urls to be tested
-	nextUrls, err := us.GetNextCrawlUrls(sss.SearchSettings.Amount)
+	nextUrls, err := e.Urls.GetNextCrawlUrls(ctx, settings.Amount, scope)
 	if err != nil {
-		fmt.Printf("something went wrong getting next urls: %s\n", err)
+		return RunStats{}, fmt.Errorf("next urls not found: %s", err)
+	}
 
-		return
+	stats := e.crawl(ctx, settings, scope, nextUrls)
+	if ctx.Err() != nil {
+		return stats, fmt.Errorf("crawl interrupted: %s", ctx.Err())
 	}
 
+	return stats, nil
+}
+
+// Recrawl crawls the given urls right away, as asked by the admin, even
+// if the search is turned off. The urls denied by the rules are not crawled.
+func (e *Engine) Recrawl(ctx context.Context, urls []services.CrawledUrl) error {
+	settings, err := e.Settings.Get(ctx)
+	if err != nil {
+		return fmt.Errorf("settings not found: %s", err)
+	}
+
+	scope, err := e.crawlScope(ctx, settings)
+	if err != nil {
+		return fmt.Errorf("crawl rules not found: %s", err)
+	}
+	for _, u := range urls {
+		if !scope.Allows(u.Url) {
+			return fmt.Errorf("the url is not allowed by the crawl rules: %s", u.Url)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, crawlTimeout(settings))
+	defer cancel()
+
+	e.crawl(ctx, settings, scope, urls)
+
+	return nil
+}
+
+// crawl crawls the urls concurrently and stores the results, the new urls
+// found in the pages (if allowed by the settings and the scope) and the
+// urls listed in the sitemaps of the crawled hosts.
+func (e *Engine) crawl(
+	ctx context.Context,
+	settings services.SearchSettings,
+	scope services.CrawlScope,
+	nextUrls []services.CrawledUrl,
+) RunStats {
+	// The results are stored with a context that is not cancelled
+	// with the run, so that no crawled url is lost
+	persistCtx, cancelPersist := context.WithTimeout(
+		context.WithoutCancel(ctx), crawlTimeout(settings)+persistTimeout,
+	)
+	defer cancelPersist()
+
+	fetcher := withTimeout(e.Fetcher, requestTimeout(settings))
+	limits := limitsOf(settings)
+	robots := newRobotsCache(fetcher)
+	stats := RunStats{Queued: len(nextUrls)}
+
+	start := time.Now()
+	e.Events.Publish(Event{Run: RunCrawl, Kind: EventRunStarted, Count: len(nextUrls)})
+	defer func() {
+		message := fmt.Sprintf("finished in %s", time.Since(start).Round(time.Millisecond))
+		if ctx.Err() != nil {
+			message = fmt.Sprintf("interrupted: %s", ctx.Err())
+		}
+		e.Events.Publish(Event{Run: RunCrawl, Kind: EventRunFinished, Message: message})
+	}()
+
 	/* === START OF WORKER POOLS IMPLEMENTATION === */
 
 	jobs := make(chan services.CrawledUrl, len(nextUrls))
@@ -52,7 +260,34 @@ func RunEngine(
 
 	worker := func(wg *sync.WaitGroup) {
 		for job := range jobs {
-			results <- runCrawl(job.ID, job.Url)
+			// The pending urls are left for the next run
+			if ctx.Err() != nil {
+				continue
+			}
+
+			// The urls disallowed by the robots.txt of their host are not fetched
+			if failure := robots.check(ctx, job.Url); failure != nil {
+				if ctx.Err() == nil {
+					results <- CrawlData{
+						ID:      job.ID,
+						Url:     job.Url,
+						Success: false,
+						Failure: failure,
+					}
+				}
+
+				continue
+			}
+
+			result := runCrawl(ctx, fetcher, limits, job.ID, job.Url)
+
+			// A crawl interrupted by the end of the run
+			// is not a failure of the url
+			if !result.Success && ctx.Err() != nil {
+				continue
+			}
+
+			results <- result
 		}
 		wg.Done()
 	}
@@ -77,33 +312,130 @@ func RunEngine(
 	newUrls := []services.CrawledUrl{}
 	testedTime := time.Now()
 
+	// The change frequency of each url (from its sitemap)
+	// sets when it must be crawled again
+	changeFreqs := map[string]string{}
+	// and the retries of each url after a transient failure,
+	// when it must be retried
+	retryCounts := map[string]int{}
+	for _, u := range nextUrls {
+		changeFreqs[u.ID] = u.ChangeFreq
+		retryCounts[u.ID] = u.RetryCount
+	}
+
+	// Hosts successfully crawled, whose sitemaps will be looked for
+	hosts := []string{}
+	seenHosts := map[string]struct{}{}
+
+	// Feeds announced by the crawled pages
+	feeds := []string{}
+
+	// addNewUrl pushes the url to `newUrls` only once, even if it is found
+	// in several of the crawled pages, and only if the rules allow it
+	seenUrls := map[string]struct{}{}
+	addNewUrl := func(u string) {
+		if _, ok := seenUrls[u]; ok {
+			return
+		}
+		seenUrls[u] = struct{}{}
+		if !scope.Allows(u) {
+			return
+		}
+		newUrls = append(newUrls, services.CrawledUrl{Url: u})
+	}
+
 	crawlResult := func(done chan bool) {
 		for result := range results {
+			// A redirected url is stored as an alias of the final url,
+			// which is the one that receives the crawled data
+			if len(result.Redirects) > 0 && result.FinalUrl != "" {
+				final, inScope, err := saveRedirectAlias(
+					persistCtx, e.Urls, scope, result, testedTime,
+				)
+				if err != nil {
+					fmt.Printf(
+						"something went wrong saving the redirect of %s: %s\n",
+						result.Url, err,
+					)
+
+					continue
+				}
+
+				// The data of a final url denied by the rules is not stored
+				if !inScope {
+					e.saveAttempt(persistCtx, final, nil, testedTime)
+					stats.Fetched++
+					e.Events.Publish(Event{
+						Run:   RunCrawl,
+						Kind:  EventUrlFetched,
+						Url:   final.Url,
+						Count: final.ResponseCode,
+					})
+
+					continue
+				}
+				result = final
+			}
+
 			// Check if the crawl was not successul
 			if !result.Success {
-				// Update row in database with the failed crawl
-				err := us.UpdateUrl(services.CrawledUrl{
-					ID:              result.ID,
-					Url:             result.Url,
-					Success:         false,
-					CrawlDuration:   result.CrawlBody.CrawlTime,
-					ResponseCode:    result.ResponseCode,
-					PageTitle:       result.CrawlBody.PageTitle,
-					PageDescription: result.CrawlBody.PageDescription,
-					Headings:        result.CrawlBody.Headings,
-					LastTested:      &testedTime,
+				failure := result.Failure
+				if failure == nil {
+					failure = permanentFailure(services.FailureOther, "unknown error")
+				}
+
+				// The transient failures are retried with backoff,
+				// until they are taken as permanent
+				retryCount := retryCounts[result.ID]
+				var retryAt *time.Time
+				if failure.Transient && retryCount < maxRetries {
+					retryCount++
+					next := testedTime.Add(
+						retryDelay(retryCount, failure.RetryAfter),
+					)
+					retryAt = &next
+				}
+
+				// Update row in database with the failed crawl, keeping
+				// the document of the last successful one, if any
+				err := e.Urls.UpdateFailedCrawl(persistCtx, services.CrawledUrl{
+					ID:            result.ID,
+					Success:       false,
+					ResponseCode:  result.ResponseCode,
+					RedirectChain: formatRedirectChain(result.Redirects),
+					RetryCount:    retryCount,
+					FailureKind:   failure.Kind,
+					FailureReason: failure.Reason,
+					LastTested:    &testedTime,
+					NextCrawlAt:   retryAt,
 				})
 				if err != nil {
 					fmt.Printf(
 						"something went wrong updating a failed url: %s\n", err,
 					)
 				}
+				e.saveAttempt(persistCtx, result, failure, testedTime)
+				stats.Failed++
+				e.Events.Publish(Event{
+					Run:     RunCrawl,
+					Kind:    EventUrlFailed,
+					Url:     result.Url,
+					Count:   result.ResponseCode,
+					Message: failure.Reason,
+				})
 
 				continue
 			}
 
+			// No copy of the text is kept of the pages that asked
+			// not to, which are only indexed by their metadata
+			content := result.CrawlBody.Content
+			if result.CrawlBody.Robots.NoArchive {
+				content = ""
+			}
+
 			// Update a successful row in database
-			err := us.UpdateUrl(services.CrawledUrl{
+			err := e.Urls.UpdateUrl(persistCtx, services.CrawledUrl{
 				ID:              result.ID,
 				Url:             result.Url,
 				Success:         result.Success,
@@ -112,17 +444,56 @@ func RunEngine(
 				PageTitle:       result.CrawlBody.PageTitle,
 				PageDescription: result.CrawlBody.PageDescription,
 				Headings:        result.CrawlBody.Headings,
-				LastTested:      &testedTime,
+				ContentType:     result.CrawlBody.ContentType,
+				Content:         content,
+				CanonicalUrl:    result.CrawlBody.CanonicalUrl,
+				ContentHash:     result.CrawlBody.Fingerprint.ContentHash,
+				SimHash:         int64(result.CrawlBody.Fingerprint.SimHash),
+				NoIndex:         result.CrawlBody.Robots.NoIndex,
+				NoArchive:       result.CrawlBody.Robots.NoArchive,
+				StructuredData:  result.CrawlBody.StructuredData,
+				Outlinks: slices.Concat(
+					result.CrawlBody.Links.Internal,
+					result.CrawlBody.Links.External,
+				),
+				Truncated:   result.CrawlBody.Truncated,
+				LastTested:  &testedTime,
+				NextCrawlAt: nextCrawlAt(changeFreqs[result.ID], testedTime),
 			})
 			if err != nil {
 				fmt.Printf(
 					"something went wrong updating %s\n", result.Url,
 				)
 			}
+			e.saveAttempt(persistCtx, result, nil, testedTime)
+			stats.Fetched++
+			e.Events.Publish(Event{
+				Run:   RunCrawl,
+				Kind:  EventUrlFetched,
+				Url:   result.Url,
+				Count: result.ResponseCode,
+			})
 
-			// Push the newly found external urls to a slice
-			for _, newUrl := range result.CrawlBody.Links.External {
-				newUrls = append(newUrls, services.CrawledUrl{Url: newUrl})
+			if host := hostOf(result.Url); host != "" {
+				if _, ok := seenHosts[host]; !ok {
+					seenHosts[host] = struct{}{}
+					hosts = append(hosts, host)
+				}
+			}
+
+			feeds = append(feeds, result.CrawlBody.Feeds...)
+
+			// Push the newly found external urls to a slice,
+			// unless the page asked not to follow its links
+			if !result.CrawlBody.Robots.NoFollow {
+				for _, newUrl := range result.CrawlBody.Links.External {
+					addNewUrl(newUrl)
+				}
+			}
+
+			// The canonical version of the page must be crawled instead
+			if result.CrawlBody.CanonicalUrl != "" {
+				addNewUrl(result.CrawlBody.CanonicalUrl)
 			}
 		} // End of loop
 
@@ -149,11 +520,18 @@ func RunEngine(
 
 	/* === END OF WORKER POOLS IMPLEMENTATION === */
 
+	if ctx.Err() != nil {
+		log.Printf("search engine crawl interrupted: %s\n", ctx.Err())
+	}
+
+	// Store the discovered feeds, which are polled by `RunFeeds`
+	saveFeeds(persistCtx, e.Feeds, feeds)
+
 	// Check if we should add the newly found urls to the database
-	if !sss.SearchSettings.AddNew {
+	if !settings.AddNew {
 		fmt.Println("Adding new urls to database is disabled")
 
-		return
+		return stats
 	}
 
 	countNotAdded := 0
@@ -162,7 +540,7 @@ func RunEngine(
 		fmt.Printf("something went wrong adding new urls to DB: %s\n", err)
 	} */
 	for _, newUrl := range newUrls {
-		err := us.Save(&newUrl)
+		err := e.Urls.Save(persistCtx, &newUrl)
 		if err != nil {
 			countNotAdded++
 			fmt.Printf(
@@ -172,46 +550,242 @@ func RunEngine(
 		}
 	}
 
-	fmt.Printf(
-		"\nAdded %d new urls to database\n\n", len(newUrls)-countNotAdded,
-	)
+	stats.Added = len(newUrls) - countNotAdded
+	fmt.Printf("\nAdded %d new urls to database\n\n", stats.Added)
+	e.Events.Publish(Event{
+		Run:     RunCrawl,
+		Kind:    EventUrlsAdded,
+		Count:   stats.Added,
+		Message: "from links",
+	})
+
+	// Add the urls listed in the sitemaps of the crawled hosts
+	if ctx.Err() != nil {
+		return stats
+	}
+	added := ingestSitemaps(ctx, fetcher, e.Sitemaps, e.Urls, scope, hosts)
+	stats.Added += added
+	fmt.Printf("Added %d new urls from sitemaps\n\n", added)
+	e.Events.Publish(Event{
+		Run:     RunCrawl,
+		Kind:    EventUrlsAdded,
+		Count:   added,
+		Message: "from sitemaps",
+	})
+
+	return stats
+}
+
+// saveAttempt adds the crawl to the history of the url.
+func (e *Engine) saveAttempt(
+	ctx context.Context, result CrawlData, failure *CrawlFailure, crawledAt time.Time,
+) {
+	attempt := services.CrawlAttempt{
+		CrawledUrlID:  result.ID,
+		Success:       result.Success,
+		ResponseCode:  result.ResponseCode,
+		CrawlDuration: result.CrawlBody.CrawlTime,
+		CrawledAt:     crawledAt,
+	}
+	if failure != nil {
+		attempt.FailureKind = failure.Kind
+		attempt.FailureReason = failure.Reason
+	}
+
+	if err := e.Urls.SaveAttempt(ctx, attempt); err != nil {
+		fmt.Printf("something went wrong saving the crawl of %s: %s\n", result.Url, err)
+	}
 }
 
-func RunIndex(us *services.UrlServices, sis *services.SearchIndexServices) {
+// RunIndex indexes the crawled urls that are not indexed yet. If the
+// context is cancelled before the index is saved, nothing is stored.
+func (e *Engine) RunIndex(ctx context.Context) (stats RunStats, runErr error) {
 	log.Println("🚀 Started search indexing…")
 
 	defer log.Println("🏁 Search indexing has finished")
 
-	// Get index settings from DB - Get all urls that are not indexed
-	notIndexed, err := us.GetNotIndexed()
-	if err != nil {
-		fmt.Println("something went wrong getting the not indexed urls:", err)
+	start := time.Now()
+	defer func() {
+		message := fmt.Sprintf("finished in %s", time.Since(start).Round(time.Millisecond))
+		if runErr != nil {
+			message = fmt.Sprintf("failed: %s", runErr)
+		}
+		e.Events.Publish(Event{Run: RunIndex, Kind: EventRunFinished, Message: message})
+	}()
 
-		return
+	// Get index settings from DB - Get all urls that are not indexed.
+	// Those changed after they are read are not marked as indexed
+	readAt := time.Now()
+	notIndexed, err := e.Urls.GetNotIndexed(ctx)
+	if err != nil {
+		return stats, fmt.Errorf("not indexed urls not found: %s", err)
 	}
+	stats.Queued = len(notIndexed)
 	fmt.Println("not indexed urls:", len(notIndexed))
+	e.Events.Publish(Event{Run: RunIndex, Kind: EventRunStarted, Count: len(notIndexed)})
+
+	// Cluster the near-duplicates so that only
+	// the canonical member of each cluster is indexed
+	if err := markDuplicates(ctx, e.Urls, notIndexed); err != nil {
+		return stats, fmt.Errorf("duplicated urls not detected: %s", err)
+	}
+	e.Events.Publish(Event{
+		Run:     RunIndex,
+		Kind:    EventIndexUpdated,
+		Message: "duplicates detected",
+	})
 
 	// Create a new index map
 	idx := make(Index)
 
-	// Add the not indexed urls to the index
-	idx.Add(notIndexed)
+	// Add the not indexed urls to the index,
+	// except those that are not the canonical version of the page
+	// or that duplicate the content of another one
+	toIndex := indexable(notIndexed)
+	idx.Add(toIndex)
 
 	// Save the index to DB
-	err = sis.Save(idx, notIndexed)
+	err = e.Index.Save(ctx, idx, notIndexed)
+	if err != nil {
+		return stats, fmt.Errorf("index not saved: %s", err)
+	}
+	stats.Indexed = len(toIndex)
+	e.Events.Publish(Event{
+		Run:     RunIndex,
+		Kind:    EventIndexUpdated,
+		Count:   len(notIndexed),
+		Message: fmt.Sprintf("index saved (%d terms)", len(idx)),
+	})
+
+	// Update the urls to be indexed=true, even if the
+	// context is cancelled now that the index is saved
+	persistCtx, cancel := context.WithTimeout(
+		context.WithoutCancel(ctx), persistTimeout,
+	)
+	defer cancel()
+	err = e.Urls.SetIndexedTrue(persistCtx, notIndexed, readAt)
+	if err != nil {
+		return stats, fmt.Errorf("indexed urls not updated: %s", err)
+	}
+
+	return stats, nil
+}
+
+// saveRedirectAlias marks the url of the result as a redirect alias
+// pointing to its final url, and returns the result for the final url,
+// which is created if it is not stored yet, and whether the rules allow it.
+func saveRedirectAlias(
+	ctx context.Context, us UrlStore, scope services.CrawlScope,
+	result CrawlData, testedTime time.Time,
+) (CrawlData, bool, error) {
+	// A final url denied by the rules is neither added nor crawled,
+	// but the url is still recorded as a redirect to it
+	if !scope.Allows(result.FinalUrl) {
+		finalUrl, err := services.NormalizeUrl(result.FinalUrl)
+		if err != nil {
+			finalUrl = result.FinalUrl
+		}
+		result.ResponseCode = result.Redirects[0].StatusCode
+		err = us.UpdateUrl(ctx, services.CrawledUrl{
+			ID:            result.ID,
+			Url:           result.Url,
+			Success:       true,
+			ResponseCode:  result.ResponseCode,
+			RedirectTo:    finalUrl,
+			RedirectChain: formatRedirectChain(result.Redirects),
+			LastTested:    &testedTime,
+		})
+		if err != nil {
+			return CrawlData{}, false, err
+		}
+		result.Success = true
+
+		return result, false, nil
+	}
+
+	final, err := us.FindOrCreate(ctx, result.FinalUrl)
 	if err != nil {
-		fmt.Println("something went wrong saving the index:", err)
+		return CrawlData{}, false, err
+	}
 
-		return
+	// The url may redirect to an equivalent form of itself
+	if final.ID == result.ID {
+		return result, true, nil
+	}
+
+	err = us.UpdateUrl(ctx, services.CrawledUrl{
+		ID:            result.ID,
+		Url:           result.Url,
+		Success:       result.Success,
+		ResponseCode:  result.Redirects[0].StatusCode,
+		RedirectTo:    final.Url,
+		RedirectChain: formatRedirectChain(result.Redirects),
+		LastTested:    &testedTime,
+	})
+	if err != nil {
+		return CrawlData{}, false, err
 	}
 
-	// Update the urls to be indexed=true
-	err = us.SetIndexedTrue(notIndexed)
+	result.ID = final.ID
+	result.Url = final.Url
+	result.Redirects = nil
+
+	return result, true, nil
+}
+
+// markDuplicates clusters all the crawled urls by their content
+// fingerprint and stores the canonical member of each url that changed
+// of cluster. It also updates the given not indexed urls in place.
+func markDuplicates(
+	ctx context.Context, us UrlStore, notIndexed []services.CrawledUrl,
+) error {
+	fingerprints, err := us.GetFingerprints(ctx)
 	if err != nil {
-		fmt.Println("something went wrong updating the indexed urls:", err)
+		return err
+	}
+
+	dups := clusterDuplicates(fingerprints)
+
+	changed := []services.CrawledUrl{}
+	for _, f := range fingerprints {
+		if dups[f.ID] != f.DuplicateOf {
+			f.DuplicateOf = dups[f.ID]
+			changed = append(changed, f)
+		}
+	}
+	if err := us.SetDuplicateOf(ctx, changed); err != nil {
+		return err
+	}
+	fmt.Println("duplicated urls:", len(dups))
+
+	for i := range notIndexed {
+		notIndexed[i].DuplicateOf = dups[notIndexed[i].ID]
+	}
+
+	return nil
+}
+
+// indexable returns the urls whose content should be added to the index.
+func indexable(urls []services.CrawledUrl) []services.CrawledUrl {
+	docs := make([]services.CrawledUrl, 0, len(urls))
+	for _, u := range urls {
+		if u.CanonicalUrl != "" && u.CanonicalUrl != u.Url {
+			continue
+		}
 
-		return
+		if u.DuplicateOf != "" || u.RedirectTo != "" || u.NoIndex {
+			continue
+		}
+
+		// The urls never crawled successfully have no document
+		if !u.Success && u.ContentType == "" {
+			continue
+		}
+
+		docs = append(docs, u)
 	}
+
+	return docs
 }
 
 /* REFERENCES ABOUT WORKER POOLS:
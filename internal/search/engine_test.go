@@ -0,0 +1,1065 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/stretchr/testify/assert"
+)
+
+/********** In-memory stores **********/
+
+type fakeSettings struct {
+	settings services.SearchSettings
+}
+
+func (fs *fakeSettings) Get(ctx context.Context) (services.SearchSettings, error) {
+	return fs.settings, nil
+}
+
+type fakeUrls struct {
+	mu       sync.Mutex
+	urls     []*services.CrawledUrl
+	attempts []services.CrawlAttempt
+}
+
+func (fu *fakeUrls) find(rawUrl string) *services.CrawledUrl {
+	for _, u := range fu.urls {
+		if u.Url == rawUrl {
+			return u
+		}
+	}
+
+	return nil
+}
+
+func (fu *fakeUrls) get(rawUrl string) services.CrawledUrl {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	if u := fu.find(rawUrl); u != nil {
+		return *u
+	}
+
+	return services.CrawledUrl{}
+}
+
+func (fu *fakeUrls) add(input services.CrawledUrl) (*services.CrawledUrl, error) {
+	normalized, err := services.NormalizeUrl(input.Url)
+	if err != nil {
+		return nil, err
+	}
+	if fu.find(normalized) != nil {
+		return nil, fmt.Errorf("duplicated url: %s", normalized)
+	}
+
+	input.ID = fmt.Sprintf("id-%d", len(fu.urls))
+	input.Url = normalized
+	input.CreatedAt = time.Now().Add(time.Duration(len(fu.urls)) * time.Millisecond)
+	fu.urls = append(fu.urls, &input)
+
+	return &input, nil
+}
+
+func (fu *fakeUrls) UpdateUrl(ctx context.Context, input services.CrawledUrl) error {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	// Only the columns selected by `UrlServices.UpdateUrl` are written
+	for _, u := range fu.urls {
+		if u.ID == input.ID {
+			u.Url = input.Url
+			u.Success = input.Success
+			u.CrawlDuration = input.CrawlDuration
+			u.ResponseCode = input.ResponseCode
+			u.PageTitle = input.PageTitle
+			u.PageDescription = input.PageDescription
+			u.Headings = input.Headings
+			u.ContentType = input.ContentType
+			u.Content = input.Content
+			u.CanonicalUrl = input.CanonicalUrl
+			u.ContentHash = input.ContentHash
+			u.SimHash = input.SimHash
+			u.NoIndex = input.NoIndex
+			u.NoArchive = input.NoArchive
+			u.RedirectTo = input.RedirectTo
+			u.RedirectChain = input.RedirectChain
+			u.StructuredData = input.StructuredData
+			u.Outlinks = input.Outlinks
+			u.Truncated = input.Truncated
+			u.RetryCount = input.RetryCount
+			u.FailureKind = input.FailureKind
+			u.FailureReason = input.FailureReason
+			u.LastTested = input.LastTested
+			u.NextCrawlAt = input.NextCrawlAt
+			u.Indexed = input.Indexed
+			u.UpdatedAt = time.Now()
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("url not found: %s", input.ID)
+}
+
+func (fu *fakeUrls) UpdateFailedCrawl(ctx context.Context, input services.CrawledUrl) error {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	// Only the columns selected by `UrlServices.UpdateFailedCrawl` are written
+	for _, u := range fu.urls {
+		if u.ID == input.ID {
+			u.Success = input.Success
+			u.ResponseCode = input.ResponseCode
+			u.RetryCount = input.RetryCount
+			u.FailureKind = input.FailureKind
+			u.FailureReason = input.FailureReason
+			u.RedirectChain = input.RedirectChain
+			u.LastTested = input.LastTested
+			u.NextCrawlAt = input.NextCrawlAt
+			u.UpdatedAt = time.Now()
+
+			return nil
+		}
+	}
+
+	return fmt.Errorf("url not found: %s", input.ID)
+}
+
+func (fu *fakeUrls) GetNextCrawlUrls(ctx context.Context, limit uint, scope services.CrawlScope) ([]services.CrawledUrl, error) {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	// As `UrlServices.GetNextCrawlUrls`: the due urls, not paused
+	// and in scope, by priority and then by age, up to the limit
+	now := time.Now()
+	next := []services.CrawledUrl{}
+	for _, u := range fu.urls {
+		due := u.LastTested == nil || (u.NextCrawlAt != nil && !u.NextCrawlAt.After(now))
+		if due && !u.Paused && scope.Allows(u.Url) {
+			next = append(next, *u)
+		}
+	}
+	sort.SliceStable(next, func(i, j int) bool {
+		if next[i].Priority != next[j].Priority {
+			return next[i].Priority > next[j].Priority
+		}
+		return next[i].CreatedAt.Before(next[j].CreatedAt)
+	})
+
+	return next[:min(len(next), int(limit))], nil
+}
+
+func (fu *fakeUrls) Save(ctx context.Context, input *services.CrawledUrl) error {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	_, err := fu.add(*input)
+
+	return err
+}
+
+func (fu *fakeUrls) SaveWithHints(ctx context.Context, input services.CrawledUrl) (services.CrawledUrl, bool, error) {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	if normalized, err := services.NormalizeUrl(input.Url); err == nil {
+		if u := fu.find(normalized); u != nil {
+			return *u, false, nil
+		}
+	}
+	u, err := fu.add(input)
+	if err != nil {
+		return services.CrawledUrl{}, false, err
+	}
+
+	return *u, true, nil
+}
+
+func (fu *fakeUrls) FindOrCreate(ctx context.Context, rawUrl string) (services.CrawledUrl, error) {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	normalized, err := services.NormalizeUrl(rawUrl)
+	if err != nil {
+		return services.CrawledUrl{}, err
+	}
+	if u := fu.find(normalized); u != nil {
+		return *u, nil
+	}
+	u, err := fu.add(services.CrawledUrl{Url: normalized})
+	if err != nil {
+		return services.CrawledUrl{}, err
+	}
+
+	return *u, nil
+}
+
+func (fu *fakeUrls) GetNotIndexed(ctx context.Context) ([]services.CrawledUrl, error) {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	urls := []services.CrawledUrl{}
+	for _, u := range fu.urls {
+		if !u.Indexed && u.LastTested != nil {
+			urls = append(urls, *u)
+		}
+	}
+
+	return urls, nil
+}
+
+func (fu *fakeUrls) SetIndexedTrue(ctx context.Context, urls []services.CrawledUrl, readAt time.Time) error {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	for _, indexed := range urls {
+		for _, u := range fu.urls {
+			if u.ID == indexed.ID && !u.UpdatedAt.After(readAt) {
+				u.Indexed = true
+			}
+		}
+	}
+
+	return nil
+}
+
+func (fu *fakeUrls) GetFingerprints(ctx context.Context) ([]services.CrawledUrl, error) {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	urls := []services.CrawledUrl{}
+	for _, u := range fu.urls {
+		if u.ContentHash != "" {
+			urls = append(urls, *u)
+		}
+	}
+
+	return urls, nil
+}
+
+func (fu *fakeUrls) SetDuplicateOf(ctx context.Context, urls []services.CrawledUrl) error {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	for _, dup := range urls {
+		for _, u := range fu.urls {
+			if u.ID == dup.ID {
+				// As `UrlServices.SetDuplicateOf`, a former
+				// duplicate is indexed in the next index run
+				if u.DuplicateOf != "" && dup.DuplicateOf == "" {
+					u.Indexed = false
+				}
+				u.DuplicateOf = dup.DuplicateOf
+			}
+		}
+	}
+
+	return nil
+}
+
+func (fu *fakeUrls) SaveAttempt(ctx context.Context, input services.CrawlAttempt) error {
+	fu.mu.Lock()
+	defer fu.mu.Unlock()
+
+	fu.attempts = append(fu.attempts, input)
+
+	return nil
+}
+
+type fakeSitemaps struct {
+	sitemaps []services.Sitemap
+}
+
+func (fs *fakeSitemaps) HasHost(ctx context.Context, host string) (bool, error) {
+	for _, s := range fs.sitemaps {
+		if s.Host == host {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (fs *fakeSitemaps) Save(ctx context.Context, input *services.Sitemap) error {
+	for _, s := range fs.sitemaps {
+		if s.Url == input.Url {
+			return nil
+		}
+	}
+	fs.sitemaps = append(fs.sitemaps, *input)
+
+	return nil
+}
+
+func (fs *fakeSitemaps) GetDue(ctx context.Context, before time.Time, limit uint) ([]services.Sitemap, error) {
+	due := []services.Sitemap{}
+	for _, s := range fs.sitemaps {
+		if s.LastFetched == nil || s.LastFetched.Before(before) {
+			due = append(due, s)
+		}
+	}
+
+	return due, nil
+}
+
+func (fs *fakeSitemaps) UpdateFetched(ctx context.Context, input services.Sitemap) error {
+	for i, s := range fs.sitemaps {
+		if s.Url == input.Url {
+			fs.sitemaps[i] = input
+		}
+	}
+
+	return nil
+}
+
+type fakeFeeds struct {
+	feeds []services.Feed
+}
+
+func (ff *fakeFeeds) Save(ctx context.Context, input *services.Feed) error {
+	for _, f := range ff.feeds {
+		if f.Url == input.Url {
+			return nil
+		}
+	}
+	ff.feeds = append(ff.feeds, *input)
+
+	return nil
+}
+
+func (ff *fakeFeeds) GetDue(ctx context.Context, before time.Time, limit uint) ([]services.Feed, error) {
+	due := []services.Feed{}
+	for _, f := range ff.feeds {
+		if f.LastPolled == nil || f.LastPolled.Before(before) {
+			due = append(due, f)
+		}
+	}
+
+	return due, nil
+}
+
+func (ff *fakeFeeds) UpdatePolled(ctx context.Context, input services.Feed) error {
+	for i, f := range ff.feeds {
+		if f.Url == input.Url {
+			ff.feeds[i] = input
+		}
+	}
+
+	return nil
+}
+
+type fakeIndex struct {
+	index map[string][]string
+}
+
+// Save merges the index as `SearchIndexServices.Save` does:
+// the urls lose their previous terms before the new ones are added.
+func (fi *fakeIndex) Save(ctx context.Context, i map[string][]string, crUrls []services.CrawledUrl) error {
+	if fi.index == nil {
+		fi.index = map[string][]string{}
+	}
+	for value, ids := range fi.index {
+		ids = slices.DeleteFunc(ids, func(id string) bool {
+			return slices.ContainsFunc(crUrls, func(u services.CrawledUrl) bool {
+				return u.ID == id
+			})
+		})
+		if len(ids) == 0 {
+			delete(fi.index, value)
+		} else {
+			fi.index[value] = ids
+		}
+	}
+	for value, ids := range i {
+		fi.index[value] = append(fi.index[value], ids...)
+	}
+
+	return nil
+}
+
+type fakeRules struct {
+	rules []services.CrawlRule
+}
+
+func (fr *fakeRules) GetAll(ctx context.Context) ([]services.CrawlRule, error) {
+	return fr.rules, nil
+}
+
+/********** Fixtures **********/
+
+// fixtureFetcher answers the requests with recorded responses,
+// without any network access.
+type fixtureFetcher map[string]string
+
+func (ff fixtureFetcher) Fetch(ctx context.Context, url string, headers http.Header) (*http.Response, error) {
+	body, ok := ff[url]
+	if !ok {
+		return &http.Response{
+			StatusCode: http.StatusNotFound,
+			Header:     http.Header{},
+			Body:       io.NopCloser(strings.NewReader("")),
+		}, nil
+	}
+
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html; charset=utf-8"}},
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}, nil
+}
+
+func newTestEngine(f Fetcher, seeds ...string) (*Engine, *fakeUrls) {
+	urls := &fakeUrls{}
+	for _, seed := range seeds {
+		urls.Save(context.Background(), &services.CrawledUrl{Url: seed})
+	}
+
+	e := NewEngine(
+		&fakeSettings{services.SearchSettings{SearchOn: true, AddNew: true, Amount: 10}},
+		urls,
+		&fakeSitemaps{},
+		&fakeFeeds{},
+		&fakeIndex{},
+		&fakeRules{},
+		f,
+		nil,
+	)
+
+	return e, urls
+}
+
+func TestRunEngine(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<html><head>
+			<title>Home page</title>
+			<meta name="description" content="The home page">
+			<link rel="alternate" type="application/rss+xml" href="/feed.xml">
+		</head><body>
+			<h1>Welcome</h1>
+			<a href="/about">About</a>
+			<a href="https://external.test/page">External</a>
+			<a href="https://external.test/ad" rel="nofollow">Ad</a>
+		</body></html>`)
+	})
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/notes.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprint(w, "Release notes\nThe crawler can be tested.")
+	})
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "User-agent: *\nSitemap: http://%s/sitemap.xml\n", r.Host)
+	})
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+			<url><loc>http://%s/about</loc><priority>0.8</priority></url>
+		</urlset>`, r.Host)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	e, urls := newTestEngine(
+		NewHttpFetcher(time.Second),
+		server.URL+"/",
+		server.URL+"/old",
+		server.URL+"/missing",
+		server.URL+"/notes.txt",
+	)
+
+	e.RunEngine(context.Background())
+
+	home := urls.get(server.URL + "/")
+	assert.True(t, home.Success)
+	assert.Equal(t, 200, home.ResponseCode)
+	assert.Equal(t, "Home page", home.PageTitle)
+	assert.Equal(t, "The home page", home.PageDescription)
+	assert.Equal(t, "Welcome", home.Headings)
+	assert.NotNil(t, home.LastTested)
+
+	old := urls.get(server.URL + "/old")
+	assert.Equal(t, server.URL+"/", old.RedirectTo)
+	assert.Equal(t, 301, old.ResponseCode)
+
+	missing := urls.get(server.URL + "/missing")
+	assert.False(t, missing.Success)
+	assert.Equal(t, 404, missing.ResponseCode)
+
+	notes := urls.get(server.URL + "/notes.txt")
+	assert.True(t, notes.Success)
+	assert.Equal(t, "text/plain", notes.ContentType)
+	assert.Equal(t, "Release notes", notes.PageTitle)
+
+	// External links are added, unless they are not followed
+	assert.NotEmpty(t, urls.get("https://external.test/page").ID)
+	assert.Empty(t, urls.get("https://external.test/ad").ID)
+
+	// The sitemap of the host adds its internal urls
+	about := urls.get(server.URL + "/about")
+	assert.NotEmpty(t, about.ID)
+	assert.Equal(t, 0.8, about.Priority)
+
+	feeds := e.Feeds.(*fakeFeeds).feeds
+	assert.Len(t, feeds, 1)
+	assert.Equal(t, server.URL+"/feed.xml", feeds[0].Url)
+
+	// The crawled documents are then indexed
+	e.RunIndex(context.Background())
+
+	index := e.Index.(*fakeIndex).index
+	assert.Equal(t, []string{home.ID}, index["welcom"])
+	assert.Equal(t, []string{notes.ID}, index["crawler"])
+	assert.True(t, urls.get(server.URL+"/").Indexed)
+}
+
+func TestRunEngineWithFixtures(t *testing.T) {
+	e, urls := newTestEngine(fixtureFetcher{
+		"https://example.com/": `<html><head><title>Example</title></head>
+			<body><a href="https://other.com/">Other</a></body></html>`,
+	}, "https://example.com/")
+
+	// Adding new urls is turned off
+	e.Settings = &fakeSettings{services.SearchSettings{SearchOn: true, Amount: 10}}
+
+	e.RunEngine(context.Background())
+
+	assert.Equal(t, "Example", urls.get("https://example.com/").PageTitle)
+	assert.Empty(t, urls.get("https://other.com/").ID)
+	assert.Empty(t, e.Sitemaps.(*fakeSitemaps).sitemaps)
+}
+
+// cancellingFetcher cancels the run when the `hang` url is requested,
+// once the rest of the urls (but robots.txt) were fetched, and hangs
+// until then.
+type cancellingFetcher struct {
+	fixtureFetcher
+	hang    string
+	fetched chan struct{}
+	cancel  context.CancelFunc
+}
+
+func (cf cancellingFetcher) Fetch(
+	ctx context.Context, url string, headers http.Header,
+) (*http.Response, error) {
+	if strings.HasSuffix(url, "/robots.txt") {
+		return cf.fixtureFetcher.Fetch(ctx, url, headers)
+	}
+	if url != cf.hang {
+		defer close(cf.fetched)
+
+		return cf.fixtureFetcher.Fetch(ctx, url, headers)
+	}
+
+	<-cf.fetched
+	cf.cancel()
+	<-ctx.Done()
+
+	return nil, ctx.Err()
+}
+
+func TestRunEngineCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	e, urls := newTestEngine(cancellingFetcher{
+		fixtureFetcher: fixtureFetcher{
+			"https://example.com/": `<html><head><title>Example</title></head>
+				<body><a href="https://other.com/">Other</a></body></html>`,
+		},
+		hang:    "https://example.com/hang",
+		fetched: make(chan struct{}),
+		cancel:  cancel,
+	}, "https://example.com/", "https://example.com/hang")
+
+	e.RunEngine(ctx)
+
+	// The url crawled before the cancellation and its links are stored
+	assert.Equal(t, "Example", urls.get("https://example.com/").PageTitle)
+	assert.NotEmpty(t, urls.get("https://other.com/").ID)
+
+	// The interrupted url is left untested, to be crawled in the next run
+	hang := urls.get("https://example.com/hang")
+	assert.Nil(t, hang.LastTested)
+	assert.Equal(t, 0, hang.ResponseCode)
+
+	// and no sitemaps are looked for
+	assert.Empty(t, e.Sitemaps.(*fakeSitemaps).sitemaps)
+}
+
+func TestRunEngineRequestTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+		},
+	))
+	defer server.Close()
+
+	e, urls := newTestEngine(NewHttpFetcher(time.Minute), server.URL+"/slow")
+	e.Settings = &fakeSettings{services.SearchSettings{
+		SearchOn: true, Amount: 10, RequestTimeout: 1,
+	}}
+
+	start := time.Now()
+	e.RunEngine(context.Background())
+
+	// The timed out url is stored as a failure, as the run was not cancelled
+	assert.Less(t, time.Since(start), 4*time.Second)
+	slow := urls.get(server.URL + "/slow")
+	assert.False(t, slow.Success)
+	assert.NotNil(t, slow.LastTested)
+}
+
+func TestRunEngineTruncated(t *testing.T) {
+	e, urls := newTestEngine(fixtureFetcher{
+		"https://example.com/big": `<html><head><title>Big</title></head>
+			<body><p>Beginning</p>` + strings.Repeat("<p>filler</p>", 1<<17),
+	}, "https://example.com/big")
+	e.Settings = &fakeSettings{services.SearchSettings{
+		SearchOn: true, Amount: 10, MaxBodySize: 1,
+	}}
+
+	e.RunEngine(context.Background())
+
+	// The partial document is stored and indexed
+	big := urls.get("https://example.com/big")
+	assert.True(t, big.Success)
+	assert.True(t, big.Truncated)
+	assert.Equal(t, "Big", big.PageTitle)
+
+	e.RunIndex(context.Background())
+
+	assert.Equal(t, []string{big.ID}, e.Index.(*fakeIndex).index["begin"])
+}
+
+func TestRunEngineRetries(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/busy", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7200")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	e, urls := newTestEngine(
+		NewHttpFetcher(time.Second),
+		server.URL+"/busy", server.URL+"/flaky", server.URL+"/gone",
+	)
+	// The url already failed as many times as allowed
+	urls.urls[1].RetryCount = maxRetries
+
+	start := time.Now()
+	e.RunEngine(context.Background())
+
+	// Transient failures are retried, after the delay asked by the server
+	busy := urls.get(server.URL + "/busy")
+	assert.False(t, busy.Success)
+	assert.Equal(t, 503, busy.ResponseCode)
+	assert.Equal(t, "503 Service Unavailable", busy.FailureReason)
+	assert.Equal(t, 1, busy.RetryCount)
+	assert.NotNil(t, busy.NextCrawlAt)
+	assert.GreaterOrEqual(t, busy.NextCrawlAt.Sub(start), 2*time.Hour)
+
+	// unless they failed too many times
+	flaky := urls.get(server.URL + "/flaky")
+	assert.Equal(t, "502 Bad Gateway", flaky.FailureReason)
+	assert.Equal(t, maxRetries, flaky.RetryCount)
+	assert.Nil(t, flaky.NextCrawlAt)
+
+	// Permanent failures are not retried
+	gone := urls.get(server.URL + "/gone")
+	assert.Equal(t, services.FailureHttpStatus, gone.FailureKind)
+	assert.Equal(t, "410 Gone", gone.FailureReason)
+	assert.Equal(t, 0, gone.RetryCount)
+	assert.Nil(t, gone.NextCrawlAt)
+}
+
+func TestRunEngineFailedRecrawl(t *testing.T) {
+	var down atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `<html><head>
+			<title>Gophers</title>
+			<meta name="robots" content="noindex">
+		</head><body><p>All about gophers</p></body></html>`)
+	}))
+	defer server.Close()
+
+	e, urls := newTestEngine(NewHttpFetcher(time.Second), server.URL+"/")
+	e.Settings = &fakeSettings{services.SearchSettings{SearchOn: true, Amount: 10}}
+	e.RunEngine(context.Background())
+	e.RunIndex(context.Background())
+
+	// The recrawl fails once the page is indexed
+	down.Store(true)
+	past := time.Now().Add(-time.Minute)
+	urls.urls[0].NextCrawlAt = &past
+	e.RunEngine(context.Background())
+
+	page := urls.get(server.URL + "/")
+	assert.False(t, page.Success)
+	assert.Equal(t, 503, page.ResponseCode)
+	assert.Equal(t, 1, page.RetryCount)
+
+	// but the document of the last successful crawl is kept
+	assert.Equal(t, "Gophers", page.PageTitle)
+	assert.Contains(t, page.Content, "All about gophers")
+	assert.NotEmpty(t, page.ContentHash)
+	assert.True(t, page.NoIndex)
+	assert.True(t, page.Indexed)
+}
+
+func TestRunEngineChangedRecrawl(t *testing.T) {
+	var changed atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		animal := "gophers"
+		if changed.Load() {
+			animal = "rabbits"
+		}
+		fmt.Fprintf(w, `<html><head><title>Pets</title></head><body><p>All about %s</p></body></html>`, animal)
+	}))
+	defer server.Close()
+
+	e, urls := newTestEngine(NewHttpFetcher(time.Second), server.URL+"/")
+	e.Settings = &fakeSettings{services.SearchSettings{SearchOn: true, Amount: 10}}
+	e.RunEngine(context.Background())
+	e.RunIndex(context.Background())
+
+	id := urls.get(server.URL + "/").ID
+	index := e.Index.(*fakeIndex)
+	assert.Equal(t, []string{id}, index.index["gopher"])
+
+	// The page changed when it is crawled again, as scheduled by its sitemap
+	changed.Store(true)
+	past := time.Now().Add(-time.Minute)
+	urls.urls[0].NextCrawlAt = &past
+	e.RunEngine(context.Background())
+	assert.False(t, urls.get(server.URL+"/").Indexed)
+
+	// so it is indexed again, only with its new terms
+	stats, err := e.RunIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Indexed)
+	assert.Equal(t, []string{id}, index.index["rabbit"])
+	assert.Equal(t, []string{id}, index.index["pet"])
+	assert.NotContains(t, index.index, "gopher")
+	assert.True(t, urls.get(server.URL+"/").Indexed)
+}
+
+func TestRunIndexSplitCluster(t *testing.T) {
+	var changed atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		animal := "gophers"
+		if r.URL.Path == "/original" && changed.Load() {
+			animal = "rabbits"
+		}
+		fmt.Fprintf(w, `<html><head><title>Pets</title></head><body><p>All about %s</p></body></html>`, animal)
+	}))
+	defer server.Close()
+
+	e, urls := newTestEngine(
+		NewHttpFetcher(time.Second), server.URL+"/original", server.URL+"/copy",
+	)
+	e.Settings = &fakeSettings{services.SearchSettings{SearchOn: true, Amount: 10}}
+	e.RunEngine(context.Background())
+	e.RunIndex(context.Background())
+
+	// The copy is only found through the original
+	original, copied := urls.get(server.URL+"/original"), urls.get(server.URL+"/copy")
+	index := e.Index.(*fakeIndex)
+	assert.Equal(t, original.ID, copied.DuplicateOf)
+	assert.True(t, copied.Indexed)
+	assert.Equal(t, []string{original.ID}, index.index["gopher"])
+
+	// until the original changes, which splits the cluster
+	changed.Store(true)
+	past := time.Now().Add(-time.Minute)
+	urls.urls[0].NextCrawlAt = &past
+	e.RunEngine(context.Background())
+	e.RunIndex(context.Background())
+
+	copied = urls.get(server.URL + "/copy")
+	assert.Empty(t, copied.DuplicateOf)
+	assert.False(t, copied.Indexed)
+
+	// so the former duplicate is indexed in the next run
+	stats, err := e.RunIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Indexed)
+	assert.True(t, urls.get(server.URL+"/copy").Indexed)
+	assert.Equal(t, []string{copied.ID}, index.index["gopher"])
+	assert.Equal(t, []string{original.ID}, index.index["rabbit"])
+}
+
+// racingUrls runs a crawl of the urls right after
+// they are read by an index run, which is still running.
+type racingUrls struct {
+	*fakeUrls
+	crawl func()
+}
+
+func (ru *racingUrls) GetNotIndexed(ctx context.Context) ([]services.CrawledUrl, error) {
+	urls, err := ru.fakeUrls.GetNotIndexed(ctx)
+	if ru.crawl != nil {
+		ru.crawl()
+		ru.crawl = nil
+	}
+
+	return urls, err
+}
+
+func TestRunIndexRecrawledMeanwhile(t *testing.T) {
+	var changed atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		animal := "gophers"
+		if changed.Load() {
+			animal = "rabbits"
+		}
+		fmt.Fprintf(w, `<html><head><title>Pets</title></head><body><p>All about %s</p></body></html>`, animal)
+	}))
+	defer server.Close()
+
+	e, urls := newTestEngine(NewHttpFetcher(time.Second), server.URL+"/")
+	e.RunEngine(context.Background())
+
+	// The page is crawled again while it is being indexed
+	e.Urls = &racingUrls{fakeUrls: urls, crawl: func() {
+		changed.Store(true)
+		err := e.Recrawl(context.Background(), []services.CrawledUrl{urls.get(server.URL + "/")})
+		assert.NoError(t, err)
+	}}
+	_, err := e.RunIndex(context.Background())
+	assert.NoError(t, err)
+
+	// so its new content is left to the next index run
+	id := urls.get(server.URL + "/").ID
+	index := e.Index.(*fakeIndex)
+	assert.Equal(t, []string{id}, index.index["gopher"])
+	assert.False(t, urls.get(server.URL+"/").Indexed)
+
+	_, err = e.RunIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{id}, index.index["rabbit"])
+	assert.NotContains(t, index.index, "gopher")
+	assert.True(t, urls.get(server.URL+"/").Indexed)
+}
+
+func TestRunFeeds(t *testing.T) {
+	e, urls := newTestEngine(fixtureFetcher{
+		"https://example.com/feed.xml": `<?xml version="1.0"?>
+			<rss version="2.0"><channel>
+				<title>Example News</title>
+				<item><link>/news/1</link></item>
+			</channel></rss>`,
+		"https://example.com/news/1": `<html><head><title>Breaking</title></head>
+			<body><p>Gophers everywhere</p></body></html>`,
+	})
+	e.Feeds = &fakeFeeds{feeds: []services.Feed{{Url: "https://example.com/feed.xml"}}}
+
+	stats, articles, err := e.RunFeeds(context.Background())
+	assert.NoError(t, err)
+
+	// The new article is crawled right away, to be indexed by the index job
+	article := urls.get("https://example.com/news/1")
+	assert.Equal(t, feedPriority, article.Priority)
+	assert.True(t, article.Success)
+	assert.Equal(t, "Breaking", article.PageTitle)
+	assert.False(t, article.Indexed)
+	assert.Equal(t, 1, articles)
+	assert.Equal(t, RunStats{Queued: 1, Fetched: 2, Added: 1}, stats)
+
+	// and only once
+	e.Feeds = &fakeFeeds{feeds: []services.Feed{{Url: "https://example.com/feed.xml"}}}
+	stats, articles, err = e.RunFeeds(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, articles)
+	assert.Equal(t, RunStats{Queued: 1, Fetched: 1}, stats)
+}
+
+func TestRunEngineNoArchive(t *testing.T) {
+	e, urls := newTestEngine(fixtureFetcher{
+		"https://example.com/": `<html><head>
+			<title>Gophers</title>
+			<meta name="robots" content="noarchive">
+		</head><body><p>Secret recipes</p></body></html>`,
+	}, "https://example.com/")
+
+	e.RunEngine(context.Background())
+	e.RunIndex(context.Background())
+
+	// No copy of the text is kept, but the page is still indexed
+	page := urls.get("https://example.com/")
+	assert.True(t, page.Success)
+	assert.True(t, page.NoArchive)
+	assert.Empty(t, page.Content)
+	assert.NotEmpty(t, page.ContentHash)
+	assert.Equal(t, []string{page.ID}, e.Index.(*fakeIndex).index["gopher"])
+	assert.NotContains(t, e.Index.(*fakeIndex).index, "secret")
+}
+
+func TestRunEngineRobotsTxt(t *testing.T) {
+	e, urls := newTestEngine(fixtureFetcher{
+		"https://example.com/robots.txt": "User-agent: *\nDisallow: /private/\n",
+		"https://example.com/":           `<html><body><p>Home</p></body></html>`,
+		"https://example.com/private/":   `<html><body><p>Private</p></body></html>`,
+	}, "https://example.com/", "https://example.com/private/")
+
+	e.RunEngine(context.Background())
+
+	assert.True(t, urls.get("https://example.com/").Success)
+
+	// The disallowed url is not fetched, and its failure is not retried
+	private := urls.get("https://example.com/private/")
+	assert.False(t, private.Success)
+	assert.NotNil(t, private.LastTested)
+	assert.Equal(t, 0, private.ResponseCode)
+	assert.Equal(t, services.FailureRobots, private.FailureKind)
+	assert.Equal(t, "blocked by robots.txt", private.FailureReason)
+	assert.Nil(t, private.NextCrawlAt)
+}
+
+func TestRunEngineRules(t *testing.T) {
+	e, urls := newTestEngine(fixtureFetcher{
+		"https://example.com/": `<html><body>
+			<a href="https://docs.example.com/">Docs</a>
+			<a href="https://blog.example.com/admin/">Admin</a>
+			<a href="https://spam.com/">Spam</a>
+			<a href="https://other.com/">Other</a>
+		</body></html>`,
+	}, "https://example.com/", "https://spam.com/seed")
+
+	rule := func(kind services.RuleKind, pattern string, allow bool) services.CrawlRule {
+		r, err := services.NewCrawlRule(kind, pattern, allow)
+		assert.NoError(t, err)
+		return r
+	}
+	e.Rules = &fakeRules{rules: []services.CrawlRule{
+		rule(services.RuleSubdomain, "*.example.com", true),
+		rule(services.RulePath, "^/admin/", false),
+		rule(services.RuleHost, "spam.com", false),
+	}}
+	e.Settings = &fakeSettings{services.SearchSettings{
+		SearchOn: true, AddNew: true, Amount: 10, ScopeOn: true,
+	}}
+
+	e.RunEngine(context.Background())
+
+	// The denied seed is never crawled
+	assert.True(t, urls.get("https://example.com/").Success)
+	assert.Nil(t, urls.get("https://spam.com/seed").LastTested)
+
+	// and only the links in scope are added
+	assert.NotEmpty(t, urls.get("https://docs.example.com/").ID)
+	assert.Empty(t, urls.get("https://blog.example.com/admin/").ID)
+	assert.Empty(t, urls.get("https://spam.com/").ID)
+	assert.Empty(t, urls.get("https://other.com/").ID)
+}
+
+func TestRunEngineRedirectOutOfScope(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		// The same server, through a host denied by the rules
+		target := "http://" + strings.Replace(r.Host, "127.0.0.1", "localhost", 1)
+		http.Redirect(w, r, target+"/new", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<html><body><p>New</p></body></html>`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	e, urls := newTestEngine(NewHttpFetcher(time.Second), srv.URL+"/old")
+	rule, err := services.NewCrawlRule(services.RuleHost, "localhost", false)
+	assert.NoError(t, err)
+	e.Rules = &fakeRules{rules: []services.CrawlRule{rule}}
+
+	e.RunEngine(context.Background())
+
+	// The url is recorded as a redirect, without the data of its target
+	target := strings.Replace(srv.URL, "127.0.0.1", "localhost", 1) + "/new"
+	old := urls.get(srv.URL + "/old")
+	assert.True(t, old.Success)
+	assert.Equal(t, 301, old.ResponseCode)
+	assert.Equal(t, target, old.RedirectTo)
+	assert.Empty(t, old.Content)
+	assert.Empty(t, old.PageTitle)
+
+	// and the target is not added
+	assert.Empty(t, urls.get(target).ID)
+}
+
+func TestRecrawl(t *testing.T) {
+	e, urls := newTestEngine(fixtureFetcher{
+		"https://example.com/": `<html><body>
+			<a href="/about">About</a>
+			<a href="https://other.com/">Other</a>
+		</body></html>`,
+	}, "https://example.com/")
+	// Even if the search is turned off
+	e.Settings = &fakeSettings{services.SearchSettings{Amount: 10}}
+
+	seed := urls.get("https://example.com/")
+	err := e.Recrawl(context.Background(), []services.CrawledUrl{seed})
+	assert.NoError(t, err)
+	_, err = e.RunIndex(context.Background())
+	assert.NoError(t, err)
+	assert.True(t, urls.get("https://example.com/").Indexed)
+	err = e.Recrawl(context.Background(), []services.CrawledUrl{seed})
+	assert.NoError(t, err)
+
+	// The crawled url is indexed again in the next index run
+	crawled := urls.get("https://example.com/")
+	assert.True(t, crawled.Success)
+	assert.False(t, crawled.Indexed)
+	assert.Equal(t, []string{
+		"https://example.com/about", "https://other.com/",
+	}, crawled.Outlinks)
+
+	// Each crawl is kept in the history of the url
+	assert.Len(t, urls.attempts, 2)
+	for _, attempt := range urls.attempts {
+		assert.Equal(t, seed.ID, attempt.CrawledUrlID)
+		assert.True(t, attempt.Success)
+		assert.Equal(t, 200, attempt.ResponseCode)
+	}
+
+	// The urls denied by the rules are not crawled
+	rule, _ := services.NewCrawlRule(services.RuleHost, "example.com", false)
+	e.Rules = &fakeRules{rules: []services.CrawlRule{rule}}
+	err = e.Recrawl(context.Background(), []services.CrawledUrl{seed})
+	assert.Error(t, err)
+	assert.Len(t, urls.attempts, 2)
+}
@@ -0,0 +1,111 @@
+package search
+
+import (
+	"sync"
+	"time"
+)
+
+// EventKind is what happened in a run of the engine.
+type EventKind string
+
+const (
+	EventRunStarted   EventKind = "run_started"   // Count: urls queued
+	EventUrlFetched   EventKind = "url_fetched"   // Url, Count: response code
+	EventUrlFailed    EventKind = "url_failed"    // Url, Count: response code, Message: failure reason
+	EventUrlsAdded    EventKind = "urls_added"    // Count: new urls, Message: their origin
+	EventIndexUpdated EventKind = "index_updated" // Count: urls indexed so far, Message: step
+	EventRunFinished  EventKind = "run_finished"  // Message: duration or error
+)
+
+// Names of the runs that publish events.
+const (
+	RunCrawl = "crawl"
+	RunIndex = "index"
+)
+
+// Event is the progress of a run of the engine.
+type Event struct {
+	Run     string
+	Kind    EventKind
+	Url     string
+	Count   int
+	Message string
+	Time    time.Time
+}
+
+// EventBus delivers the events of the engine to the subscribers in
+// the same process (e.g. the dashboard), without ever blocking the
+// engine: the events are dropped for the subscribers that are too slow.
+// A nil bus discards the events.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+	closed      bool
+}
+
+func NewEventBus() *EventBus {
+
+	return &EventBus{
+		subscribers: map[chan Event]struct{}{},
+	}
+}
+
+// Publish sends the event to all the subscribers.
+func (b *EventBus) Publish(ev Event) {
+	if b == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// Subscribe returns a channel that receives the events published from
+// now on, with room for `buffer` pending events, and the function that
+// cancels the subscription. The channel is closed when the subscription
+// is cancelled or the bus is closed.
+func (b *EventBus) Subscribe(buffer int) (<-chan Event, func()) {
+	ch := make(chan Event, buffer)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(ch)
+
+		return ch, func() {}
+	}
+	b.subscribers[ch] = struct{}{}
+
+	return ch, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		if _, ok := b.subscribers[ch]; ok {
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Close ends all the subscriptions, e.g. when the server is shut down.
+func (b *EventBus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for ch := range b.subscribers {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
@@ -0,0 +1,90 @@
+package search
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestEventBus(t *testing.T) {
+	bus := NewEventBus()
+
+	first, unsubscribe := bus.Subscribe(1)
+	second, _ := bus.Subscribe(10)
+
+	bus.Publish(Event{Run: RunCrawl, Kind: EventRunStarted, Count: 2})
+	// The first subscriber is too slow for this one
+	bus.Publish(Event{Run: RunCrawl, Kind: EventRunFinished})
+
+	ev := <-first
+	assert.Equal(t, EventRunStarted, ev.Kind)
+	assert.False(t, ev.Time.IsZero())
+	assert.Len(t, first, 0)
+	assert.Len(t, second, 2)
+
+	// The channel is closed when the subscription is cancelled
+	unsubscribe()
+	unsubscribe()
+	_, ok := <-first
+	assert.False(t, ok)
+
+	bus.Close()
+	<-second
+	<-second
+	_, ok = <-second
+	assert.False(t, ok)
+
+	closed, _ := bus.Subscribe(1)
+	_, ok = <-closed
+	assert.False(t, ok)
+
+	// A nil bus discards the events
+	var none *EventBus
+	none.Publish(Event{Run: RunIndex, Kind: EventRunStarted})
+}
+
+func TestRunEngineEvents(t *testing.T) {
+	e, _ := newTestEngine(fixtureFetcher{
+		"https://example.com/": `<html><body><a href="https://other.com/">Other</a></body></html>`,
+	}, "https://example.com/", "https://example.com/missing")
+	e.Events = NewEventBus()
+	events, unsubscribe := e.Events.Subscribe(100)
+	defer unsubscribe()
+
+	stats, err := e.RunEngine(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, RunStats{Queued: 2, Fetched: 1, Failed: 1, Added: 1}, stats)
+	stats, err = e.RunIndex(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stats.Indexed)
+
+	kinds := []string{}
+	for len(events) > 0 {
+		ev := <-events
+		kinds = append(kinds, ev.Run+" "+string(ev.Kind))
+		switch ev.Kind {
+		case EventUrlFetched:
+			assert.Equal(t, "https://example.com/", ev.Url)
+			assert.Equal(t, 200, ev.Count)
+		case EventUrlFailed:
+			assert.Equal(t, "https://example.com/missing", ev.Url)
+			assert.Equal(t, 404, ev.Count)
+		}
+	}
+
+	// The urls are crawled concurrently, so only the runs are in order
+	assert.Equal(t, "crawl run_started", kinds[0])
+	assert.Equal(t, "crawl run_finished", kinds[5])
+	assert.ElementsMatch(t, []string{
+		"crawl url_fetched",
+		"crawl url_failed",
+		"crawl urls_added",
+		"crawl urls_added",
+	}, kinds[1:5])
+	assert.Equal(t, []string{
+		"index run_started",
+		"index index_updated",
+		"index index_updated",
+		"index run_finished",
+	}, kinds[6:])
+}
@@ -0,0 +1,174 @@
+package search
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/services"
+)
+
+// CrawlFailure is the reason why a url could not be crawled.
+type CrawlFailure struct {
+	Kind       services.FailureKind
+	Reason     string        // e.g. "503 Service Unavailable"
+	Transient  bool          // The url may be crawled successfully later
+	RetryAfter time.Duration // Delay asked by the server, if any
+}
+
+func (cf *CrawlFailure) Error() string {
+	return cf.Reason
+}
+
+// errorFailure classifies an error of the request.
+func errorFailure(err error) *CrawlFailure {
+	return &CrawlFailure{
+		Kind:      errorKind(err),
+		Reason:    err.Error(),
+		Transient: isTransientError(err),
+	}
+}
+
+// statusFailure classifies an unsuccessful response,
+// with the delay of its `Retry-After` header.
+func statusFailure(resp *http.Response) *CrawlFailure {
+	return &CrawlFailure{
+		Kind:       services.FailureHttpStatus,
+		Reason:     resp.Status,
+		Transient:  isTransientStatus(resp.StatusCode),
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+// permanentFailure returns a failure that is not retried.
+func permanentFailure(
+	kind services.FailureKind, format string, a ...any,
+) *CrawlFailure {
+	return &CrawlFailure{Kind: kind, Reason: fmt.Sprintf(format, a...)}
+}
+
+// documentFailure classifies an error reading or parsing the document.
+// Documents that could not be parsed because they were cut at the
+// maximum body size are taken as too large.
+func documentFailure(err error, truncated bool) *CrawlFailure {
+	failure := errorFailure(err)
+	if failure.Transient {
+		// The body may not be read because of the network
+		return failure
+	}
+
+	kind := services.FailureParse
+	if truncated || errors.Is(err, bufio.ErrTooLong) {
+		kind = services.FailureTooLarge
+	}
+
+	return permanentFailure(kind, "invalid document: %s", err)
+}
+
+// errorKind returns the kind of failure of a request error.
+func errorKind(err error) services.FailureKind {
+	if errors.Is(err, ErrTooManyRedirects) || errors.Is(err, ErrRedirectLoop) {
+		return services.FailureRedirect
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return services.FailureDns
+	}
+
+	if isTlsError(err) {
+		return services.FailureTls
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr) && netErr.Timeout() {
+		return services.FailureTimeout
+	}
+
+	var opErr *net.OpError
+	if errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) ||
+		errors.As(err, &opErr) {
+		return services.FailureConnection
+	}
+
+	return services.FailureOther
+}
+
+// isTlsError reports whether the TLS handshake failed,
+// e.g. because of an invalid or untrusted certificate.
+func isTlsError(err error) bool {
+	var (
+		verificationErr *tls.CertificateVerificationError
+		recordErr       tls.RecordHeaderError
+		alertErr        tls.AlertError
+		authorityErr    x509.UnknownAuthorityError
+		hostnameErr     x509.HostnameError
+		invalidErr      x509.CertificateInvalidError
+	)
+
+	return errors.As(err, &verificationErr) ||
+		errors.As(err, &recordErr) ||
+		errors.As(err, &alertErr) ||
+		errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr)
+}
+
+// isTransientStatus reports whether the status code
+// is due to a temporary condition of the server.
+func isTransientStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusRequestTimeout,
+		http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// isTransientError reports whether the request failed because of
+// a timeout or a network error that may not happen again.
+func isTransientError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNABORTED) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) {
+		return true
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return netErr.Timeout()
+	}
+
+	return false
+}
+
+/* NETWORK AND TLS ERRORS:
+https://pkg.go.dev/net#DNSError
+https://pkg.go.dev/crypto/tls#CertificateVerificationError
+https://pkg.go.dev/crypto/x509#UnknownAuthorityError
+*/
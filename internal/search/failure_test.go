@@ -0,0 +1,81 @@
+package search
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"testing"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorKind(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected services.FailureKind
+	}{
+		{"dns", &url.Error{Op: "Get", Err: &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", IsNotFound: true}}}, services.FailureDns},
+		{"tls", &url.Error{Op: "Get", Err: &tls.CertificateVerificationError{Err: x509.UnknownAuthorityError{}}}, services.FailureTls},
+		{"timeout", &url.Error{Op: "Get", Err: context.DeadlineExceeded}, services.FailureTimeout},
+		{"refused", &url.Error{Op: "Get", Err: &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, services.FailureConnection},
+		{"eof", &url.Error{Op: "Get", Err: io.EOF}, services.FailureConnection},
+		{"redirect loop", fmt.Errorf("crawl: %w", ErrRedirectLoop), services.FailureRedirect},
+		{"too many redirects", ErrTooManyRedirects, services.FailureRedirect},
+		{"other", errors.New("unsupported protocol scheme"), services.FailureOther},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			assert.Equal(st, tc.expected, errorKind(tc.err))
+		})
+	}
+}
+
+func TestDocumentFailure(t *testing.T) {
+	failure := documentFailure(errors.New("XML syntax error"), false)
+	assert.Equal(t, services.FailureParse, failure.Kind)
+	assert.False(t, failure.Transient)
+
+	failure = documentFailure(io.ErrUnexpectedEOF, true)
+	assert.Equal(t, services.FailureConnection, failure.Kind)
+	assert.True(t, failure.Transient)
+
+	failure = documentFailure(errors.New("XML syntax error: unexpected EOF"), true)
+	assert.Equal(t, services.FailureTooLarge, failure.Kind)
+	assert.False(t, failure.Transient)
+}
+
+func TestIsTransientError(t *testing.T) {
+	testCases := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"deadline", &url.Error{Op: "Get", Err: context.DeadlineExceeded}, true},
+		{"reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{"dns timeout", &net.DNSError{Err: "timeout", IsTimeout: true}, true},
+		{"dns not found", &net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{"redirects", fmt.Errorf("crawl: %w", ErrTooManyRedirects), false},
+		{"other", errors.New("unsupported protocol scheme"), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			assert.Equal(st, tc.expected, isTransientError(tc.err))
+		})
+	}
+
+	assert.True(t, isTransientStatus(http.StatusTooManyRequests))
+	assert.True(t, isTransientStatus(http.StatusServiceUnavailable))
+	assert.False(t, isTransientStatus(http.StatusNotFound))
+	assert.False(t, isTransientStatus(http.StatusInternalServerError))
+}
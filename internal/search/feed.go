@@ -0,0 +1,398 @@
+package search
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// maxFeedSize is the maximum size of a feed document.
+	maxFeedSize = 10 << 20
+	// feedsPerRun is the number of feeds polled in each run.
+	feedsPerRun = 50
+	// feedRefresh is the minimum time between two polls of a feed.
+	feedRefresh = 5 * time.Minute
+	// feedPriority is the crawl priority of the feed articles,
+	// higher than any other url so that they are crawled first.
+	feedPriority = 1.0
+)
+
+// feedTypes are the MIME types of the feeds announced with
+// `<link rel="alternate">`.
+var feedTypes = map[string]struct{}{
+	"application/rss+xml":  {},
+	"application/atom+xml": {},
+}
+
+// feedXml matches the root of RSS 2.0 (`<rss>`),
+// RSS 1.0 (`<rdf:RDF>`) and Atom (`<feed>`) documents.
+type feedXml struct {
+	XMLName xml.Name
+	// RSS 2.0
+	Channel struct {
+		Title string        `xml:"title"`
+		Items []feedRssItem `xml:"item"`
+	} `xml:"channel"`
+	// RSS 1.0, whose items are siblings of the channel
+	Items []feedRssItem `xml:"item"`
+	// Atom
+	Title   string          `xml:"title"`
+	Entries []feedAtomEntry `xml:"entry"`
+}
+
+type feedRssItem struct {
+	Link    string `xml:"link"`
+	PubDate string `xml:"pubDate"`
+	Date    string `xml:"date"` // Dublin Core, used by RSS 1.0
+}
+
+type feedAtomEntry struct {
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Updated   string `xml:"updated"`
+	Published string `xml:"published"`
+}
+
+// FeedItem is each of the articles listed in a feed.
+type FeedItem struct {
+	Url       string
+	Published *time.Time
+}
+
+// feedDateLayouts are the date formats used by RSS (RFC 822) and Atom.
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	time.RFC3339Nano,
+	"2006-01-02",
+}
+
+// parseFeedDate returns the first date that can be parsed, or nil.
+func parseFeedDate(values ...string) *time.Time {
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		for _, layout := range feedDateLayouts {
+			if t, err := time.Parse(layout, v); err == nil {
+				return &t
+			}
+		}
+	}
+
+	return nil
+}
+
+// resolveFeedLink returns the absolute url of a link of the feed,
+// which may be relative to the url of the feed, or "" if it is invalid.
+func resolveFeedLink(baseUrl *url.URL, link string) string {
+	link = strings.TrimSpace(link)
+	if link == "" {
+		return ""
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	return baseUrl.ResolveReference(u).String()
+}
+
+// parseFeed decodes an RSS or Atom feed and returns its title and
+// items, whose links are resolved against the url of the feed.
+func parseFeed(body io.Reader, baseUrl *url.URL) (string, []FeedItem, error) {
+	f := feedXml{}
+	decoder := xml.NewDecoder(io.LimitReader(body, maxFeedSize))
+	decoder.CharsetReader = charset.NewReaderLabel
+	if err := decoder.Decode(&f); err != nil {
+		return "", nil, fmt.Errorf("invalid feed: %s", err)
+	}
+
+	items := []FeedItem{}
+	switch f.XMLName.Local {
+	case "rss", "RDF":
+		rssItems := append(f.Channel.Items, f.Items...)
+		for _, item := range rssItems {
+			if link := resolveFeedLink(baseUrl, item.Link); link != "" {
+				items = append(items, FeedItem{
+					Url:       link,
+					Published: parseFeedDate(item.PubDate, item.Date),
+				})
+			}
+		}
+
+		return strings.TrimSpace(f.Channel.Title), items, nil
+
+	case "feed":
+		for _, entry := range f.Entries {
+			for _, link := range entry.Links {
+				// The link of the entry is the one without `rel` or "alternate"
+				if link.Rel == "" || link.Rel == "alternate" {
+					if href := resolveFeedLink(baseUrl, link.Href); href != "" {
+						items = append(items, FeedItem{
+							Url:       href,
+							Published: parseFeedDate(entry.Updated, entry.Published),
+						})
+					}
+
+					break
+				}
+			}
+		}
+
+		return strings.TrimSpace(f.Title), items, nil
+	}
+
+	return "", nil, fmt.Errorf(
+		"invalid feed: unexpected root element <%s>", f.XMLName.Local,
+	)
+}
+
+// getFeedLinks looks for the feeds announced by the page with
+// `<link rel="alternate" type="application/rss+xml">` (or atom+xml)
+// and sends their normalized urls.
+func getFeedLinks(n *html.Node, baseUrl *url.URL, fch chan []string) {
+	feeds := []string{}
+	if n == nil {
+		fch <- feeds
+		return
+	}
+
+	var findFeeds func(*html.Node)
+	findFeeds = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "link" {
+			var rel, kind, href string
+			for _, attr := range n.Attr {
+				switch attr.Key {
+				case "rel":
+					rel = strings.ToLower(attr.Val)
+				case "type":
+					kind = strings.ToLower(strings.TrimSpace(attr.Val))
+				case "href":
+					href = strings.TrimSpace(attr.Val)
+				}
+			}
+
+			_, isFeed := feedTypes[kind]
+			if isFeed && href != "" &&
+				strings.Contains(" "+rel+" ", " alternate ") {
+				if u, err := url.Parse(href); err == nil {
+					normalized, err := services.NormalizeUrl(
+						baseUrl.ResolveReference(u).String(),
+					)
+					if err == nil {
+						feeds = append(feeds, normalized)
+					}
+				}
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			findFeeds(c)
+		}
+	}
+
+	// We call the previously defined function
+	findFeeds(n)
+
+	fch <- feeds
+}
+
+// pollFeed requests the feed, conditionally if it was polled before,
+// and returns its items. The feed is updated with the new validators.
+// No items are returned if the feed was not modified.
+func pollFeed(
+	ctx context.Context, f Fetcher, feed *services.Feed,
+) ([]FeedItem, error) {
+	headers := http.Header{}
+	if feed.ETag != "" {
+		headers.Set("If-None-Match", feed.ETag)
+	}
+	if feed.LastModified != "" {
+		headers.Set("If-Modified-Since", feed.LastModified)
+	}
+
+	resp, finalUrl, _, err := fetchFollowingRedirects(ctx, f, feed.Url, headers)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	baseUrl, err := url.Parse(finalUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid feed url: %s", err)
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		return []FeedItem{}, nil
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("non 200 code found: %d", resp.StatusCode)
+	}
+
+	title, items, err := parseFeed(resp.Body, baseUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	if title != "" {
+		feed.Title = title
+	}
+	feed.ETag = resp.Header.Get("ETag")
+	feed.LastModified = resp.Header.Get("Last-Modified")
+
+	return items, nil
+}
+
+// saveFeeds stores the feeds discovered in the crawled pages.
+func saveFeeds(ctx context.Context, fs FeedStore, feeds []string) {
+	for _, feedUrl := range feeds {
+		err := fs.Save(ctx, &services.Feed{Url: feedUrl, Host: hostOf(feedUrl)})
+		if err != nil {
+			fmt.Printf("something went wrong adding a feed: %s\n", err)
+		}
+	}
+}
+
+// RunFeeds polls the stored feeds and adds their new articles to
+// the database with the highest priority. The new (and the updated)
+// articles are crawled in the same run, up to the amount of urls per
+// run of the settings, and their number is returned so that they are
+// indexed right away (see `Jobs`) and found within minutes; the rest
+// are crawled in the next run of the engine. When the context is done,
+// the feeds that were not polled yet are left for the next run.
+func (e *Engine) RunFeeds(ctx context.Context) (RunStats, int, error) {
+	log.Println("🚀 Started feeds polling…")
+
+	defer log.Println("🏁 Feeds polling has finished")
+
+	// Get crawl settings from DB
+	settings, err := e.Settings.Get(ctx)
+	if err != nil {
+		return RunStats{}, 0, fmt.Errorf("settings not found: %s", err)
+	}
+
+	// Check if search and adding new urls are turned on
+	if !settings.SearchOn {
+		fmt.Println("search is turned off")
+
+		return RunStats{}, 0, ErrSearchOff
+	}
+	if !settings.AddNew {
+		fmt.Println("adding new urls is turned off")
+
+		return RunStats{}, 0, nil
+	}
+
+	scope, err := e.crawlScope(ctx, settings)
+	if err != nil {
+		return RunStats{}, 0, fmt.Errorf("crawl rules not found: %s", err)
+	}
+
+	feeds, err := e.Feeds.GetDue(ctx, time.Now().Add(-feedRefresh), feedsPerRun)
+	if err != nil {
+		return RunStats{}, 0, fmt.Errorf("feeds not found: %s", err)
+	}
+
+	// The feeds polled so far are stored even if the run is cancelled
+	persistCtx, cancel := context.WithTimeout(
+		context.WithoutCancel(ctx), persistTimeout,
+	)
+	defer cancel()
+	fetcher := withTimeout(e.Fetcher, requestTimeout(settings))
+
+	stats := RunStats{Queued: len(feeds)}
+	fresh := []services.CrawledUrl{} // Articles to crawl now
+	for _, feed := range feeds {
+		if ctx.Err() != nil {
+			log.Printf("feeds polling cancelled: %s\n", ctx.Err())
+
+			break
+		}
+
+		polled := time.Now()
+		feed.LastPolled = &polled
+		feed.LastError = ""
+
+		items, err := pollFeed(ctx, fetcher, &feed)
+		if err != nil && ctx.Err() != nil {
+			// The feed was not polled, but interrupted
+			break
+		}
+		if err != nil {
+			log.Printf("something went wrong polling %s: %s\n", feed.Url, err)
+			feed.LastError = err.Error()
+			stats.Failed++
+		} else {
+			stats.Fetched++
+		}
+
+		for _, item := range items {
+			if !scope.Allows(item.Url) {
+				continue
+			}
+
+			article, created, err := e.Urls.SaveWithHints(persistCtx, services.CrawledUrl{
+				Url:          item.Url,
+				Priority:     feedPriority,
+				LastModified: item.Published,
+			})
+			if err != nil {
+				continue
+			}
+			if created {
+				stats.Added++
+			}
+			// The updated articles are those scheduled to be crawled now
+			updated := article.NextCrawlAt != nil && !article.NextCrawlAt.After(time.Now())
+			if (created || updated) && !article.Paused {
+				fresh = append(fresh, article)
+			}
+		}
+		if len(items) > 0 {
+			feed.ItemCount = len(items)
+		}
+
+		if err := e.Feeds.UpdatePolled(persistCtx, feed); err != nil {
+			fmt.Printf("something went wrong updating a feed: %s\n", err)
+		}
+	}
+
+	fmt.Printf("Polled %d feeds, added %d new urls\n", len(feeds), stats.Added)
+
+	if len(fresh) == 0 || ctx.Err() != nil {
+		return stats, 0, nil
+	}
+
+	// The fetched articles are counted along with the polled feeds
+	fresh = fresh[:min(len(fresh), int(settings.Amount))]
+	crawlCtx, cancelCrawl := context.WithTimeout(ctx, crawlTimeout(settings))
+	defer cancelCrawl()
+	crawled := e.crawl(crawlCtx, settings, scope, fresh)
+	stats.Fetched += crawled.Fetched
+	stats.Failed += crawled.Failed
+	stats.Added += crawled.Added
+
+	return stats, len(fresh), nil
+}
+
+/* RSS AND ATOM FEEDS:
+https://www.rssboard.org/rss-specification
+https://web.resource.org/rss/1.0/spec
+https://datatracker.ietf.org/doc/html/rfc4287
+https://www.rssboard.org/rss-autodiscovery
+*/
@@ -0,0 +1,114 @@
+package search
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/html"
+)
+
+func TestParseFeed(t *testing.T) {
+	published := time.Date(2024, 7, 1, 9, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		name  string
+		feed  string
+		title string
+		items []FeedItem
+	}{
+		{
+			name: "rss 2.0",
+			feed: `<?xml version="1.0"?>
+				<rss version="2.0"><channel>
+					<title>Example News</title>
+					<item>
+						<link>https://example.com/news/1</link>
+						<pubDate>Mon, 01 Jul 2024 09:00:00 GMT</pubDate>
+					</item>
+					<item><link>/news/2</link></item>
+					<item><title>No link</title></item>
+				</channel></rss>`,
+			title: "Example News",
+			items: []FeedItem{
+				{Url: "https://example.com/news/1", Published: &published},
+				{Url: "https://example.com/news/2"},
+			},
+		},
+		{
+			name: "atom",
+			feed: `<?xml version="1.0" encoding="utf-8"?>
+				<feed xmlns="http://www.w3.org/2005/Atom">
+					<title>Example Blog</title>
+					<entry>
+						<link rel="edit" href="https://example.com/edit/1"/>
+						<link href="1"/>
+						<updated>2024-07-01T09:00:00Z</updated>
+					</entry>
+				</feed>`,
+			title: "Example Blog",
+			items: []FeedItem{
+				{Url: "https://example.com/blog/1", Published: &published},
+			},
+		},
+		{
+			name: "rss 1.0",
+			feed: `<?xml version="1.0"?>
+				<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+					xmlns="http://purl.org/rss/1.0/">
+					<channel><title>Example RDF</title></channel>
+					<item><link>https://example.com/rdf/1</link></item>
+				</rdf:RDF>`,
+			title: "Example RDF",
+			items: []FeedItem{{Url: "https://example.com/rdf/1"}},
+		},
+	}
+
+	// The relative links are resolved against the url of the feed
+	baseUrl, _ := url.Parse("https://example.com/blog/feed.xml")
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			title, items, err := parseFeed(strings.NewReader(tc.feed), baseUrl)
+			assert.NoError(st, err)
+			assert.Equal(st, tc.title, title)
+			assert.Equal(st, len(tc.items), len(items))
+			for i := range tc.items {
+				assert.Equal(st, tc.items[i].Url, items[i].Url)
+				if tc.items[i].Published == nil {
+					assert.Nil(st, items[i].Published)
+				} else {
+					assert.True(st, tc.items[i].Published.Equal(*items[i].Published))
+				}
+			}
+		})
+	}
+
+	_, _, err := parseFeed(strings.NewReader(`<html></html>`), baseUrl)
+	assert.Error(t, err)
+}
+
+func TestGetFeedLinks(t *testing.T) {
+	doc, _ := html.Parse(strings.NewReader(`
+		<html>
+			<head>
+				<link rel="alternate" type="application/rss+xml" href="/feed.xml">
+				<link rel="alternate" type="application/atom+xml" href="https://example.com/atom">
+				<link rel="alternate" hreflang="es" href="/es/">
+				<link rel="stylesheet" type="text/css" href="/main.css">
+			</head>
+		</html>
+	`))
+
+	baseUrl, _ := url.Parse("https://example.com/blog/")
+	feedsch := make(chan []string)
+
+	// Call the function `getFeedLinks`
+	go getFeedLinks(doc, baseUrl, feedsch)
+
+	assert.Equal(t, []string{
+		"https://example.com/feed.xml", "https://example.com/atom",
+	}, <-feedsch)
+}
@@ -0,0 +1,115 @@
+package search
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// defaultTimeout is the time limit of each request of the default fetcher.
+const defaultTimeout = 10 * time.Second
+
+// Fetcher performs the HTTP requests of the crawler. Redirects must
+// not be followed, but returned as is (see `fetchFollowingRedirects`),
+// so that the crawler can record each hop. The request must be
+// cancelled when the context is done.
+type Fetcher interface {
+	Fetch(ctx context.Context, url string, headers http.Header) (*http.Response, error)
+}
+
+// HttpFetcher is the default `Fetcher`, which sends
+// the requests to the real servers with a random user agent.
+type HttpFetcher struct {
+	Client *http.Client
+}
+
+// NewHttpFetcher returns a fetcher whose requests time out after
+// the given duration (or `defaultTimeout`, if it is zero).
+func NewHttpFetcher(timeout time.Duration) *HttpFetcher {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+
+	return &HttpFetcher{
+		Client: &http.Client{
+			Timeout: timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Fetch creates the request to the given url
+// and sets a random user agent and the given headers for that request.
+// Returns an http response reference or an error.
+func (hf *HttpFetcher) Fetch(
+	ctx context.Context, url string, headers http.Header,
+) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range headers {
+		for _, v := range values {
+			req.Header.Add(key, v)
+		}
+	}
+	req.Header.Set("User-Agent", randomUserAgent())
+	res, err := hf.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// timeoutFetcher limits the time of each request of a fetcher,
+// including the reading of the body of the response.
+type timeoutFetcher struct {
+	fetcher Fetcher
+	timeout time.Duration
+}
+
+// withTimeout returns a fetcher whose requests time out after the given
+// duration, as set in the settings. A zero timeout means no limit.
+func withTimeout(f Fetcher, timeout time.Duration) Fetcher {
+	if timeout <= 0 {
+		return f
+	}
+
+	return timeoutFetcher{fetcher: f, timeout: timeout}
+}
+
+func (tf timeoutFetcher) Fetch(
+	ctx context.Context, url string, headers http.Header,
+) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(ctx, tf.timeout)
+	resp, err := tf.fetcher.Fetch(ctx, url, headers)
+	if err != nil {
+		cancel()
+
+		return nil, err
+	}
+	// The context is released once the body is read and closed
+	resp.Body = cancelBody{ReadCloser: resp.Body, cancel: cancel}
+
+	return resp, nil
+}
+
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (cb cancelBody) Close() error {
+	err := cb.ReadCloser.Close()
+	cb.cancel()
+
+	return err
+}
+
+/* TESTING HTTP CLIENTS:
+https://pkg.go.dev/net/http/httptest#Server
+https://pkg.go.dev/net/http#Client (see `CheckRedirect` and `ErrUseLastResponse`)
+*/
@@ -0,0 +1,180 @@
+package search
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/fnv"
+	"math/bits"
+	"sort"
+	"strings"
+
+	"github.com/emarifer/search-engine/internal/services"
+)
+
+const (
+	// shingleSize is the number of consecutive words hashed together.
+	shingleSize = 3
+	// simHashThreshold is the maximum number of different bits
+	// between two SimHashes to consider the documents near-duplicates.
+	simHashThreshold = 3
+	// simHashBands is the number of blocks the SimHash is split into
+	// when looking for candidates. It must be greater than the threshold,
+	// so that two near-duplicates share at least one identical block.
+	simHashBands = 4
+)
+
+// Fingerprint identifies the content of a document.
+type Fingerprint struct {
+	ContentHash string // Exact hash of the normalized text
+	SimHash     uint64 // Locality sensitive hash of the text shingles
+}
+
+// newFingerprint computes the fingerprint of the given text.
+// Documents without text get an empty fingerprint.
+func newFingerprint(text string) Fingerprint {
+	words := lowercaseFilter(tokenize(text))
+	if len(words) == 0 {
+		return Fingerprint{}
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(words, " ")))
+
+	return Fingerprint{
+		ContentHash: hex.EncodeToString(sum[:]),
+		SimHash:     simHash(shingles(words)),
+	}
+}
+
+// shingles returns the groups of `shingleSize` consecutive words.
+// Texts shorter than that are returned as a single shingle.
+func shingles(words []string) []string {
+	if len(words) <= shingleSize {
+		return []string{strings.Join(words, " ")}
+	}
+
+	r := make([]string, 0, len(words)-shingleSize+1)
+	for i := 0; i+shingleSize <= len(words); i++ {
+		r = append(r, strings.Join(words[i:i+shingleSize], " "))
+	}
+
+	return r
+}
+
+// simHash computes the 64 bits SimHash of the given features:
+// each bit is set if the majority of the feature hashes have it set.
+func simHash(features []string) uint64 {
+	var weights [64]int
+	for _, feature := range features {
+		h := fnv.New64a()
+		h.Write([]byte(feature))
+		sum := h.Sum64()
+		for i := 0; i < 64; i++ {
+			if sum&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	var r uint64
+	for i, w := range weights {
+		if w > 0 {
+			r |= 1 << i
+		}
+	}
+
+	return r
+}
+
+// hammingDistance returns the number of different bits of a and b.
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// clusterDuplicates groups the documents with identical or nearly
+// identical content and returns, for each document that is not the
+// canonical member of its cluster, the ID of the canonical member.
+// The canonical member is the oldest document (the first one found).
+func clusterDuplicates(docs []services.CrawledUrl) map[string]string {
+	sorted := make([]services.CrawledUrl, 0, len(docs))
+	for _, doc := range docs {
+		if doc.ContentHash != "" {
+			sorted = append(sorted, doc)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if !sorted[i].CreatedAt.Equal(sorted[j].CreatedAt) {
+			return sorted[i].CreatedAt.Before(sorted[j].CreatedAt)
+		}
+
+		return sorted[i].Url < sorted[j].Url
+	})
+
+	// Union-find of the document positions. The root of each
+	// cluster is always its lowest position, i.e. the canonical member.
+	parent := make([]int, len(sorted))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	union := func(i, j int) {
+		ri, rj := find(i), find(j)
+		if ri < rj {
+			parent[rj] = ri
+		} else if rj < ri {
+			parent[ri] = rj
+		}
+	}
+
+	// Only the documents sharing the exact content hash or
+	// one of the SimHash bands are compared with each other.
+	byHash := map[string]int{}
+	bands := make([]map[uint64][]int, simHashBands)
+	for b := range bands {
+		bands[b] = map[uint64][]int{}
+	}
+	bandWidth := 64 / simHashBands
+	mask := uint64(1)<<bandWidth - 1
+
+	for i, doc := range sorted {
+		if first, ok := byHash[doc.ContentHash]; ok {
+			union(first, i)
+		} else {
+			byHash[doc.ContentHash] = i
+		}
+
+		sh := uint64(doc.SimHash)
+		for b := range bands {
+			key := (sh >> (b * bandWidth)) & mask
+			for _, j := range bands[b][key] {
+				if find(i) != find(j) &&
+					hammingDistance(sh, uint64(sorted[j].SimHash)) <= simHashThreshold {
+					union(j, i)
+				}
+			}
+			bands[b][key] = append(bands[b][key], i)
+		}
+	}
+
+	dups := map[string]string{}
+	for i, doc := range sorted {
+		if root := find(i); root != i {
+			dups[doc.ID] = sorted[root].ID
+		}
+	}
+
+	return dups
+}
+
+/* SIMHASH AND NEAR-DUPLICATE DETECTION:
+https://en.wikipedia.org/wiki/SimHash
+https://www.wwwconference.org/www2007/papers/paper215.pdf
+https://matpalm.com/resemblance/simhash/
+*/
@@ -0,0 +1,64 @@
+package search
+
+import (
+	"testing"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/stretchr/testify/assert"
+)
+
+const sampleText = `Go is an open source programming language that makes it simple
+to build secure, scalable systems. It was designed at Google by Robert
+Griesemer, Rob Pike and Ken Thompson, and it is syntactically similar to C,
+but also has memory safety, garbage collection, structural typing and
+CSP-style concurrency. It is often referred to as Golang because of its
+former domain name, but its proper name is Go.`
+
+func TestNewFingerprint(t *testing.T) {
+	a := newFingerprint(sampleText)
+	b := newFingerprint("  GO is an open source   programming language" +
+		sampleText[len("Go is an open source programming language"):])
+	c := newFingerprint(sampleText + " Read more.")
+	d := newFingerprint("A completely unrelated page about cooking pasta " +
+		"with tomatoes, garlic, olive oil and fresh basil from the garden.")
+
+	// Case and whitespace do not change the content hash
+	assert.Equal(t, a.ContentHash, b.ContentHash)
+	assert.NotEqual(t, a.ContentHash, c.ContentHash)
+
+	assert.LessOrEqual(t, hammingDistance(a.SimHash, c.SimHash), simHashThreshold)
+	assert.Greater(t, hammingDistance(a.SimHash, d.SimHash), simHashThreshold)
+
+	assert.Equal(t, Fingerprint{}, newFingerprint(" \n "))
+}
+
+func TestClusterDuplicates(t *testing.T) {
+	now := time.Now()
+	doc := func(id string, age time.Duration, text string) services.CrawledUrl {
+		fp := newFingerprint(text)
+		return services.CrawledUrl{
+			ID:          id,
+			Url:         "https://" + id + ".com/",
+			ContentHash: fp.ContentHash,
+			SimHash:     int64(fp.SimHash),
+			CreatedAt:   now.Add(-age),
+		}
+	}
+
+	docs := []services.CrawledUrl{
+		doc("mirror", time.Hour, sampleText),
+		doc("original", 2*time.Hour, sampleText),
+		doc("syndicated", 0, sampleText+" Read more."),
+		doc("other", 3*time.Hour, "A completely unrelated page about "+
+			"cooking pasta with tomatoes, garlic, olive oil and fresh basil."),
+		{ID: "empty", Url: "https://empty.com/"},
+	}
+
+	expected := map[string]string{
+		"mirror":     "original",
+		"syndicated": "original",
+	}
+
+	assert.Equal(t, expected, clusterDuplicates(docs))
+}
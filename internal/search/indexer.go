@@ -11,7 +11,8 @@ func (idx Index) Add(docs []services.CrawledUrl) {
 		docString := doc.Url + " " +
 			doc.PageTitle + " " +
 			doc.PageDescription + " " +
-			doc.Headings
+			doc.Headings + " " +
+			doc.Content
 		for _, token := range analyze(docString) {
 			ids := idx[token]
 			if ids != nil && ids[len(ids)-1] == doc.ID {
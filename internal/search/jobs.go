@@ -0,0 +1,212 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/services"
+)
+
+type JobStore interface {
+	Start(ctx context.Context, input *services.Job) error
+	Finish(ctx context.Context, input services.Job) error
+}
+
+// Names of the jobs of the engine.
+const (
+	JobCrawl = RunCrawl
+	JobIndex = RunIndex
+	JobFeeds = "feeds"
+)
+
+var (
+	ErrJobRunning = errors.New("the job is already running")
+	ErrJobUnknown = errors.New("unknown job")
+)
+
+// JobFunc is a run of a job.
+type JobFunc func(ctx context.Context) (RunStats, error)
+
+// FeedsFunc is a run of the feeds job, which
+// also returns the number of articles crawled.
+type FeedsFunc func(ctx context.Context) (RunStats, int, error)
+
+// Jobs runs the jobs of the engine, either on schedule or on demand,
+// never two runs of the same job at once, and stores their history.
+type Jobs struct {
+	ctx     context.Context // Cancels the runs when the server is shut down
+	store   JobStore
+	funcs   map[string]JobFunc
+	feeds   FeedsFunc
+	mu      sync.Mutex
+	running map[string]bool
+	wg      sync.WaitGroup // Runs started in the background
+}
+
+// NewJobs returns the registry of the jobs of the engine,
+// whose runs are cancelled when the given context is done.
+func NewJobs(ctx context.Context, e *Engine, js JobStore) *Jobs {
+	j := &Jobs{
+		ctx:   ctx,
+		store: js,
+		funcs: map[string]JobFunc{
+			JobCrawl: e.RunEngine,
+			JobIndex: e.RunIndex,
+		},
+		feeds:   e.RunFeeds,
+		running: map[string]bool{},
+	}
+	j.funcs[JobFeeds] = j.runFeeds
+
+	return j
+}
+
+// runFeeds polls the feeds and indexes the articles crawled by the run
+// right away, through the index job, since two index runs must never
+// overlap: if the index job is running, they are left to its next run.
+func (j *Jobs) runFeeds(ctx context.Context) (RunStats, error) {
+	stats, articles, err := j.feeds(ctx)
+	if err != nil || articles == 0 || ctx.Err() != nil {
+		return stats, err
+	}
+
+	run, err := j.claim(JobIndex)
+	if errors.Is(err, ErrJobRunning) {
+		log.Println("the index job is running, the feed articles are left to its next run")
+
+		return stats, nil
+	}
+	if err != nil {
+		return stats, err
+	}
+	defer j.release(JobIndex)
+
+	index := j.run(JobIndex, services.TriggerFeeds, run)
+	stats.Indexed = index.Indexed
+	if index.Status == services.JobFailed {
+		return stats, fmt.Errorf("feed articles not indexed: %s", index.Error)
+	}
+
+	return stats, nil
+}
+
+// claim marks the job as running, unless it is running already.
+func (j *Jobs) claim(name string) (JobFunc, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	run, ok := j.funcs[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrJobUnknown, name)
+	}
+	if j.running[name] {
+		return nil, ErrJobRunning
+	}
+	j.running[name] = true
+
+	return run, nil
+}
+
+func (j *Jobs) release(name string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	delete(j.running, name)
+}
+
+// IsRunning reports whether the job is running now.
+func (j *Jobs) IsRunning(name string) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	return j.running[name]
+}
+
+// Run runs the job and waits for it to finish.
+func (j *Jobs) Run(name, trigger string) (services.Job, error) {
+	run, err := j.claim(name)
+	if err != nil {
+		return services.Job{}, err
+	}
+	defer j.release(name)
+
+	return j.run(name, trigger, run), nil
+}
+
+// Start runs the job in the background, e.g. when asked by the admin.
+func (j *Jobs) Start(name, trigger string) error {
+	run, err := j.claim(name)
+	if err != nil {
+		return err
+	}
+
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		defer j.release(name)
+
+		j.run(name, trigger, run)
+	}()
+
+	return nil
+}
+
+// Wait waits for the runs started in the background to finish.
+func (j *Jobs) Wait() {
+	j.wg.Wait()
+}
+
+// persistCtx returns the context to store the runs, which
+// is not cancelled with them, so that the history is complete.
+func (j *Jobs) persistCtx() (context.Context, context.CancelFunc) {
+
+	return context.WithTimeout(context.WithoutCancel(j.ctx), persistTimeout)
+}
+
+// run runs the job and stores it in the history, even
+// if it is cancelled, and returns the result of the run.
+func (j *Jobs) run(name, trigger string, run JobFunc) services.Job {
+	job := services.Job{Name: name, Trigger: trigger, StartedAt: time.Now()}
+
+	ctx, cancel := j.persistCtx()
+	if err := j.store.Start(ctx, &job); err != nil {
+		log.Printf("the %s job could not be stored: %s\n", name, err)
+	}
+	cancel()
+
+	stats, err := run(j.ctx)
+
+	finished := time.Now()
+	job.FinishedAt = &finished
+	job.Duration = finished.Sub(job.StartedAt)
+	job.Queued = stats.Queued
+	job.Fetched = stats.Fetched
+	job.Failed = stats.Failed
+	job.Added = stats.Added
+	job.Indexed = stats.Indexed
+	job.Status = services.JobSucceeded
+	switch {
+	case errors.Is(err, ErrSearchOff):
+		job.Status = services.JobSkipped
+		job.Error = err.Error()
+	case err != nil:
+		log.Printf("the %s job has failed: %s\n", name, err)
+		job.Status = services.JobFailed
+		job.Error = err.Error()
+	}
+
+	// The run is not in the history if it could not be stored
+	if job.ID != 0 {
+		ctx, cancel := j.persistCtx()
+		defer cancel()
+		if err := j.store.Finish(ctx, job); err != nil {
+			log.Printf("the %s job could not be updated: %s\n", name, err)
+		}
+	}
+
+	return job
+}
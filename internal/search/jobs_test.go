@@ -0,0 +1,186 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeJobs struct {
+	mu       sync.Mutex
+	started  []services.Job
+	finished []services.Job
+}
+
+func (f *fakeJobs) Start(ctx context.Context, input *services.Job) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	input.ID = uint(len(f.started) + 1)
+	input.Status = services.JobRunning
+	f.started = append(f.started, *input)
+
+	return nil
+}
+
+func (f *fakeJobs) Finish(ctx context.Context, input services.Job) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.finished = append(f.finished, input)
+
+	return nil
+}
+
+func TestJobsRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		stats  RunStats
+		err    error
+		status services.JobStatus
+		errMsg string
+	}{
+		{
+			name:   "succeeded",
+			stats:  RunStats{Queued: 3, Fetched: 2, Failed: 1, Added: 5},
+			status: services.JobSucceeded,
+		},
+		{
+			name:   "failed",
+			stats:  RunStats{Queued: 3, Fetched: 1},
+			err:    errors.New("crawl interrupted"),
+			status: services.JobFailed,
+			errMsg: "crawl interrupted",
+		},
+		{
+			name:   "skipped",
+			err:    ErrSearchOff,
+			status: services.JobSkipped,
+			errMsg: ErrSearchOff.Error(),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &fakeJobs{}
+			jobs := NewJobs(context.Background(), &Engine{}, store)
+			jobs.funcs[JobCrawl] = func(ctx context.Context) (RunStats, error) {
+				return tt.stats, tt.err
+			}
+
+			job, err := jobs.Run(JobCrawl, services.TriggerCron)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.status, job.Status)
+			assert.Equal(t, tt.errMsg, job.Error)
+			assert.Equal(t, tt.stats.Fetched, job.Fetched)
+			assert.Equal(t, tt.stats.Added, job.Added)
+			assert.NotNil(t, job.FinishedAt)
+			assert.Equal(t, []services.Job{job}, store.finished)
+			assert.False(t, jobs.IsRunning(JobCrawl))
+		})
+	}
+}
+
+func TestJobsNoOverlap(t *testing.T) {
+	store := &fakeJobs{}
+	jobs := NewJobs(context.Background(), &Engine{}, store)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	jobs.funcs[JobCrawl] = func(ctx context.Context) (RunStats, error) {
+		close(started)
+		<-release
+
+		return RunStats{Queued: 1, Fetched: 1}, nil
+	}
+	jobs.funcs[JobIndex] = func(ctx context.Context) (RunStats, error) {
+		return RunStats{Indexed: 1}, nil
+	}
+
+	require.NoError(t, jobs.Start(JobCrawl, services.TriggerManual))
+	<-started
+	assert.True(t, jobs.IsRunning(JobCrawl))
+
+	// The same job cannot run twice at once, but other jobs can
+	_, err := jobs.Run(JobCrawl, services.TriggerCron)
+	assert.ErrorIs(t, err, ErrJobRunning)
+	assert.ErrorIs(t, jobs.Start(JobCrawl, services.TriggerManual), ErrJobRunning)
+	_, err = jobs.Run(JobIndex, services.TriggerCron)
+	assert.NoError(t, err)
+
+	assert.ErrorIs(t, jobs.Start("unknown", services.TriggerManual), ErrJobUnknown)
+
+	close(release)
+	jobs.Wait()
+
+	assert.False(t, jobs.IsRunning(JobCrawl))
+	require.Len(t, store.finished, 2)
+	assert.Equal(t, JobIndex, store.finished[0].Name)
+	assert.Equal(t, JobCrawl, store.finished[1].Name)
+	assert.Equal(t, services.TriggerManual, store.finished[1].Trigger)
+	assert.Equal(t, 1, store.finished[1].Fetched)
+}
+
+func TestJobsCancelled(t *testing.T) {
+	store := &fakeJobs{}
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs := NewJobs(ctx, &Engine{}, store)
+	jobs.funcs[JobCrawl] = func(ctx context.Context) (RunStats, error) {
+		cancel()
+		<-ctx.Done()
+
+		return RunStats{}, ctx.Err()
+	}
+
+	job, err := jobs.Run(JobCrawl, services.TriggerCron)
+	require.NoError(t, err)
+
+	// The run is stored even if the server is shutting down
+	assert.Equal(t, services.JobFailed, job.Status)
+	assert.Len(t, store.finished, 1)
+}
+
+func TestJobsFeedsIndex(t *testing.T) {
+	store := &fakeJobs{}
+	jobs := NewJobs(context.Background(), &Engine{}, store)
+	jobs.feeds = func(ctx context.Context) (RunStats, int, error) {
+		return RunStats{Queued: 1, Fetched: 3, Added: 2}, 2, nil
+	}
+	jobs.funcs[JobIndex] = func(ctx context.Context) (RunStats, error) {
+		return RunStats{Queued: 2, Indexed: 2}, nil
+	}
+
+	// The crawled articles are indexed by a run of the index job
+	job, err := jobs.Run(JobFeeds, services.TriggerCron)
+	require.NoError(t, err)
+	assert.Equal(t, services.JobSucceeded, job.Status)
+	assert.Equal(t, 2, job.Indexed)
+	require.Len(t, store.finished, 2)
+	assert.Equal(t, JobIndex, store.finished[0].Name)
+	assert.Equal(t, services.TriggerFeeds, store.finished[0].Trigger)
+
+	// which never overlaps another one: the articles are left to it
+	started, release := make(chan struct{}), make(chan struct{})
+	jobs.funcs[JobIndex] = func(ctx context.Context) (RunStats, error) {
+		close(started)
+		<-release
+
+		return RunStats{}, nil
+	}
+	require.NoError(t, jobs.Start(JobIndex, services.TriggerManual))
+	<-started
+
+	job, err = jobs.Run(JobFeeds, services.TriggerCron)
+	require.NoError(t, err)
+	assert.Equal(t, services.JobSucceeded, job.Status)
+	assert.Equal(t, 0, job.Indexed)
+
+	close(release)
+	jobs.Wait()
+	assert.Len(t, store.finished, 4)
+}
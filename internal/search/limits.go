@@ -0,0 +1,113 @@
+package search
+
+import (
+	"io"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"golang.org/x/net/html"
+)
+
+// Limits bound the resources used to parse each crawled document,
+// so that huge or endless responses cannot exhaust the memory.
+// A zero value means no limit.
+type Limits struct {
+	MaxBodySize int64 // Bytes of the body that are read
+	MaxDomDepth int   // Depth of the HTML tree that is kept
+	MaxLinks    int   // Links of the page that are kept
+}
+
+// limitsOf returns the limits set in the settings.
+func limitsOf(settings services.SearchSettings) Limits {
+	return Limits{
+		MaxBodySize: int64(settings.MaxBodySize) << 20,
+		MaxDomDepth: int(settings.MaxDomDepth),
+		MaxLinks:    int(settings.MaxLinks),
+	}
+}
+
+// truncatingReader reads at most `n` bytes of the underlying
+// reader and records whether there was more data to read.
+type truncatingReader struct {
+	r         io.Reader
+	n         int64
+	truncated bool
+}
+
+func newTruncatingReader(r io.Reader, n int64) *truncatingReader {
+	return &truncatingReader{r: r, n: n}
+}
+
+func (tr *truncatingReader) Read(p []byte) (int, error) {
+	if tr.n <= 0 {
+		// Check whether the body is longer than the limit
+		if !tr.truncated {
+			var b [1]byte
+			for {
+				n, err := tr.r.Read(b[:])
+				if n > 0 {
+					tr.truncated = true
+				}
+				if n > 0 || err != nil {
+					break
+				}
+			}
+		}
+
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > tr.n {
+		p = p[:tr.n]
+	}
+	n, err := tr.r.Read(p)
+	tr.n -= int64(n)
+
+	return n, err
+}
+
+// pruneDepth removes the nodes deeper than the given depth (the
+// document being at depth 0) and reports whether any was removed.
+func pruneDepth(doc *html.Node, maxDepth int) bool {
+	if maxDepth <= 0 {
+		return false
+	}
+
+	pruned := false
+	var prune func(*html.Node, int)
+	prune = func(n *html.Node, depth int) {
+		if depth >= maxDepth {
+			if n.FirstChild != nil {
+				n.FirstChild, n.LastChild = nil, nil
+				pruned = true
+			}
+
+			return
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			prune(c, depth+1)
+		}
+	}
+
+	// We call the previously defined function
+	prune(doc, 0)
+
+	return pruned
+}
+
+// limit keeps the first `max` links of the page, internal ones first,
+// and reports whether any was dropped.
+func (l Links) limit(max int) (Links, bool) {
+	if max <= 0 || len(l.Internal)+len(l.External) <= max {
+		return l, false
+	}
+
+	if len(l.Internal) >= max {
+		return Links{Internal: l.Internal[:max], External: []string{}}, true
+	}
+
+	return Links{
+		Internal: l.Internal,
+		External: l.External[:max-len(l.Internal)],
+	}, true
+}
@@ -0,0 +1,65 @@
+package search
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncatingReader(t *testing.T) {
+	tr := newTruncatingReader(strings.NewReader("0123456789"), 4)
+	data, err := io.ReadAll(tr)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123", string(data))
+	assert.True(t, tr.truncated)
+
+	tr = newTruncatingReader(strings.NewReader("0123"), 4)
+	data, err = io.ReadAll(tr)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123", string(data))
+	assert.False(t, tr.truncated)
+}
+
+func TestParseBodyLimits(t *testing.T) {
+	body := "<html><head><title>Deep</title></head><body>" +
+		"<p>Visible</p>" + strings.Repeat("<div>", 100) + "Hidden" +
+		strings.Repeat("</div>", 100) + "</body></html>"
+	baseUrl, _ := url.Parse("https://example.com")
+
+	// Document (0) > html (1) > body (2) > p (3) > text (4)
+	result, err := parseBody(strings.NewReader(body), baseUrl, Limits{MaxDomDepth: 4})
+	assert.NoError(t, err)
+	assert.True(t, result.Truncated)
+	assert.Equal(t, "Deep", result.PageTitle)
+	assert.Contains(t, result.Content, "Visible")
+	assert.NotContains(t, result.Content, "Hidden")
+
+	result, err = parseBody(strings.NewReader(body), baseUrl, Limits{MaxDomDepth: 200})
+	assert.NoError(t, err)
+	assert.False(t, result.Truncated)
+	assert.Contains(t, result.Content, "Hidden")
+}
+
+func TestLinksLimit(t *testing.T) {
+	links := Links{
+		Internal: []string{"https://a.com/1", "https://a.com/2"},
+		External: []string{"https://b.com/", "https://c.com/"},
+	}
+
+	limited, dropped := links.limit(0)
+	assert.Equal(t, links, limited)
+	assert.False(t, dropped)
+
+	limited, dropped = links.limit(3)
+	assert.Equal(t, []string{"https://a.com/1", "https://a.com/2"}, limited.Internal)
+	assert.Equal(t, []string{"https://b.com/"}, limited.External)
+	assert.True(t, dropped)
+
+	limited, dropped = links.limit(1)
+	assert.Equal(t, []string{"https://a.com/1"}, limited.Internal)
+	assert.Empty(t, limited.External)
+	assert.True(t, dropped)
+}
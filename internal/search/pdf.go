@@ -0,0 +1,319 @@
+package search
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf16"
+)
+
+var (
+	pdfStreamRe = regexp.MustCompile(`>>\s*stream\r?\n`)
+	pdfFlateRe  = regexp.MustCompile(`/Filter\s*(?:/FlateDecode|\[\s*/FlateDecode\s*\])`)
+	pdfTitleRe  = regexp.MustCompile(`/Title\s*(\((?:\\.|[^\\)])*\)|<[0-9A-Fa-f\s]*>)`)
+)
+
+// parsePdf extracts the text of the content streams of a PDF document.
+// It supports uncompressed and `FlateDecode` streams and the text shown
+// with the `Tj`, `TJ`, `'` and `"` operators. Text drawn with fonts that
+// need a `ToUnicode` map to be read (e.g. subset CID fonts) is skipped.
+func parsePdf(
+	body io.Reader, contentType string, baseUrl *url.URL, limits Limits,
+) (ParsedBody, error) {
+	start := time.Now()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return ParsedBody{}, err
+	}
+
+	// The streams share a single budget, so that many small streams
+	// that are highly compressed cannot expand without limit
+	budget := int64(maxDocumentSize)
+	truncated := false
+	var text strings.Builder
+	for _, loc := range pdfStreamRe.FindAllIndex(data, -1) {
+		if budget <= 0 || text.Len() >= maxDocumentSize {
+			truncated = true
+
+			break
+		}
+
+		// The dictionary of the stream goes from its "obj" keyword
+		dictStart := bytes.LastIndex(data[:loc[0]], []byte("obj"))
+		if dictStart < 0 {
+			continue
+		}
+		dict := string(data[dictStart:loc[0]])
+		streamStart := loc[1]
+		streamEnd := bytes.Index(data[streamStart:], []byte("endstream"))
+		if streamEnd < 0 {
+			break
+		}
+		stream := data[streamStart : streamStart+streamEnd]
+
+		// Only the content streams (without images, fonts, etc.) have text
+		if strings.Contains(dict, "/Subtype") || strings.Contains(dict, "/Type") {
+			continue
+		}
+
+		if strings.Contains(dict, "/Filter") {
+			// Other filters (or chains of filters) are not supported
+			if !pdfFlateRe.MatchString(dict) {
+				continue
+			}
+
+			r, err := zlib.NewReader(bytes.NewReader(stream))
+			if err != nil {
+				continue
+			}
+			stream, err = io.ReadAll(io.LimitReader(r, budget))
+			r.Close()
+			budget -= int64(len(stream))
+			if err != nil && len(stream) == 0 {
+				continue
+			}
+		}
+
+		extractPdfText(stream, &text)
+	}
+
+	content := text.String()
+	if len(content) > maxDocumentSize {
+		content = strings.ToValidUTF8(content[:maxDocumentSize], "")
+		truncated = true
+	}
+	content = strings.TrimSpace(content)
+
+	title := ""
+	if m := pdfTitleRe.FindSubmatch(data); m != nil {
+		title = strings.TrimSpace(decodePdfString(m[1]))
+	}
+	if title == "" {
+		// Use the file name, e.g. "annual-report.pdf"
+		title = path.Base(baseUrl.Path)
+	}
+
+	return ParsedBody{
+		CrawlTime:       time.Since(start),
+		PageTitle:       truncateText(title, descriptionLength),
+		PageDescription: excerpt(content, descriptionLength),
+		Content:         content,
+		Fingerprint:     newFingerprint(content),
+		Truncated:       truncated,
+	}, nil
+}
+
+// pdfWordSpacing is the (negative) adjustment of a `TJ` array, in
+// thousandths of em, from which it is taken as a space between words.
+const pdfWordSpacing = -200
+
+// extractPdfText writes the text shown by the operators of a content stream.
+func extractPdfText(stream []byte, text *strings.Builder) {
+	pending := []string{}
+	inArray := false
+	flush := func(sep string) {
+		for _, s := range pending {
+			text.WriteString(s)
+		}
+		pending = pending[:0]
+		text.WriteString(sep)
+	}
+
+	for i := 0; i < len(stream); i++ {
+		switch c := stream[i]; {
+		case c == '(':
+			end := pdfStringEnd(stream, i)
+			pending = append(pending, decodePdfString(stream[i:end]))
+			i = end - 1
+		case c == '<' && i+1 < len(stream) && stream[i+1] != '<':
+			end := bytes.IndexByte(stream[i:], '>')
+			if end < 0 {
+				return
+			}
+			pending = append(pending, decodePdfString(stream[i:i+end+1]))
+			i += end
+		case c == '[':
+			inArray = true
+		case c == ']':
+			inArray = false
+		case inArray && (c == '-' || c == '.' || c >= '0' && c <= '9'):
+			j := i + 1
+			for j < len(stream) && (stream[j] == '.' || stream[j] >= '0' && stream[j] <= '9') {
+				j++
+			}
+			n, err := strconv.ParseFloat(string(stream[i:j]), 64)
+			if err == nil && n < pdfWordSpacing {
+				pending = append(pending, " ")
+			}
+			i = j - 1
+		case c == '%':
+			// Comment until the end of the line
+			for i < len(stream) && stream[i] != '\n' && stream[i] != '\r' {
+				i++
+			}
+		case isPdfOperatorChar(c):
+			j := i
+			for j < len(stream) && isPdfOperatorChar(stream[j]) {
+				j++
+			}
+			switch string(stream[i:j]) {
+			case "Tj", "TJ":
+				flush("")
+			case "'", "\"", "T*", "Td", "TD":
+				// Move to the next line
+				text.WriteString(" ")
+				flush("")
+			case "ET":
+				flush("\n")
+			case "BT":
+				pending = pending[:0]
+			}
+			i = j - 1
+		}
+	}
+}
+
+// isPdfOperatorChar reports whether c can be part of an operator.
+func isPdfOperatorChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '*' ||
+		c == '\'' || c == '"'
+}
+
+// pdfStringEnd returns the position after the closing parenthesis
+// of the literal string that starts at `start`, which can contain
+// balanced parentheses and escaped characters.
+func pdfStringEnd(data []byte, start int) int {
+	depth := 0
+	for i := start; i < len(data); i++ {
+		switch data[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return len(data)
+}
+
+// decodePdfString decodes a literal `(...)` or hexadecimal `<...>`
+// string, encoded in UTF-16BE (with BOM) or PDFDocEncoding (which is
+// treated as Latin-1). Strings that are not readable text are dropped.
+func decodePdfString(s []byte) string {
+	var raw []byte
+	if len(s) >= 2 && s[0] == '<' {
+		hex := bytes.Map(func(r rune) rune {
+			if unicode.IsSpace(r) || r == '<' || r == '>' {
+				return -1
+			}
+			return r
+		}, s)
+		if len(hex)%2 == 1 {
+			hex = append(hex, '0')
+		}
+		raw = make([]byte, len(hex)/2)
+		for i := range raw {
+			raw[i] = unhex(hex[2*i])<<4 | unhex(hex[2*i+1])
+		}
+	} else if len(s) >= 2 {
+		raw = unescapePdfString(s[1 : len(s)-1])
+	}
+
+	var r []rune
+	if len(raw) >= 2 && raw[0] == 0xfe && raw[1] == 0xff {
+		units := make([]uint16, 0, len(raw)/2)
+		for i := 2; i+1 < len(raw); i += 2 {
+			units = append(units, uint16(raw[i])<<8|uint16(raw[i+1]))
+		}
+		r = utf16.Decode(units)
+	} else {
+		r = make([]rune, len(raw))
+		for i, b := range raw {
+			r[i] = rune(b)
+		}
+	}
+
+	for _, c := range r {
+		if !unicode.IsPrint(c) && !unicode.IsSpace(c) {
+			return ""
+		}
+	}
+
+	return string(r)
+}
+
+// unescapePdfString resolves the escape sequences of a literal string.
+func unescapePdfString(s []byte) []byte {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			out = append(out, s[i])
+			continue
+		}
+
+		i++
+		switch c := s[i]; c {
+		case 'n':
+			out = append(out, '\n')
+		case 'r':
+			out = append(out, '\r')
+		case 't':
+			out = append(out, '\t')
+		case 'b':
+			out = append(out, '\b')
+		case 'f':
+			out = append(out, '\f')
+		case '\r', '\n':
+			// Line continuation
+			if c == '\r' && i+1 < len(s) && s[i+1] == '\n' {
+				i++
+			}
+		default:
+			if c >= '0' && c <= '7' {
+				// Octal code of up to 3 digits
+				v := 0
+				j := i
+				for ; j < len(s) && j < i+3 && s[j] >= '0' && s[j] <= '7'; j++ {
+					v = v*8 + int(s[j]-'0')
+				}
+				out = append(out, byte(v))
+				i = j - 1
+			} else {
+				out = append(out, c)
+			}
+		}
+	}
+
+	return out
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10
+	}
+
+	return 0
+}
+
+/* PDF FORMAT:
+https://opensource.adobe.com/dc-acrobat-sdk-docs/pdfstandards/PDF32000_2008.pdf
+(7.3.4 String Objects, 7.3.8 Stream Objects, 9.4 Text Objects)
+*/
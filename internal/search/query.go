@@ -0,0 +1,204 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// QueryTerms returns the distinct terms of a search query,
+// analyzed in the same way as the indexed documents.
+func QueryTerms(query string) []string {
+	terms := []string{}
+	seen := map[string]struct{}{}
+	for _, term := range analyze(query) {
+		if _, ok := seen[term]; ok {
+			continue
+		}
+		seen[term] = struct{}{}
+		terms = append(terms, term)
+	}
+
+	return terms
+}
+
+// SnippetPart is a piece of a snippet, which
+// is highlighted if it matches the query.
+type SnippetPart struct {
+	Text  string
+	Match bool
+}
+
+// maxSnippetSource is the length of the text in
+// which the terms of the query are looked for.
+const maxSnippetSource = 100_000
+
+// word is the position of a word in a text.
+type word struct {
+	start, end int
+}
+
+// words returns the positions of the words of the
+// text, split as the documents are when indexed.
+func words(text string) []word {
+	ws := []word{}
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsNumber(r)
+		switch {
+		case isWord && start < 0:
+			start = i
+		case !isWord && start >= 0:
+			ws = append(ws, word{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		ws = append(ws, word{start, len(text)})
+	}
+
+	return ws
+}
+
+// matchesTerms reports whether the word is found by any of the terms,
+// which match the words whose stem starts with them, as in the index.
+func matchesTerms(w string, terms []string) bool {
+	for _, token := range analyze(w) {
+		for _, term := range terms {
+			if strings.HasPrefix(token, term) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Snippet returns an excerpt of about `maxLen` bytes of the text around
+// the first word that matches the terms, or its beginning if none does,
+// with the matching words highlighted.
+func Snippet(text string, terms []string, maxLen int) []SnippetPart {
+	text = strings.Join(strings.Fields(text), " ")
+	if len(text) > maxSnippetSource {
+		text = strings.ToValidUTF8(text[:maxSnippetSource], "")
+	}
+	if text == "" || maxLen <= 0 {
+		return nil
+	}
+
+	ws := words(text)
+	first := -1
+	for i, w := range ws {
+		if matchesTerms(text[w.start:w.end], terms) {
+			first = i
+			break
+		}
+	}
+
+	// The excerpt starts a few words before the first match
+	start, end := 0, len(text)
+	if first > 0 {
+		from := ws[first].start - maxLen/4
+		start = ws[first].start
+		for i := first - 1; i >= 0 && ws[i].start >= from; i-- {
+			start = ws[i].start
+		}
+		if from <= 0 {
+			start = 0
+		}
+	}
+	if end-start > maxLen {
+		end = start + maxLen
+		for end > start && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		// It does not end in the middle of a word
+		for _, w := range ws {
+			if w.start < end && end < w.end && w.start > start {
+				end = w.start
+				break
+			}
+		}
+	}
+
+	parts := []SnippetPart{}
+	if start > 0 {
+		parts = append(parts, SnippetPart{Text: "… "})
+	}
+	pos := start
+	for _, w := range ws {
+		if w.start < start || w.end > end {
+			continue
+		}
+		if !matchesTerms(text[w.start:w.end], terms) {
+			continue
+		}
+		if w.start > pos {
+			parts = append(parts, SnippetPart{Text: text[pos:w.start]})
+		}
+		parts = append(parts, SnippetPart{Text: text[w.start:w.end], Match: true})
+		pos = w.end
+	}
+	if end > pos {
+		parts = append(parts, SnippetPart{Text: strings.TrimRight(text[pos:end], " ")})
+	}
+	if end < len(text) {
+		parts = append(parts, SnippetPart{Text: " …"})
+	}
+
+	return parts
+}
+
+// maxSuggestionDistance is the number of edits of the
+// suggestions for the terms that are not in the index.
+const maxSuggestionDistance = 2
+
+// ClosestTerm returns the candidate that is the most similar to the term,
+// if it is similar enough, to suggest it when nothing is found.
+func ClosestTerm(term string, candidates []string) (string, bool) {
+	best, bestDistance := "", maxSuggestionDistance+1
+	// The short terms only admit one edit
+	if utf8.RuneCountInString(term) <= 4 {
+		bestDistance = 2
+	}
+
+	for _, c := range candidates {
+		if c == term {
+			continue
+		}
+		if d := levenshtein(term, c); d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+
+	return best, best != ""
+}
+
+// levenshtein returns the number of edits (insertions,
+// deletions or substitutions) between two strings.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+/* EDIT DISTANCE:
+https://en.wikipedia.org/wiki/Levenshtein_distance#Iterative_with_two_matrix_rows
+*/
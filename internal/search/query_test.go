@@ -0,0 +1,104 @@
+package search
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueryTerms(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{}},
+		{"The Running of the Bulls", []string{"run", "bull"}},
+		{"go, Go & GO!", []string{"go"}},
+		{"web-crawlers in golang", []string{"web", "crawler", "golang"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.query, func(st *testing.T) {
+			assert.Equal(st, tc.expected, QueryTerms(tc.query))
+		})
+	}
+}
+
+// snippetText joins the parts of a snippet, with the matches in brackets.
+func snippetText(parts []SnippetPart) string {
+	var sb strings.Builder
+	for _, p := range parts {
+		if p.Match {
+			sb.WriteString("[" + p.Text + "]")
+		} else {
+			sb.WriteString(p.Text)
+		}
+	}
+
+	return sb.String()
+}
+
+func TestSnippet(t *testing.T) {
+	long := strings.Repeat("lorem ipsum ", 20) + "the crawlers run   every\nhour " +
+		strings.Repeat("dolor sit amet ", 20)
+
+	testCases := []struct {
+		name     string
+		text     string
+		query    string
+		maxLen   int
+		expected string
+	}{
+		{"empty", "", "go", 50, ""},
+		{"no match", "Lorem ipsum dolor sit amet", "go", 12, "Lorem ipsum …"},
+		{"short", "Go is fun. Going further", "go", 100, "[Go] is fun. [Going] further"},
+		{
+			"around the match",
+			long,
+			"running crawler",
+			40,
+			"… ipsum the [crawlers] [run] every hour dolor …",
+		},
+		{"no cut words", "Crawling the web with Go", "crawl", 18, "[Crawling] the web …"},
+		{"multibyte", "Café crème brûlée", "crème", 100, "Café [crème] brûlée"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			parts := Snippet(tc.text, QueryTerms(tc.query), tc.maxLen)
+			assert.Equal(st, tc.expected, snippetText(parts))
+		})
+	}
+}
+
+func TestClosestTerm(t *testing.T) {
+	candidates := []string{"golang", "google", "gopher", "go"}
+
+	testCases := []struct {
+		term     string
+		expected string
+		found    bool
+	}{
+		{"golnag", "golang", true},
+		{"gophr", "gopher", true},
+		{"goo", "go", true},
+		{"golang", "", false}, // Already in the index
+		{"python", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.term, func(st *testing.T) {
+			term, found := ClosestTerm(tc.term, candidates)
+			assert.Equal(st, tc.expected, term)
+			assert.Equal(st, tc.found, found)
+		})
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	assert.Equal(t, 0, levenshtein("", ""))
+	assert.Equal(t, 3, levenshtein("", "abc"))
+	assert.Equal(t, 3, levenshtein("kitten", "sitting"))
+	assert.Equal(t, 1, levenshtein("café", "cafe"))
+}
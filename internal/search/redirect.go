@@ -0,0 +1,123 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/emarifer/search-engine/internal/services"
+)
+
+// maxRedirects is the maximum number of redirects followed for a url.
+const maxRedirects = 10
+
+var (
+	ErrTooManyRedirects = errors.New("too many redirects")
+	ErrRedirectLoop     = errors.New("redirect loop detected")
+)
+
+// RedirectHop is each of the redirect responses received
+// before reaching the final url.
+type RedirectHop struct {
+	Url        string
+	StatusCode int
+	Location   string
+}
+
+// isRedirect reports whether the status code
+// is a redirect that carries a `Location` header.
+func isRedirect(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMovedPermanently,
+		http.StatusFound,
+		http.StatusSeeOther,
+		http.StatusTemporaryRedirect,
+		http.StatusPermanentRedirect:
+		return true
+	}
+
+	return false
+}
+
+// fetchFollowingRedirects requests the given url with the fetcher (and
+// the given extra headers, if any) and follows the redirects one by one, recording each hop.
+// It returns the response of the final url (which may be different from
+// the given one) and the hops that led to it.
+// On error, the hops followed so far are also returned.
+func fetchFollowingRedirects(
+	ctx context.Context, f Fetcher, inputUrl string, headers http.Header,
+) (*http.Response, string, []RedirectHop, error) {
+	currentUrl := inputUrl
+	if normalized, err := services.NormalizeUrl(inputUrl); err == nil {
+		currentUrl = normalized
+	}
+
+	hops := []RedirectHop{}
+	visited := map[string]struct{}{currentUrl: {}}
+
+	for {
+		resp, err := f.Fetch(ctx, currentUrl, headers)
+		if err != nil {
+			return nil, currentUrl, hops, err
+		}
+
+		location := resp.Header.Get("Location")
+		if !isRedirect(resp.StatusCode) || location == "" {
+			return resp, currentUrl, hops, nil
+		}
+		resp.Body.Close()
+
+		hops = append(hops, RedirectHop{
+			Url:        currentUrl,
+			StatusCode: resp.StatusCode,
+			Location:   location,
+		})
+
+		if len(hops) > maxRedirects {
+			return nil, currentUrl, hops, ErrTooManyRedirects
+		}
+
+		// The location may be relative to the current url
+		base, err := url.Parse(currentUrl)
+		if err != nil {
+			return nil, currentUrl, hops, err
+		}
+		next, err := url.Parse(location)
+		if err != nil {
+			return nil, currentUrl, hops, fmt.Errorf(
+				"invalid redirect location %q: %s", location, err,
+			)
+		}
+		nextUrl, err := services.NormalizeUrl(base.ResolveReference(next).String())
+		if err != nil {
+			return nil, currentUrl, hops, fmt.Errorf(
+				"invalid redirect location %q: %s", location, err,
+			)
+		}
+
+		if _, ok := visited[nextUrl]; ok {
+			return nil, currentUrl, hops, ErrRedirectLoop
+		}
+		visited[nextUrl] = struct{}{}
+		currentUrl = nextUrl
+	}
+}
+
+// formatRedirectChain returns a readable form of the
+// redirect hops, e.g. "301 http://a.com/ → https://a.com/".
+func formatRedirectChain(hops []RedirectHop) string {
+	chain := make([]string, len(hops))
+	for i, hop := range hops {
+		chain[i] = fmt.Sprintf("%d %s → %s", hop.StatusCode, hop.Url, hop.Location)
+	}
+
+	return strings.Join(chain, ", ")
+}
+
+/* HTTP REDIRECTS:
+https://developer.mozilla.org/en-US/docs/Web/HTTP/Redirections
+https://pkg.go.dev/net/http#Client (see `CheckRedirect` and `ErrUseLastResponse`)
+*/
@@ -0,0 +1,57 @@
+package search
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchFollowingRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/old", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/moved", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/new#top", http.StatusFound)
+	})
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("final"))
+	})
+	mux.HandleFunc("/loop-a", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop-b", http.StatusFound)
+	})
+	mux.HandleFunc("/loop-b", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop-a", http.StatusFound)
+	})
+	n := 0
+	mux.HandleFunc("/endless", func(w http.ResponseWriter, r *http.Request) {
+		n++
+		http.Redirect(w, r, "/endless?n="+string(rune('a'+n)), http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	// Redirect chain
+	resp, finalUrl, hops, err := fetchFollowingRedirects(context.Background(), NewHttpFetcher(0), server.URL+"/old", nil)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, server.URL+"/new", finalUrl)
+	assert.Equal(t, []RedirectHop{
+		{server.URL + "/old", http.StatusMovedPermanently, "/moved"},
+		{server.URL + "/moved", http.StatusFound, "/new#top"},
+	}, hops)
+
+	// Redirect loop
+	_, _, hops, err = fetchFollowingRedirects(context.Background(), NewHttpFetcher(0), server.URL+"/loop-a", nil)
+	assert.ErrorIs(t, err, ErrRedirectLoop)
+	assert.Len(t, hops, 2)
+
+	// Too many redirects
+	_, _, hops, err = fetchFollowingRedirects(context.Background(), NewHttpFetcher(0), server.URL+"/endless", nil)
+	assert.ErrorIs(t, err, ErrTooManyRedirects)
+	assert.Len(t, hops, maxRedirects+1)
+}
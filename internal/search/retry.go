@@ -0,0 +1,64 @@
+package search
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxRetries is the number of times a url that failed with a transient
+	// error is crawled again before the failure is taken as permanent.
+	maxRetries = 5
+	// retryBaseDelay and retryMaxDelay bound the exponential backoff
+	// between the retries: 5m, 10m, 20m, 40m… up to a day.
+	retryBaseDelay = 5 * time.Minute
+	retryMaxDelay  = 24 * time.Hour
+	// maxRetryAfter is the longest `Retry-After` delay that is honoured.
+	maxRetryAfter = 7 * 24 * time.Hour
+)
+
+// parseRetryAfter returns the delay of a `Retry-After` header,
+// given in seconds or as an HTTP date, or 0 if there is none.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	var d time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = t.Sub(now)
+	}
+
+	if d < 0 {
+		return 0
+	}
+
+	return min(d, maxRetryAfter)
+}
+
+// retryDelay returns the time to wait before the given retry (1, 2…),
+// which doubles with each retry and is jittered, so that the urls that
+// failed together are not retried at the same time. The delay asked by
+// the server is honoured if it is longer.
+func retryDelay(retry int, retryAfter time.Duration) time.Duration {
+	d := retryMaxDelay
+	if retry < 20 {
+		d = min(retryBaseDelay<<(retry-1), retryMaxDelay)
+	}
+
+	// "Equal jitter": between half and all of the delay
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+
+	return max(d, retryAfter)
+}
+
+/* RETRIES AND BACKOFF:
+https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/Retry-After
+*/
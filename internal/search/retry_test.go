@@ -0,0 +1,43 @@
+package search
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"Wed, 01 May 2024 12:30:00 GMT", 30 * time.Minute},
+		{"Wed, 01 May 2024 11:30:00 GMT", 0},
+		{"99999999", maxRetryAfter},
+		{"soon", 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(st *testing.T) {
+			assert.Equal(st, tc.expected, parseRetryAfter(tc.value, now))
+		})
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	for retry := 1; retry <= maxRetries; retry++ {
+		base := min(retryBaseDelay<<(retry-1), retryMaxDelay)
+		d := retryDelay(retry, 0)
+		assert.GreaterOrEqual(t, d, base/2)
+		assert.LessOrEqual(t, d, base)
+	}
+
+	assert.LessOrEqual(t, retryDelay(100, 0), retryMaxDelay)
+	assert.Equal(t, 48*time.Hour, retryDelay(1, 48*time.Hour))
+}
@@ -0,0 +1,319 @@
+package search
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"golang.org/x/net/html"
+)
+
+// maxRobotsTxtSize is the maximum size of a robots.txt file that is read.
+const maxRobotsTxtSize = 500 << 10
+
+// RobotsDirectives are the indexing rules that a page declares for
+// crawlers, through `<meta name="robots">` or the `X-Robots-Tag` header.
+type RobotsDirectives struct {
+	NoIndex   bool // The page can be crawled but must not be indexed
+	NoFollow  bool // The links of the page must not be followed
+	NoArchive bool // No cached copy of the page must be kept
+}
+
+// robotsValueDirectives are the directives that contain a colon,
+// so that they are not confused with a user agent prefix.
+var robotsValueDirectives = map[string]struct{}{
+	"unavailable_after": {}, "max-snippet": {},
+	"max-image-preview": {}, "max-video-preview": {},
+}
+
+// parseRobotsDirectives returns the directives of a comma separated list
+// like "noindex, nofollow". Unknown directives are ignored.
+func parseRobotsDirectives(value string) RobotsDirectives {
+	r := RobotsDirectives{}
+	for _, d := range strings.Split(value, ",") {
+		switch strings.ToLower(strings.TrimSpace(d)) {
+		case "noindex":
+			r.NoIndex = true
+		case "nofollow":
+			r.NoFollow = true
+		case "noarchive", "nocache":
+			r.NoArchive = true
+		case "none":
+			r.NoIndex = true
+			r.NoFollow = true
+		}
+	}
+
+	return r
+}
+
+// parseRobotsHeader returns the directives of the `X-Robots-Tag` header
+// values. Values addressed to a specific crawler (e.g. "googlebot: noindex")
+// do not apply to us and are ignored.
+func parseRobotsHeader(values []string) RobotsDirectives {
+	r := RobotsDirectives{}
+	for _, value := range values {
+		if name, _, found := strings.Cut(value, ":"); found {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if _, ok := robotsValueDirectives[name]; !ok &&
+				!strings.ContainsAny(name, ", ") {
+				continue
+			}
+		}
+
+		r = r.merge(parseRobotsDirectives(value))
+	}
+
+	return r
+}
+
+// merge returns the most restrictive combination of both directives.
+func (r RobotsDirectives) merge(o RobotsDirectives) RobotsDirectives {
+	return RobotsDirectives{
+		NoIndex:   r.NoIndex || o.NoIndex,
+		NoFollow:  r.NoFollow || o.NoFollow,
+		NoArchive: r.NoArchive || o.NoArchive,
+	}
+}
+
+// hasRelNoFollow reports whether the `rel` attribute
+// value of a link contains the "nofollow" keyword.
+func hasRelNoFollow(rel string) bool {
+	for _, v := range strings.Fields(strings.ToLower(rel)) {
+		if v == "nofollow" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// getRobotsMeta looks for the `<meta name="robots">`
+// elements of the page and sends their directives.
+func getRobotsMeta(n *html.Node, rch chan RobotsDirectives) {
+	robots := RobotsDirectives{}
+	if n == nil {
+		rch <- robots
+		return
+	}
+
+	var findRobots func(*html.Node)
+	findRobots = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "meta" {
+			var name, content string
+			for _, attr := range n.Attr {
+				if attr.Key == "name" {
+					name = strings.ToLower(strings.TrimSpace(attr.Val))
+				} else if attr.Key == "content" {
+					content = attr.Val
+				}
+			}
+
+			if name == "robots" {
+				robots = robots.merge(parseRobotsDirectives(content))
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			findRobots(c)
+		}
+	}
+
+	// We call the previously defined function
+	findRobots(n)
+
+	rch <- robots
+}
+
+// robotsRule is an `Allow` or `Disallow` line of robots.txt.
+type robotsRule struct {
+	pattern string
+	match   *regexp.Regexp
+	allow   bool
+}
+
+// robotsTxt are the rules of robots.txt for any crawler (`User-agent: *`),
+// the only ones that apply to us, since we send no name of our own.
+type robotsTxt struct {
+	rules       []robotsRule
+	unreachable bool // It could not be fetched, so nothing can be crawled
+}
+
+// robotsPattern returns the regexp of a path pattern of robots.txt, where
+// `*` matches any sequence of characters and a final `$` the end of the path.
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	expr := "^" + strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, ".*")
+	if anchored {
+		expr += "$"
+	}
+
+	return regexp.MustCompile(expr)
+}
+
+// parseRobotsTxt returns the rules of the groups of robots.txt for any
+// crawler. A group is made of consecutive `User-agent` lines and the
+// rules that follow them.
+func parseRobotsTxt(body io.Reader) robotsTxt {
+	robots := robotsTxt{}
+	applies, inRules := false, false
+
+	scanner := bufio.NewScanner(io.LimitReader(body, maxRobotsTxtSize))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		name, value, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+
+		switch name {
+		case "user-agent":
+			// A new group starts after the rules of the previous one
+			if inRules {
+				applies, inRules = false, false
+			}
+			if value == "*" {
+				applies = true
+			}
+		case "allow", "disallow":
+			inRules = true
+			// An empty `Disallow` allows everything
+			if applies && value != "" {
+				robots.rules = append(robots.rules, robotsRule{
+					pattern: value,
+					match:   robotsPattern(value),
+					allow:   name == "allow",
+				})
+			}
+		}
+	}
+
+	return robots
+}
+
+// allows reports whether the path (with its query) can be crawled: the
+// most specific (longest) matching rule applies, `Allow` if they tie.
+func (r robotsTxt) allows(path string) bool {
+	if r.unreachable {
+		return false
+	}
+
+	allowed, longest := true, -1
+	for _, rule := range r.rules {
+		if !rule.match.MatchString(path) {
+			continue
+		}
+		if len(rule.pattern) > longest || len(rule.pattern) == longest && rule.allow {
+			allowed, longest = rule.allow, len(rule.pattern)
+		}
+	}
+
+	return allowed
+}
+
+// robotsHost is the robots.txt of a host, which is fetched once.
+type robotsHost struct {
+	once   sync.Once
+	robots robotsTxt
+}
+
+// robotsCache holds the robots.txt of the hosts crawled in a run.
+type robotsCache struct {
+	fetcher Fetcher
+	mu      sync.Mutex
+	hosts   map[string]*robotsHost
+}
+
+func newRobotsCache(f Fetcher) *robotsCache {
+
+	return &robotsCache{fetcher: f, hosts: map[string]*robotsHost{}}
+}
+
+// fetchRobotsTxt requests the robots.txt of the host (e.g. https://x.com).
+// As RFC 9309 says, everything is allowed if it is unavailable (a 4xx
+// response or too many redirects), and nothing if it is unreachable
+// (a 5xx response or a failed request).
+func fetchRobotsTxt(ctx context.Context, f Fetcher, host string) robotsTxt {
+	resp, _, _, err := fetchFollowingRedirects(ctx, f, host+"/robots.txt", nil)
+	if errors.Is(err, ErrTooManyRedirects) || errors.Is(err, ErrRedirectLoop) {
+		return robotsTxt{}
+	}
+	if err != nil {
+		return robotsTxt{unreachable: true}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		return robotsTxt{unreachable: true}
+	case resp.StatusCode != http.StatusOK:
+		return robotsTxt{}
+	}
+
+	return parseRobotsTxt(resp.Body)
+}
+
+// check returns why the robots.txt of its host does not allow crawling
+// the url, if it does not. The failure is transient when the robots.txt
+// could not be reached, so that the url is crawled once it can be.
+func (rc *robotsCache) check(ctx context.Context, rawUrl string) *CrawlFailure {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Host == "" {
+		return nil
+	}
+	host := u.Scheme + "://" + u.Host
+
+	rc.mu.Lock()
+	h, ok := rc.hosts[host]
+	if !ok {
+		h = &robotsHost{}
+		rc.hosts[host] = h
+	}
+	rc.mu.Unlock()
+
+	h.once.Do(func() {
+		h.robots = fetchRobotsTxt(ctx, rc.fetcher, host)
+	})
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+
+	if h.robots.unreachable {
+		return &CrawlFailure{
+			Kind:      services.FailureRobots,
+			Reason:    "robots.txt unreachable",
+			Transient: true,
+		}
+	}
+	if !h.robots.allows(path) {
+		return permanentFailure(services.FailureRobots, "blocked by robots.txt")
+	}
+
+	return nil
+}
+
+/* ROBOTS META TAG AND X-ROBOTS-TAG:
+https://developers.google.com/search/docs/crawling-indexing/robots-meta-tag
+https://developer.mozilla.org/en-US/docs/Web/HTTP/Headers/X-Robots-Tag
+https://developers.google.com/search/docs/crawling-indexing/qualify-outbound-links
+
+ROBOTS.TXT:
+https://www.rfc-editor.org/rfc/rfc9309
+https://developers.google.com/search/docs/crawling-indexing/robots/robots_txt
+*/
@@ -0,0 +1,154 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/emarifer/search-engine/internal/services"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/html"
+)
+
+func TestParseRobotsHeader(t *testing.T) {
+	testCases := []struct {
+		values   []string
+		expected RobotsDirectives
+	}{
+		{nil, RobotsDirectives{}},
+		{[]string{"all"}, RobotsDirectives{}},
+		{[]string{"noindex"}, RobotsDirectives{NoIndex: true}},
+		{[]string{"NoIndex, NoFollow"}, RobotsDirectives{NoIndex: true, NoFollow: true}},
+		{[]string{"none"}, RobotsDirectives{NoIndex: true, NoFollow: true}},
+		{[]string{"noarchive", "nofollow"}, RobotsDirectives{NoFollow: true, NoArchive: true}},
+		{[]string{"googlebot: noindex"}, RobotsDirectives{}},
+		{[]string{"unavailable_after: 25 Jun 2010 15:00:00 PST, noarchive"}, RobotsDirectives{NoArchive: true}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strings.Join(tc.values, "|"), func(st *testing.T) {
+			assert.Equal(st, tc.expected, parseRobotsHeader(tc.values))
+		})
+	}
+}
+
+func TestGetRobotsMeta(t *testing.T) {
+	doc, _ := html.Parse(strings.NewReader(`
+		<html>
+			<head>
+				<meta name="description" content="noindex">
+				<meta name="ROBOTS" content="noindex">
+				<meta name="robots" content="noarchive">
+			</head>
+		</html>
+	`))
+
+	robotsch := make(chan RobotsDirectives)
+
+	// Call the function `getRobotsMeta`
+	go getRobotsMeta(doc, robotsch)
+
+	assert.Equal(t, RobotsDirectives{NoIndex: true, NoArchive: true}, <-robotsch)
+}
+
+func TestParseRobotsTxt(t *testing.T) {
+	robots := parseRobotsTxt(strings.NewReader(`
+		# Only the groups for any crawler apply
+		User-agent: Googlebot
+		Disallow: /
+
+		User-agent: Bingbot
+		User-agent: *
+		Disallow: /private/ # Comments are ignored
+		Allow: /private/public
+		Disallow: /*.pdf$
+		Disallow: /search?
+
+		User-agent: Other
+		Disallow: /other
+		Disallow:
+	`))
+
+	testCases := []struct {
+		path    string
+		allowed bool
+	}{
+		{"/", true},
+		{"/other", true},
+		{"/private", true},
+		{"/private/", false},
+		{"/private/notes", false},
+		{"/private/public/notes", true},
+		{"/docs/guide.pdf", false},
+		{"/docs/guide.pdf?download=1", true},
+		{"/search?q=go", false},
+		{"/search", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.path, func(st *testing.T) {
+			assert.Equal(st, tc.allowed, robots.allows(tc.path))
+		})
+	}
+
+	// Nothing is disallowed without rules for any crawler
+	assert.True(t, parseRobotsTxt(strings.NewReader("User-agent: Googlebot\nDisallow: /")).allows("/"))
+}
+
+// statusFetcher answers every request with the given status, or fails.
+type statusFetcher int
+
+func (sf statusFetcher) Fetch(ctx context.Context, url string, headers http.Header) (*http.Response, error) {
+	if sf == 0 {
+		return nil, errors.New("connection refused")
+	}
+
+	return &http.Response{
+		StatusCode: int(sf),
+		Header:     http.Header{},
+		Body:       io.NopCloser(strings.NewReader("User-agent: *\nDisallow: /private/\n")),
+	}, nil
+}
+
+func TestRobotsCacheCheck(t *testing.T) {
+	testCases := []struct {
+		name      string
+		fetcher   statusFetcher
+		public    bool // The public url is allowed
+		transient bool // The failures are retried
+	}{
+		{"found", http.StatusOK, true, false},
+		{"not found", http.StatusNotFound, true, false},
+		{"forbidden", http.StatusForbidden, true, false},
+		{"unavailable", http.StatusServiceUnavailable, false, true},
+		{"too many requests", http.StatusTooManyRequests, false, true},
+		{"unreachable", 0, false, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			robots := newRobotsCache(tc.fetcher)
+
+			failure := robots.check(context.Background(), "https://example.com/page")
+			if tc.public {
+				assert.Nil(st, failure)
+			} else if assert.NotNil(st, failure) {
+				assert.Equal(st, services.FailureRobots, failure.Kind)
+				assert.Equal(st, tc.transient, failure.Transient)
+			}
+
+			// The rules only apply when the robots.txt is found
+			failure = robots.check(context.Background(), "https://example.com/private/page")
+			if tc.fetcher == http.StatusOK {
+				assert.NotNil(st, failure)
+				assert.False(st, failure.Transient)
+			} else if tc.public {
+				assert.Nil(st, failure)
+			}
+		})
+	}
+}
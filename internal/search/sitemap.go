@@ -0,0 +1,320 @@
+package search
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	// maxSitemapSize is the maximum uncompressed size of a sitemap
+	// and the maximum number of urls it can list, as in the protocol.
+	maxSitemapSize = 50 << 20
+	maxSitemapUrls = 50_000
+	// sitemapsPerRun is the number of sitemaps fetched in each crawl.
+	sitemapsPerRun = 10
+	// sitemapRefresh is the time after which a sitemap is fetched again.
+	sitemapRefresh = 24 * time.Hour
+	// defaultPriority is the priority of the urls that do not declare one.
+	defaultPriority = 0.5
+)
+
+// sitemapXml matches both the `<urlset>` of a sitemap
+// and the `<sitemapindex>` of a sitemap index.
+type sitemapXml struct {
+	XMLName  xml.Name
+	Urls     []sitemapEntry `xml:"url"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+type sitemapEntry struct {
+	Loc        string `xml:"loc"`
+	LastMod    string `xml:"lastmod"`
+	ChangeFreq string `xml:"changefreq"`
+	Priority   string `xml:"priority"`
+}
+
+// lastModLayouts are the W3C Datetime formats allowed for `<lastmod>`.
+var lastModLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+// changeFreqs maps the `<changefreq>` values
+// to the time after which the url is crawled again.
+var changeFreqs = map[string]time.Duration{
+	"always":  time.Hour,
+	"hourly":  time.Hour,
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+	"yearly":  365 * 24 * time.Hour,
+}
+
+// nextCrawlAt returns when a url with the given change frequency must be
+// crawled again, or nil if it must not ("never" or no frequency at all).
+func nextCrawlAt(changeFreq string, tested time.Time) *time.Time {
+	d, ok := changeFreqs[changeFreq]
+	if !ok {
+		return nil
+	}
+	next := tested.Add(d)
+
+	return &next
+}
+
+// parseSitemap decodes a sitemap or sitemap index, gzipped or not.
+func parseSitemap(body io.Reader) (sitemapXml, error) {
+	br := bufio.NewReader(body)
+
+	// Gzipped sitemaps (`sitemap.xml.gz`) are usually
+	// served as binary files, so we check the magic number
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return sitemapXml{}, fmt.Errorf("invalid gzipped sitemap: %s", err)
+		}
+		defer gz.Close()
+		body = gz
+	} else {
+		body = br
+	}
+
+	sm := sitemapXml{}
+	decoder := xml.NewDecoder(io.LimitReader(body, maxSitemapSize))
+	decoder.CharsetReader = charset.NewReaderLabel
+	if err := decoder.Decode(&sm); err != nil {
+		return sitemapXml{}, fmt.Errorf("invalid sitemap: %s", err)
+	}
+
+	if sm.XMLName.Local != "urlset" && sm.XMLName.Local != "sitemapindex" {
+		return sitemapXml{}, fmt.Errorf(
+			"invalid sitemap: unexpected root element <%s>", sm.XMLName.Local,
+		)
+	}
+
+	return sm, nil
+}
+
+// toCrawledUrl converts a sitemap entry into a url to
+// be crawled, with its `lastmod`, `changefreq` and `priority` hints.
+func (e sitemapEntry) toCrawledUrl() services.CrawledUrl {
+	u := services.CrawledUrl{
+		Url:        strings.TrimSpace(e.Loc),
+		Priority:   defaultPriority,
+		ChangeFreq: strings.ToLower(strings.TrimSpace(e.ChangeFreq)),
+	}
+
+	if p, err := strconv.ParseFloat(strings.TrimSpace(e.Priority), 64); err == nil &&
+		p >= 0 && p <= 1 {
+		u.Priority = p
+	}
+
+	lastMod := strings.TrimSpace(e.LastMod)
+	for _, layout := range lastModLayouts {
+		if t, err := time.Parse(layout, lastMod); err == nil {
+			u.LastModified = &t
+			break
+		}
+	}
+
+	return u
+}
+
+// parseRobotsSitemaps returns the urls of the `Sitemap:` lines of robots.txt.
+func parseRobotsSitemaps(body io.Reader) []string {
+	sitemaps := []string{}
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		name, value, found := strings.Cut(scanner.Text(), ":")
+		if found && strings.EqualFold(strings.TrimSpace(name), "sitemap") {
+			if v := strings.TrimSpace(value); v != "" {
+				sitemaps = append(sitemaps, v)
+			}
+		}
+	}
+
+	return sitemaps
+}
+
+// discoverSitemaps returns the sitemaps of the host (e.g. https://x.com)
+// declared in its robots.txt or, if there is none, the default /sitemap.xml.
+func discoverSitemaps(ctx context.Context, f Fetcher, host string) []string {
+	resp, _, _, err := fetchFollowingRedirects(ctx, f, host+"/robots.txt", nil)
+	if err == nil {
+		defer resp.Body.Close()
+		if resp.StatusCode == 200 {
+			sitemaps := parseRobotsSitemaps(io.LimitReader(resp.Body, 1<<20))
+			if len(sitemaps) > 0 {
+				return sitemaps
+			}
+		}
+	}
+
+	return []string{host + "/sitemap.xml"}
+}
+
+// fetchSitemap requests and decodes the sitemap at the given url.
+func fetchSitemap(
+	ctx context.Context, f Fetcher, sitemapUrl string,
+) (sitemapXml, error) {
+	resp, _, _, err := fetchFollowingRedirects(ctx, f, sitemapUrl, nil)
+	if err != nil {
+		return sitemapXml{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return sitemapXml{}, fmt.Errorf("non 200 code found: %d", resp.StatusCode)
+	}
+
+	return parseSitemap(resp.Body)
+}
+
+// hostOf returns the scheme and host of the url, e.g. https://x.com
+func hostOf(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+
+	return u.Scheme + "://" + u.Host
+}
+
+// sameHost reports whether both urls are on the same host.
+func sameHost(a, b string) bool {
+	ua, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	ub, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+
+	return ua.Host != "" && strings.EqualFold(ua.Host, ub.Host)
+}
+
+// ingestSitemaps discovers the sitemaps of the given hosts that were not
+// discovered before, and then fetches the sitemaps that are due, storing
+// the urls they list (or the sitemaps, in the case of sitemap indexes).
+// Only the urls allowed by the scope are stored.
+// Returns the number of new urls added to the database.
+// It stops when the context is done, leaving the pending
+// sitemaps to be fetched in the next run.
+func ingestSitemaps(
+	ctx context.Context,
+	f Fetcher,
+	sms SitemapStore,
+	us UrlStore,
+	scope services.CrawlScope,
+	hosts []string,
+) int {
+	for _, host := range hosts {
+		if ctx.Err() != nil {
+			return 0
+		}
+
+		known, err := sms.HasHost(ctx, host)
+		if err != nil {
+			fmt.Printf("something went wrong checking the sitemaps: %s\n", err)
+
+			return 0
+		}
+		if known {
+			continue
+		}
+
+		for _, sitemapUrl := range discoverSitemaps(ctx, f, host) {
+			err := sms.Save(ctx, &services.Sitemap{Url: sitemapUrl, Host: host})
+			if err != nil {
+				fmt.Printf("something went wrong adding a sitemap: %s\n", err)
+			}
+		}
+	}
+
+	due, err := sms.GetDue(ctx, time.Now().Add(-sitemapRefresh), sitemapsPerRun)
+	if err != nil {
+		fmt.Printf("something went wrong getting the sitemaps: %s\n", err)
+
+		return 0
+	}
+
+	added := 0
+	for _, sitemap := range due {
+		if ctx.Err() != nil {
+			break
+		}
+
+		fetched := time.Now()
+		sitemap.LastFetched = &fetched
+		sitemap.LastError = ""
+		sitemap.UrlCount = 0
+
+		sm, err := fetchSitemap(ctx, f, sitemap.Url)
+		if err != nil {
+			log.Printf("something went wrong fetching %s: %s\n", sitemap.Url, err)
+			sitemap.LastError = err.Error()
+		}
+
+		// A sitemap index lists other sitemaps of the same site, so
+		// those of other hosts are ignored: a site cannot have the
+		// sitemaps of any other one fetched on its behalf
+		for _, entry := range sm.Sitemaps {
+			childUrl, err := services.NormalizeUrl(strings.TrimSpace(entry.Loc))
+			if err != nil || !sameHost(childUrl, sitemap.Host) {
+				continue
+			}
+			child := services.Sitemap{Url: childUrl, Host: sitemap.Host}
+			if err := sms.Save(ctx, &child); err != nil {
+				fmt.Printf("something went wrong adding a sitemap: %s\n", err)
+			}
+		}
+
+		for i, entry := range sm.Urls {
+			if i == maxSitemapUrls {
+				break
+			}
+
+			crUrl := entry.toCrawledUrl()
+			if !scope.Allows(crUrl.Url) {
+				continue
+			}
+
+			_, created, err := us.SaveWithHints(ctx, crUrl)
+			if err != nil {
+				continue
+			}
+			sitemap.UrlCount++
+			if created {
+				added++
+			}
+		}
+
+		if err := sms.UpdateFetched(ctx, sitemap); err != nil {
+			fmt.Printf("something went wrong updating a sitemap: %s\n", err)
+		}
+	}
+
+	return added
+}
+
+/* SITEMAPS PROTOCOL:
+https://www.sitemaps.org/protocol.html
+https://www.w3.org/TR/NOTE-datetime
+https://developers.google.com/search/docs/crawling-indexing/sitemaps/build-sitemap
+*/
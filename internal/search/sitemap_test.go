@@ -0,0 +1,113 @@
+package search
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/stretchr/testify/assert"
+)
+
+const sampleSitemap = `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+	<url>
+		<loc>https://example.com/</loc>
+		<lastmod>2024-07-01</lastmod>
+		<changefreq>Daily</changefreq>
+		<priority>0.8</priority>
+	</url>
+	<url>
+		<loc> https://example.com/about </loc>
+		<lastmod>2024-07-01T10:30:00+02:00</lastmod>
+	</url>
+</urlset>`
+
+func TestParseSitemap(t *testing.T) {
+	sm, err := parseSitemap(strings.NewReader(sampleSitemap))
+	assert.NoError(t, err)
+	assert.Len(t, sm.Urls, 2)
+	assert.Empty(t, sm.Sitemaps)
+
+	home, about := sm.Urls[0].toCrawledUrl(), sm.Urls[1].toCrawledUrl()
+
+	assert.Equal(t, "https://example.com/", home.Url)
+	assert.Equal(t, 0.8, home.Priority)
+	assert.Equal(t, "daily", home.ChangeFreq)
+	assert.Equal(t, time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC), *home.LastModified)
+
+	assert.Equal(t, "https://example.com/about", about.Url)
+	assert.Equal(t, defaultPriority, about.Priority)
+	assert.Equal(t, "", about.ChangeFreq)
+	assert.True(t, time.Date(2024, 7, 1, 8, 30, 0, 0, time.UTC).Equal(*about.LastModified))
+}
+
+func TestParseSitemapIndexGzipped(t *testing.T) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+		<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+			<sitemap><loc>https://example.com/sitemap-posts.xml.gz</loc></sitemap>
+			<sitemap><loc>https://example.com/sitemap-pages.xml</loc></sitemap>
+		</sitemapindex>`))
+	gz.Close()
+
+	sm, err := parseSitemap(&buf)
+	assert.NoError(t, err)
+	assert.Empty(t, sm.Urls)
+	assert.Equal(t, []sitemapEntry{
+		{Loc: "https://example.com/sitemap-posts.xml.gz"},
+		{Loc: "https://example.com/sitemap-pages.xml"},
+	}, sm.Sitemaps)
+
+	_, err = parseSitemap(strings.NewReader(`<html><body></body></html>`))
+	assert.Error(t, err)
+}
+
+func TestParseRobotsSitemaps(t *testing.T) {
+	robots := `User-agent: *
+Disallow: /admin
+sitemap: https://example.com/sitemap.xml
+Sitemap:https://example.com/news.xml
+`
+
+	assert.Equal(t, []string{
+		"https://example.com/sitemap.xml", "https://example.com/news.xml",
+	}, parseRobotsSitemaps(strings.NewReader(robots)))
+}
+
+func TestNextCrawlAt(t *testing.T) {
+	tested := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, tested.Add(24*time.Hour), *nextCrawlAt("daily", tested))
+	assert.Nil(t, nextCrawlAt("never", tested))
+	assert.Nil(t, nextCrawlAt("", tested))
+}
+
+func TestIngestSitemapIndex(t *testing.T) {
+	f := fixtureFetcher{
+		"https://example.com/sitemap.xml": `<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+			<sitemap><loc> https://Example.com/posts.xml#top </loc></sitemap>
+			<sitemap><loc>https://other.com/sitemap.xml</loc></sitemap>
+			<sitemap><loc>not a url</loc></sitemap>
+		</sitemapindex>`,
+	}
+	sms := &fakeSitemaps{sitemaps: []services.Sitemap{
+		{Url: "https://example.com/sitemap.xml", Host: "https://example.com"},
+	}}
+
+	ingestSitemaps(context.Background(), f, sms, &fakeUrls{}, services.CrawlScope{}, nil)
+
+	// Only the sitemaps of the same host are added, normalized
+	urls := []string{}
+	for _, s := range sms.sitemaps {
+		urls = append(urls, s.Url)
+		assert.Equal(t, "https://example.com", s.Host)
+	}
+	assert.Equal(t, []string{
+		"https://example.com/sitemap.xml", "https://example.com/posts.xml",
+	}, urls)
+}
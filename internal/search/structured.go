@@ -0,0 +1,383 @@
+package search
+
+import (
+	"encoding/json"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"golang.org/x/net/html"
+)
+
+// maxJsonLdSize is the maximum size of a JSON-LD block that is decoded.
+const maxJsonLdSize = 1 << 20
+
+// metaPrefixes are the prefixes of the OpenGraph and Twitter card tags.
+var metaPrefixes = []string{"og:", "article:", "twitter:"}
+
+// publishedLayouts are the date formats used by `datePublished`
+// (ISO 8601) and `article:published_time`.
+var publishedLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// getStructuredData collects the OpenGraph/Twitter card tags,
+// the JSON-LD blocks and the microdata items of the page and
+// summarizes them (title, image, author, etc.) for rich results.
+func getStructuredData(
+	n *html.Node, baseUrl *url.URL, sdch chan services.StructuredData,
+) {
+	sd := services.StructuredData{}
+	if n == nil {
+		sdch <- sd
+		return
+	}
+
+	var findData func(*html.Node)
+	findData = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch {
+			case n.Data == "meta":
+				addMetaTag(&sd, n)
+			case n.Data == "script" &&
+				strings.EqualFold(strings.TrimSpace(attrValue(n, "type")), "application/ld+json"):
+				sd.JsonLd = append(sd.JsonLd, parseJsonLd(textOf(n))...)
+			case hasAttr(n, "itemscope") && !hasAttr(n, "itemprop"):
+				// Nested items are stored in the properties of their parent
+				sd.Microdata = append(sd.Microdata, parseMicrodataItem(n, baseUrl))
+			}
+		}
+
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			findData(c)
+		}
+	}
+
+	// We call the previously defined function
+	findData(n)
+
+	summarizeStructuredData(&sd, baseUrl)
+
+	sdch <- sd
+}
+
+// addMetaTag stores the `<meta property="og:…">` and
+// `<meta name="twitter:…">` tags. The first value of a tag wins.
+func addMetaTag(sd *services.StructuredData, n *html.Node) {
+	key := strings.ToLower(strings.TrimSpace(attrValue(n, "property")))
+	if key == "" {
+		key = strings.ToLower(strings.TrimSpace(attrValue(n, "name")))
+	}
+	content := strings.TrimSpace(attrValue(n, "content"))
+	if key == "" || content == "" {
+		return
+	}
+
+	for _, prefix := range metaPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			if sd.OpenGraph == nil {
+				sd.OpenGraph = map[string]string{}
+			}
+			if _, ok := sd.OpenGraph[key]; !ok {
+				sd.OpenGraph[key] = content
+			}
+
+			return
+		}
+	}
+}
+
+// parseJsonLd decodes a JSON-LD block, which can be a single
+// node, an array of nodes or a node with a `@graph` of nodes.
+// Invalid blocks are ignored.
+func parseJsonLd(block string) []map[string]any {
+	if len(block) > maxJsonLdSize {
+		return nil
+	}
+
+	var data any
+	if err := json.Unmarshal([]byte(block), &data); err != nil {
+		return nil
+	}
+
+	nodes := []map[string]any{}
+	var addNodes func(any)
+	addNodes = func(v any) {
+		switch v := v.(type) {
+		case []any:
+			for _, item := range v {
+				addNodes(item)
+			}
+		case map[string]any:
+			if graph, ok := v["@graph"]; ok {
+				addNodes(graph)
+			} else {
+				nodes = append(nodes, v)
+			}
+		}
+	}
+	addNodes(data)
+
+	return nodes
+}
+
+// parseMicrodataItem returns the type and properties of an `itemscope`
+// element. Properties can be declared by any descendant that is not
+// inside a nested item, whose value is then the nested item itself.
+func parseMicrodataItem(n *html.Node, baseUrl *url.URL) services.MicrodataItem {
+	item := services.MicrodataItem{
+		Type:       schemaType(attrValue(n, "itemtype")),
+		Properties: map[string][]any{},
+	}
+
+	var findProps func(*html.Node)
+	findProps = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+
+			names := strings.Fields(attrValue(c, "itemprop"))
+			nested := hasAttr(c, "itemscope")
+
+			var value any
+			if nested {
+				value = parseMicrodataItem(c, baseUrl)
+			} else if len(names) > 0 {
+				value = microdataValue(c, baseUrl)
+			}
+			for _, name := range names {
+				item.Properties[name] = append(item.Properties[name], value)
+			}
+
+			if !nested {
+				findProps(c)
+			}
+		}
+	}
+
+	// We call the previously defined function
+	findProps(n)
+
+	return item
+}
+
+// microdataValue returns the value of an `itemprop` element,
+// which depends on the element, as in the HTML specification.
+func microdataValue(n *html.Node, baseUrl *url.URL) string {
+	switch n.Data {
+	case "meta":
+		return strings.TrimSpace(attrValue(n, "content"))
+	case "audio", "embed", "iframe", "img", "source", "track", "video":
+		return resolveUrl(baseUrl, attrValue(n, "src"))
+	case "a", "area", "link":
+		return resolveUrl(baseUrl, attrValue(n, "href"))
+	case "object":
+		return resolveUrl(baseUrl, attrValue(n, "data"))
+	case "data", "meter":
+		return strings.TrimSpace(attrValue(n, "value"))
+	case "time":
+		if hasAttr(n, "datetime") {
+			return strings.TrimSpace(attrValue(n, "datetime"))
+		}
+	}
+
+	return strings.Join(strings.Fields(textOf(n)), " ")
+}
+
+// summarizeStructuredData fills the types and the fields shown in the rich
+// results, taking them from the OpenGraph/Twitter tags, then from JSON-LD
+// and finally from microdata.
+func summarizeStructuredData(sd *services.StructuredData, baseUrl *url.URL) {
+	seen := map[string]struct{}{}
+	addType := func(t string) {
+		if t = schemaType(t); t != "" {
+			if _, ok := seen[t]; !ok {
+				seen[t] = struct{}{}
+				sd.Types = append(sd.Types, t)
+			}
+		}
+	}
+
+	// The candidates of each field, in order of preference
+	var titles, descriptions, images, authors, sites, published []string
+	og := sd.OpenGraph
+	titles = append(titles, og["og:title"], og["twitter:title"])
+	descriptions = append(descriptions, og["og:description"], og["twitter:description"])
+	images = append(images, og["og:image"], og["og:image:url"], og["twitter:image"])
+	authors = append(authors, og["article:author"], og["twitter:creator"])
+	sites = append(sites, og["og:site_name"])
+	published = append(published, og["article:published_time"])
+
+	for _, node := range sd.JsonLd {
+		for _, t := range jsonLdStrings(node["@type"], "") {
+			addType(t)
+		}
+		titles = append(titles, jsonLdStrings(node["headline"], "")...)
+		titles = append(titles, jsonLdStrings(node["name"], "")...)
+		descriptions = append(descriptions, jsonLdStrings(node["description"], "")...)
+		images = append(images, jsonLdStrings(node["image"], "url")...)
+		authors = append(authors, jsonLdStrings(node["author"], "name")...)
+		sites = append(sites, jsonLdStrings(node["publisher"], "name")...)
+		published = append(published, jsonLdStrings(node["datePublished"], "")...)
+	}
+
+	for _, item := range sd.Microdata {
+		addType(item.Type)
+		props := item.Properties
+		titles = append(titles, microdataStrings(props["headline"])...)
+		titles = append(titles, microdataStrings(props["name"])...)
+		descriptions = append(descriptions, microdataStrings(props["description"])...)
+		images = append(images, microdataStrings(props["image"])...)
+		authors = append(authors, microdataStrings(props["author"])...)
+		published = append(published, microdataStrings(props["datePublished"])...)
+	}
+
+	sd.Title = firstNonEmpty(titles)
+	sd.Description = firstNonEmpty(descriptions)
+	sd.Image = resolveUrl(baseUrl, firstNonEmpty(images))
+	sd.Author = firstNonEmpty(authors)
+	sd.SiteName = firstNonEmpty(sites)
+
+	for _, p := range published {
+		if t := parsePublished(p); t != nil {
+			sd.PublishedAt = t
+			break
+		}
+	}
+}
+
+// jsonLdStrings returns the string values of a JSON-LD property, which
+// can be a string, an object (whose `key` is taken, e.g. the "name" of an
+// author) or an array of them.
+func jsonLdStrings(v any, key string) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{strings.TrimSpace(v)}
+	case []any:
+		values := []string{}
+		for _, item := range v {
+			values = append(values, jsonLdStrings(item, key)...)
+		}
+		return values
+	case map[string]any:
+		if key != "" {
+			return jsonLdStrings(v[key], "")
+		}
+	}
+
+	return nil
+}
+
+// microdataStrings returns the string values of a microdata property,
+// taking the "name" (or "url", for images) of the nested items.
+func microdataStrings(values []any) []string {
+	strs := []string{}
+	for _, v := range values {
+		switch v := v.(type) {
+		case string:
+			strs = append(strs, v)
+		case services.MicrodataItem:
+			strs = append(strs, microdataStrings(v.Properties["name"])...)
+			strs = append(strs, microdataStrings(v.Properties["url"])...)
+		}
+	}
+
+	return strs
+}
+
+// schemaType returns the name of a schema.org type,
+// e.g. "Article" for "https://schema.org/Article".
+// Only the first of several space-separated types is taken.
+func schemaType(t string) string {
+	fields := strings.Fields(t)
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return path.Base(strings.TrimRight(fields[0], "/#"))
+}
+
+// parsePublished parses a publication date in ISO 8601 format.
+func parsePublished(v string) *time.Time {
+	v = strings.TrimSpace(v)
+	for _, layout := range publishedLayouts {
+		if t, err := time.Parse(layout, v); err == nil {
+			return &t
+		}
+	}
+
+	return nil
+}
+
+func firstNonEmpty(values []string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+
+	return ""
+}
+
+// resolveUrl returns the absolute form of a (possibly relative) url.
+func resolveUrl(baseUrl *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+
+	return baseUrl.ResolveReference(u).String()
+}
+
+func attrValue(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return true
+		}
+	}
+
+	return false
+}
+
+// textOf returns the text of all the descendants of the node.
+func textOf(n *html.Node) string {
+	var sb strings.Builder
+	var collect func(*html.Node)
+	collect = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			collect(c)
+		}
+	}
+	collect(n)
+
+	return sb.String()
+}
+
+/* STRUCTURED DATA:
+https://developers.google.com/search/docs/appearance/structured-data/intro-structured-data
+https://schema.org/docs/gs.html
+https://html.spec.whatwg.org/multipage/microdata.html#values
+*/
@@ -0,0 +1,96 @@
+package search
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/html"
+)
+
+func TestGetStructuredData(t *testing.T) {
+	doc, _ := html.Parse(strings.NewReader(`
+		<html>
+			<head>
+				<meta property="og:title" content="Best pancakes">
+				<meta property="og:image" content="/img/pancakes.jpg">
+				<meta property="og:image" content="/img/other.jpg">
+				<meta name="twitter:card" content="summary_large_image">
+				<meta name="description" content="Not OpenGraph">
+				<script type="application/ld+json">
+					{
+						"@context": "https://schema.org",
+						"@graph": [
+							{"@type": "Recipe", "name": "Pancakes",
+							 "author": {"@type": "Person", "name": "Jane Doe"},
+							 "datePublished": "2024-03-01"},
+							{"@type": ["WebPage", "Recipe"]}
+						]
+					}
+				</script>
+				<script type="application/ld+json">{ invalid json</script>
+			</head>
+			<body>
+				<div itemscope itemtype="https://schema.org/Product">
+					<span itemprop="name">Pan</span>
+					<a itemprop="url" href="/pan">Buy</a>
+					<div itemprop="offers" itemscope itemtype="https://schema.org/Offer">
+						<meta itemprop="price" content="9.99">
+					</div>
+				</div>
+			</body>
+		</html>
+	`))
+
+	baseUrl, _ := url.Parse("https://example.com/recipes/pancakes")
+	sdch := make(chan services.StructuredData)
+
+	// Call the function `getStructuredData`
+	go getStructuredData(doc, baseUrl, sdch)
+	sd := <-sdch
+
+	assert.Equal(t, []string{"Recipe", "WebPage", "Product"}, sd.Types)
+	assert.Equal(t, "Best pancakes", sd.Title)
+	assert.Equal(t, "https://example.com/img/pancakes.jpg", sd.Image)
+	assert.Equal(t, "Jane Doe", sd.Author)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), *sd.PublishedAt)
+	assert.Equal(t, map[string]string{
+		"og:title":     "Best pancakes",
+		"og:image":     "/img/pancakes.jpg",
+		"twitter:card": "summary_large_image",
+	}, sd.OpenGraph)
+	assert.Len(t, sd.JsonLd, 2)
+
+	assert.Equal(t, []services.MicrodataItem{{
+		Type: "Product",
+		Properties: map[string][]any{
+			"name": {"Pan"},
+			"url":  {"https://example.com/pan"},
+			"offers": {services.MicrodataItem{
+				Type:       "Offer",
+				Properties: map[string][]any{"price": {"9.99"}},
+			}},
+		},
+	}}, sd.Microdata)
+}
+
+func TestSchemaType(t *testing.T) {
+	testCases := []struct {
+		itemType string
+		expected string
+	}{
+		{"", ""},
+		{"Article", "Article"},
+		{"https://schema.org/NewsArticle", "NewsArticle"},
+		{"http://schema.org/Recipe/ http://schema.org/Thing", "Recipe"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.itemType, func(st *testing.T) {
+			assert.Equal(st, tc.expected, schemaType(tc.itemType))
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ApiKey gives access to the search API with its own limits. Only the
+// hash of the key is stored, the key itself is shown once, when created.
+type ApiKey struct {
+	ID         uint       `gorm:"primaryKey" json:"id"`
+	Name       string     `gorm:"not null" json:"name"`
+	Prefix     string     `gorm:"not null" json:"prefix"` // Start of the key, to recognize it
+	Hash       string     `gorm:"uniqueIndex;not null" json:"-"`
+	RateLimit  int        `gorm:"default:60" json:"rateLimit"` // Requests per minute
+	Burst      int        `gorm:"default:10" json:"burst"`     // Requests in a row
+	DailyQuota int        `gorm:"default:0" json:"dailyQuota"` // Requests per day, 0 is unlimited
+	UsageCount int64      `gorm:"default:0" json:"usageCount"`
+	UsageToday int        `gorm:"default:0" json:"usageToday"`
+	UsageDay   string     `json:"usageDay"` // Day (YYYY-MM-DD) of `UsageToday`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	RevokedAt  *time.Time `gorm:"index" json:"revokedAt"`
+	CreatedAt  time.Time  `gorm:"datetime:timestamp;default:CURRENT_TIMESTAMP" json:"createdAt"`
+}
+
+// ErrApiKeyNotFound is returned for the unknown and the revoked keys.
+var ErrApiKeyNotFound = errors.New("api key not found")
+
+const (
+	apiKeyPrefix = "se_"
+	apiKeyBytes  = 24
+	shownPrefix  = len(apiKeyPrefix) + 6
+)
+
+// HashApiKey returns the hash of the key, as it is stored.
+func HashApiKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// NewApiKey validates the limits of a key and returns it along with
+// the key itself, which is random and long enough not to need a salt.
+func NewApiKey(name string, rateLimit, burst, dailyQuota int) (ApiKey, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ApiKey{}, "", fmt.Errorf("the name cannot be empty")
+	}
+	if rateLimit <= 0 || burst <= 0 {
+		return ApiKey{}, "", fmt.Errorf("the rate limit and the burst must be positive")
+	}
+	if dailyQuota < 0 {
+		return ApiKey{}, "", fmt.Errorf("the daily quota cannot be negative")
+	}
+
+	random := make([]byte, apiKeyBytes)
+	if _, err := rand.Read(random); err != nil {
+		return ApiKey{}, "", fmt.Errorf("the key could not be generated: %s", err)
+	}
+	key := apiKeyPrefix + hex.EncodeToString(random)
+
+	return ApiKey{
+		Name:       name,
+		Prefix:     key[:shownPrefix],
+		Hash:       HashApiKey(key),
+		RateLimit:  rateLimit,
+		Burst:      burst,
+		DailyQuota: dailyQuota,
+	}, key, nil
+}
+
+type ApiKeyServices struct {
+	ApiKey      ApiKey
+	ApiKeyStore *gorm.DB
+}
+
+func NewApiKeyServices(k ApiKey, kStore *gorm.DB) ApiKeyServices {
+
+	return ApiKeyServices{
+		ApiKey:      k,
+		ApiKeyStore: kStore,
+	}
+}
+
+// GetAll returns all the keys, the revoked ones included, the newest first.
+func (ks *ApiKeyServices) GetAll(ctx context.Context) ([]ApiKey, error) {
+	var keys []ApiKey
+
+	tx := ks.ApiKeyStore.WithContext(ctx).Order("created_at DESC").Find(&keys)
+	if tx.Error != nil {
+		return []ApiKey{}, fmt.Errorf("api keys not found: %s", tx.Error)
+	}
+
+	return keys, nil
+}
+
+// GetByKey returns the key, unless it is unknown or revoked.
+func (ks *ApiKeyServices) GetByKey(ctx context.Context, key string) (ApiKey, error) {
+	var k ApiKey
+
+	tx := ks.ApiKeyStore.WithContext(ctx).
+		Where("hash = ? AND revoked_at IS NULL", HashApiKey(key)).
+		First(&k)
+	if errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		return ApiKey{}, ErrApiKeyNotFound
+	}
+	if tx.Error != nil {
+		return ApiKey{}, fmt.Errorf("api key not found: %s", tx.Error)
+	}
+
+	return k, nil
+}
+
+func (ks *ApiKeyServices) Save(ctx context.Context, input *ApiKey) error {
+	tx := ks.ApiKeyStore.WithContext(ctx).Create(input)
+	if tx.Error != nil {
+		return fmt.Errorf("the api key could not be saved: %s", tx.Error)
+	}
+
+	return nil
+}
+
+// Revoke disables the key, which is kept along with its usage.
+func (ks *ApiKeyServices) Revoke(ctx context.Context, id uint) error {
+	tx := ks.ApiKeyStore.WithContext(ctx).
+		Model(&ApiKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if tx.Error != nil {
+		return fmt.Errorf("the api key could not be revoked: %s", tx.Error)
+	}
+
+	return nil
+}
+
+// usageQuery returns the update that counts a request made with the key
+// at the given time, unless it exceeds the daily quota of the key.
+func usageQuery(tx *gorm.DB, id uint, at time.Time) *gorm.DB {
+	day := at.Format(time.DateOnly)
+
+	return tx.
+		Model(&ApiKey{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Where("daily_quota = 0 OR usage_day IS DISTINCT FROM ? OR usage_today < daily_quota", day).
+		Updates(map[string]any{
+			"usage_count":  gorm.Expr("usage_count + 1"),
+			"usage_today":  gorm.Expr("CASE WHEN usage_day = ? THEN usage_today + 1 ELSE 1 END", day),
+			"usage_day":    day,
+			"last_used_at": at,
+		})
+}
+
+// RecordUsage counts a request made with the key, reporting whether it is
+// within the daily quota of the key (the requests beyond it are not counted).
+// The days are those of the server, as the times of the cron jobs.
+func (ks *ApiKeyServices) RecordUsage(ctx context.Context, id uint, at time.Time) (bool, error) {
+	tx := usageQuery(ks.ApiKeyStore.WithContext(ctx), id, at)
+	if tx.Error != nil {
+		return false, fmt.Errorf("the usage of the api key could not be counted: %s", tx.Error)
+	}
+
+	return tx.RowsAffected == 1, nil
+}
+
+/* API KEYS:
+https://cheatsheetseries.owasp.org/cheatsheets/REST_Security_Cheat_Sheet.html#api-keys
+https://pkg.go.dev/crypto/rand#Read
+*/
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestNewApiKey(t *testing.T) {
+	k, key, err := NewApiKey("  my app ", 60, 10, 1000)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(key, "se_"))
+	assert.Len(t, key, len("se_")+2*apiKeyBytes)
+	assert.Equal(t, ApiKey{
+		Name:       "my app",
+		Prefix:     key[:9],
+		Hash:       HashApiKey(key),
+		RateLimit:  60,
+		Burst:      10,
+		DailyQuota: 1000,
+	}, k)
+	assert.NotContains(t, k.Hash, key[3:])
+
+	_, other, err := NewApiKey("my app", 60, 10, 0)
+	assert.NoError(t, err)
+	assert.NotEqual(t, key, other)
+
+	tests := []struct {
+		name                         string
+		rateLimit, burst, dailyQuota int
+	}{
+		{" ", 60, 10, 0},
+		{"my app", 0, 10, 0},
+		{"my app", 60, 0, 0},
+		{"my app", 60, 10, -1},
+	}
+	for _, tt := range tests {
+		_, _, err := NewApiKey(tt.name, tt.rateLimit, tt.burst, tt.dailyQuota)
+		assert.Error(t, err, tt)
+	}
+}
+
+func TestUsageQuery(t *testing.T) {
+	at := time.Date(2024, 7, 1, 23, 59, 0, 0, time.Local)
+
+	// The updates are run in a transaction, which needs a connection
+	db := dryRunDB(t).Session(&gorm.Session{SkipDefaultTransaction: true})
+
+	stmt := usageQuery(db, 7, at).Statement
+	sql := stmt.SQL.String()
+
+	assert.Contains(t, sql,
+		`"usage_today"=CASE WHEN usage_day = $3 THEN usage_today + 1 ELSE 1 END`)
+	assert.Contains(t, sql,
+		"WHERE (id = $4 AND revoked_at IS NULL) AND "+
+			"(daily_quota = 0 OR usage_day IS DISTINCT FROM $5 OR usage_today < daily_quota)")
+	assert.Equal(t, []any{at, "2024-07-01", "2024-07-01", uint(7), "2024-07-01"}, stmt.Vars)
+}
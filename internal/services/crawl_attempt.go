@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// CrawlAttempt is each crawl of a url, kept as its history.
+type CrawlAttempt struct {
+	ID            uint          `gorm:"primaryKey" json:"id"`
+	CrawledUrlID  string        `gorm:"type:uuid;index;not null" json:"crawledUrlId"`
+	Success       bool          `json:"success"`
+	ResponseCode  int           `gorm:"type:smallint" json:"responseCode"`
+	CrawlDuration time.Duration `json:"crawlDuration"`
+	FailureKind   FailureKind   `json:"failureKind"`
+	FailureReason string        `json:"failureReason"`
+	CrawledAt     time.Time     `gorm:"index" json:"crawledAt"`
+}
+
+// maxAttempts is the number of crawls kept in the history of each url.
+const maxAttempts = 50
+
+// SaveAttempt adds the crawl to the history of its url,
+// removing the oldest crawls beyond `maxAttempts`.
+func (u *UrlServices) SaveAttempt(ctx context.Context, input CrawlAttempt) error {
+	tx := u.UrlStore.WithContext(ctx).Create(&input)
+	if tx.Error != nil {
+		return fmt.Errorf("the crawl could not be saved: %s", tx.Error)
+	}
+
+	tx = u.UrlStore.WithContext(ctx).
+		Where("crawled_url_id = ?", input.CrawledUrlID).
+		Where(
+			"id NOT IN (?)",
+			u.UrlStore.Model(&CrawlAttempt{}).
+				Select("id").
+				Where("crawled_url_id = ?", input.CrawledUrlID).
+				Order("crawled_at DESC").
+				Limit(maxAttempts),
+		).
+		Delete(&CrawlAttempt{})
+	if tx.Error != nil {
+		return fmt.Errorf("the history could not be pruned: %s", tx.Error)
+	}
+
+	return nil
+}
+
+// GetAttempts returns the history of crawls of the url, the newest first.
+func (u *UrlServices) GetAttempts(ctx context.Context, id string) ([]CrawlAttempt, error) {
+	var attempts []CrawlAttempt
+
+	tx := u.UrlStore.WithContext(ctx).
+		Where("crawled_url_id = ?", id).
+		Order("crawled_at DESC").
+		Limit(maxAttempts).
+		Find(&attempts)
+	if tx.Error != nil {
+		return []CrawlAttempt{}, fmt.Errorf("history not found: %s", tx.Error)
+	}
+
+	return attempts, nil
+}
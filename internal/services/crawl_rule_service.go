@@ -0,0 +1,286 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// RuleKind is what a crawl rule is matched against.
+type RuleKind string
+
+const (
+	RuleHost      RuleKind = "host"      // Exact host, e.g. "example.com"
+	RuleSubdomain RuleKind = "subdomain" // A domain and its subdomains, e.g. "*.example.com"
+	RulePath      RuleKind = "path"      // Regular expression on the path, e.g. "^/blog/"
+)
+
+// CrawlRule allows or denies the crawl of the urls it matches.
+// The urls matched by a deny rule are never added nor crawled and,
+// in scope mode, only the urls matched by an allow rule are.
+type CrawlRule struct {
+	ID        uint      `gorm:"primaryKey" json:"id"`
+	Kind      RuleKind  `gorm:"not null" json:"kind"`
+	Pattern   string    `gorm:"not null" json:"pattern"`
+	Allow     bool      `json:"allow"`
+	CreatedAt time.Time `gorm:"datetime:timestamp;default:CURRENT_TIMESTAMP" json:"createdAt"`
+}
+
+// NewCrawlRule validates the pattern of a rule and returns it normalized.
+func NewCrawlRule(kind RuleKind, pattern string, allow bool) (CrawlRule, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return CrawlRule{}, fmt.Errorf("the pattern cannot be empty")
+	}
+
+	switch kind {
+	case RuleHost:
+		pattern = strings.ToLower(pattern)
+		if strings.ContainsAny(pattern, "*/:?# ") {
+			return CrawlRule{}, fmt.Errorf("invalid host: %q", pattern)
+		}
+	case RuleSubdomain:
+		domain := strings.TrimPrefix(strings.ToLower(pattern), "*.")
+		if domain == "" || strings.ContainsAny(domain, "*/:?# ") {
+			return CrawlRule{}, fmt.Errorf("invalid domain: %q", pattern)
+		}
+		pattern = "*." + domain
+	case RulePath:
+		if _, err := regexp.Compile(pattern); err != nil {
+			return CrawlRule{}, fmt.Errorf("invalid regular expression: %s", err)
+		}
+	default:
+		return CrawlRule{}, fmt.Errorf("unknown kind of rule: %q", kind)
+	}
+
+	return CrawlRule{Kind: kind, Pattern: pattern, Allow: allow}, nil
+}
+
+// domain returns the domain of a subdomain rule, without the wildcard.
+func (r CrawlRule) domain() string {
+	return strings.TrimPrefix(r.Pattern, "*.")
+}
+
+// The patterns that extract the host and the path of the `url` column,
+// which must match what `url.URL` returns in `matches`.
+const (
+	hostPattern = `^[^:/?#]+://(?:[^@/?#]*@)?([^/:?#]+)`
+	pathPattern = `^[^:/?#]+://[^/?#]*([^?#]*)`
+)
+
+// condition returns the SQL condition of the rule on the `url` column.
+// The path expressions are run by Postgres, so they can only use the
+// syntax shared with Go (classes, anchors, groups, repetitions…),
+// which `Save` checks.
+func (r CrawlRule) condition() (string, []any) {
+	host := "coalesce(lower(substring(url from ?)), '')"
+
+	switch r.Kind {
+	case RuleHost:
+		return host + " = ?", []any{hostPattern, r.Pattern}
+	case RuleSubdomain:
+		domain := r.domain()
+		return fmt.Sprintf("(%s = ? OR right(%s, ?) = ?)", host, host),
+			[]any{hostPattern, domain, hostPattern, len(domain) + 1, "." + domain}
+	case RulePath:
+		return "coalesce(nullif(substring(url from ?), ''), '/') ~ ?",
+			[]any{pathPattern, r.Pattern}
+	}
+
+	return "false", nil
+}
+
+// CrawlScope holds the rules that decide which urls are crawled.
+type CrawlScope struct {
+	ScopeOn bool // Only the urls matched by an allow rule are crawled
+	rules   []scopeRule
+}
+
+type scopeRule struct {
+	CrawlRule
+	path *regexp.Regexp // Compiled pattern of the path rules
+}
+
+// NewCrawlScope returns the scope of the given rules.
+// Invalid rules, which cannot be saved, are ignored.
+func NewCrawlScope(rules []CrawlRule, scopeOn bool) CrawlScope {
+	cs := CrawlScope{ScopeOn: scopeOn}
+	for _, r := range rules {
+		sr := scopeRule{CrawlRule: r}
+		if r.Kind == RulePath {
+			re, err := regexp.Compile(r.Pattern)
+			if err != nil {
+				log.Printf("invalid crawl rule %q: %s\n", r.Pattern, err)
+				continue
+			}
+			sr.path = re
+		}
+		cs.rules = append(cs.rules, sr)
+	}
+
+	return cs
+}
+
+// HasAllowRules reports whether any url can be crawled in scope mode.
+func (cs CrawlScope) HasAllowRules() bool {
+	for _, r := range cs.rules {
+		if r.Allow {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matches reports whether the rule matches the url.
+func (r scopeRule) matches(u *url.URL) bool {
+	host := strings.ToLower(u.Hostname())
+
+	switch r.Kind {
+	case RuleHost:
+		return host == r.Pattern
+	case RuleSubdomain:
+		domain := r.domain()
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	case RulePath:
+		path := u.EscapedPath()
+		if path == "" {
+			path = "/"
+		}
+		return r.path != nil && r.path.MatchString(path)
+	}
+
+	return false
+}
+
+// Allows reports whether the url can be added and crawled.
+func (cs CrawlScope) Allows(rawUrl string) bool {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+
+	allowed := !cs.ScopeOn
+	for _, r := range cs.rules {
+		if !r.matches(u) {
+			continue
+		}
+		if !r.Allow {
+			return false
+		}
+		allowed = true
+	}
+
+	return allowed
+}
+
+// apply filters the query of urls with the rules of the scope.
+func (cs CrawlScope) apply(tx *gorm.DB) *gorm.DB {
+	allowed := []string{}
+	allowedArgs := []any{}
+	for _, r := range cs.rules {
+		cond, args := r.condition()
+		if r.Allow {
+			allowed = append(allowed, cond)
+			allowedArgs = append(allowedArgs, args...)
+		} else {
+			tx = tx.Where("NOT ("+cond+")", args...)
+		}
+	}
+
+	if cs.ScopeOn {
+		if len(allowed) == 0 {
+			return tx.Where("false")
+		}
+		tx = tx.Where("("+strings.Join(allowed, " OR ")+")", allowedArgs...)
+	}
+
+	return tx
+}
+
+type CrawlRuleServices struct {
+	Rule      CrawlRule
+	RuleStore *gorm.DB
+}
+
+func NewCrawlRuleServices(r CrawlRule, rStore *gorm.DB) CrawlRuleServices {
+
+	return CrawlRuleServices{
+		Rule:      r,
+		RuleStore: rStore,
+	}
+}
+
+// GetAll returns the rules, the deny rules first.
+func (rs *CrawlRuleServices) GetAll(ctx context.Context) ([]CrawlRule, error) {
+	var rules []CrawlRule
+
+	tx := rs.RuleStore.WithContext(ctx).
+		Order("allow ASC").
+		Order("kind ASC").
+		Order("pattern ASC").
+		Find(&rules)
+	if tx.Error != nil {
+		return []CrawlRule{}, fmt.Errorf("rules not found: %s", tx.Error)
+	}
+
+	return rules, nil
+}
+
+// ErrInvalidRule is returned for the path rules that Postgres cannot run.
+var ErrInvalidRule = errors.New("invalid rule")
+
+// invalidRegexp is the Postgres error code of an invalid regular expression.
+const invalidRegexp = "2201B"
+
+// Save stores the rule, unless there is the same rule already.
+// The expression of a path rule must be valid in Postgres as well,
+// otherwise the query of the next urls to crawl would always fail.
+func (rs *CrawlRuleServices) Save(ctx context.Context, input *CrawlRule) error {
+	if input.Kind == RulePath {
+		var matches bool
+		err := rs.RuleStore.WithContext(ctx).
+			Raw("SELECT '' ~ ?", input.Pattern).
+			Scan(&matches).
+			Error
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == invalidRegexp {
+			return fmt.Errorf("%w: %s", ErrInvalidRule, pgErr.Message)
+		}
+		if err != nil {
+			return fmt.Errorf("the rule could not be saved: %s", err)
+		}
+	}
+
+	tx := rs.RuleStore.WithContext(ctx).
+		Where(map[string]any{
+			"kind": input.Kind, "pattern": input.Pattern, "allow": input.Allow,
+		}).
+		FirstOrCreate(input)
+	if tx.Error != nil {
+		return fmt.Errorf("the rule could not be saved: %s", tx.Error)
+	}
+
+	return nil
+}
+
+func (rs *CrawlRuleServices) Delete(ctx context.Context, id uint) error {
+	tx := rs.RuleStore.WithContext(ctx).Delete(&CrawlRule{}, id)
+	if tx.Error != nil {
+		return fmt.Errorf("the rule could not be deleted: %s", tx.Error)
+	}
+
+	return nil
+}
+
+/* POSTGRES PATTERN MATCHING:
+https://www.postgresql.org/docs/current/functions-matching.html#FUNCTIONS-POSIX-REGEXP
+*/
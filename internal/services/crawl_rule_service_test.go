@@ -0,0 +1,148 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+func TestNewCrawlRule(t *testing.T) {
+	testCases := []struct {
+		kind     RuleKind
+		pattern  string
+		expected string
+		valid    bool
+	}{
+		{RuleHost, " Example.COM ", "example.com", true},
+		{RuleHost, "*.example.com", "", false},
+		{RuleHost, "https://example.com/", "", false},
+		{RuleSubdomain, "*.Example.com", "*.example.com", true},
+		{RuleSubdomain, "example.com", "*.example.com", true},
+		{RuleSubdomain, "*.", "", false},
+		{RulePath, "^/blog/", "^/blog/", true},
+		{RulePath, "^/blog/(", "", false},
+		{RuleKind("query"), "a=b", "", false},
+		{RuleHost, " ", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.kind)+" "+tc.pattern, func(st *testing.T) {
+			rule, err := NewCrawlRule(tc.kind, tc.pattern, true)
+			if !tc.valid {
+				assert.Error(st, err)
+				return
+			}
+			assert.NoError(st, err)
+			assert.Equal(st, tc.expected, rule.Pattern)
+		})
+	}
+}
+
+func TestCrawlScopeAllows(t *testing.T) {
+	rules := []CrawlRule{
+		{Kind: RuleSubdomain, Pattern: "*.example.com", Allow: true},
+		{Kind: RuleHost, Pattern: "golang.org", Allow: true},
+		{Kind: RuleHost, Pattern: "private.example.com", Allow: false},
+		{Kind: RulePath, Pattern: `^/(admin|login)(/|$)`, Allow: false},
+	}
+
+	testCases := []struct {
+		url    string
+		open   bool // Allowed out of scope mode
+		scoped bool // Allowed in scope mode
+	}{
+		{"https://example.com/", true, true},
+		{"https://www.Example.com/blog/post", true, true},
+		{"https://notexample.com/", true, false},
+		{"https://golang.org/doc/", true, true},
+		{"https://go.golang.org/", true, false},
+		{"https://private.example.com/", false, false},
+		{"https://example.com/admin/users", false, false},
+		{"https://other.com/login", false, false},
+		{"https://other.com/", true, false},
+		{"://invalid", false, false},
+	}
+
+	open := NewCrawlScope(rules, false)
+	scoped := NewCrawlScope(rules, true)
+	for _, tc := range testCases {
+		t.Run(tc.url, func(st *testing.T) {
+			assert.Equal(st, tc.open, open.Allows(tc.url))
+			assert.Equal(st, tc.scoped, scoped.Allows(tc.url))
+		})
+	}
+
+	assert.False(t, NewCrawlScope(nil, true).Allows("https://example.com/"))
+}
+
+// dryRunDB returns a database that builds the SQL
+// of the queries without running them.
+func dryRunDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	assert.NoError(t, err)
+
+	return db
+}
+
+func TestCrawlScopeApply(t *testing.T) {
+	db := dryRunDB(t)
+
+	scope := NewCrawlScope([]CrawlRule{
+		{Kind: RuleSubdomain, Pattern: "*.example.com", Allow: true},
+		{Kind: RuleHost, Pattern: "golang.org", Allow: true},
+		{Kind: RulePath, Pattern: "^/admin/?", Allow: false},
+	}, true)
+
+	stmt := scope.apply(db.Model(&CrawledUrl{})).Find(&[]CrawledUrl{}).Statement
+	sql := stmt.SQL.String()
+
+	// The `?` of the patterns are bound as values, not taken as placeholders
+	assert.Contains(t, sql, "NOT (coalesce(nullif(substring(url from $1), ''), '/') ~ $2)")
+	assert.Contains(t, sql, "coalesce(lower(substring(url from $3)), '') = $4")
+	assert.Contains(t, sql, "right(coalesce(lower(substring(url from $5)), ''), $6) = $7")
+	assert.Contains(t, sql, "coalesce(lower(substring(url from $8)), '') = $9")
+	assert.Equal(t, []any{
+		pathPattern, "^/admin/?",
+		hostPattern, "example.com", hostPattern, 12, ".example.com",
+		hostPattern, "golang.org",
+	}, stmt.Vars)
+}
+
+func TestCrawlRuleSaveChecksPath(t *testing.T) {
+	db := dryRunDB(t)
+
+	// Postgres rejects the expression checked
+	checked := []any{}
+	db.Callback().Row().After("gorm:row").Register("test:reject", func(tx *gorm.DB) {
+		if tx.Statement.SQL.String() == "SELECT '' ~ $1" {
+			checked = append(checked, tx.Statement.Vars...)
+			tx.Error = &pgconn.PgError{
+				Code:    "2201B",
+				Message: "invalid regular expression: invalid escape \\ sequence",
+			}
+		}
+	})
+	rs := NewCrawlRuleServices(CrawlRule{}, db)
+
+	// The expression is valid in Go, but not in Postgres
+	rule, err := NewCrawlRule(RulePath, `^/\Qa.b\E`, false)
+	assert.NoError(t, err)
+	err = rs.Save(context.Background(), &rule)
+	assert.ErrorIs(t, err, ErrInvalidRule)
+	assert.ErrorContains(t, err, "invalid escape")
+	assert.Equal(t, []any{`^/\Qa.b\E`}, checked)
+
+	// The other rules are not checked
+	checked = []any{}
+	rule, err = NewCrawlRule(RuleHost, "example.com", false)
+	assert.NoError(t, err)
+	_ = rs.Save(context.Background(), &rule)
+	assert.Empty(t, checked)
+}
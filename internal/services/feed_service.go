@@ -0,0 +1,95 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Feed struct {
+	ID           string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Url          string     `gorm:"unique;not null" json:"url"`
+	Host         string     `gorm:"index;not null" json:"host"`
+	Title        string     `json:"title"`
+	ETag         string     `gorm:"column:etag" json:"-"` // Validators for conditional requests
+	LastModified string     `json:"-"`
+	ItemCount    int        `json:"itemCount"`
+	LastError    string     `json:"lastError"`
+	LastPolled   *time.Time `json:"lastPolled"` // Use pointer so this value can be nil
+	CreatedAt    time.Time  `gorm:"datetime:timestamp;default:CURRENT_TIMESTAMP" json:"createdAt"`
+	UpdatedAt    time.Time  `gorm:"datetime:timestamp" json:"updatedAt"`
+}
+
+type FeedServices struct {
+	Feed      Feed
+	FeedStore *gorm.DB
+}
+
+func NewFeedServices(f Feed, fStore *gorm.DB) FeedServices {
+
+	return FeedServices{
+		Feed:      f,
+		FeedStore: fStore,
+	}
+}
+
+// Save stores the feed if it is not stored yet.
+func (fs *FeedServices) Save(ctx context.Context, input *Feed) error {
+	normalized, err := NormalizeUrl(input.Url)
+	if err != nil {
+		return fmt.Errorf("the feed could not be saved: %s", err)
+	}
+	input.Url = normalized
+
+	tx := fs.FeedStore.WithContext(ctx).
+		Where(Feed{Url: input.Url}).
+		Attrs(Feed{Host: input.Host, Title: input.Title}).
+		FirstOrCreate(input)
+	if tx.Error != nil {
+		return fmt.Errorf("the feed could not be saved: %s", tx.Error)
+	}
+
+	return nil
+}
+
+// GetDue returns the feeds never polled or
+// polled before the given time, oldest first.
+func (fs *FeedServices) GetDue(
+	ctx context.Context, before time.Time, limit uint) ([]Feed, error,
+) {
+	var feeds []Feed
+
+	tx := fs.FeedStore.WithContext(ctx).
+		Where("last_polled IS NULL OR last_polled < ?", before).
+		Order("last_polled ASC NULLS FIRST").
+		Limit(int(limit)).
+		Find(&feeds)
+	if tx.Error != nil {
+		return []Feed{}, fmt.Errorf("feeds not found: %s", tx.Error)
+	}
+
+	return feeds, nil
+}
+
+// UpdatePolled stores the result of polling the feed.
+func (fs *FeedServices) UpdatePolled(ctx context.Context, input Feed) error {
+	tx := fs.FeedStore.WithContext(ctx).
+		Select(
+			"title",
+			"etag",
+			"last_modified",
+			"item_count",
+			"last_error",
+			"last_polled",
+			"updated_at",
+		).
+		Omit("created_at").
+		Save(&input)
+	if tx.Error != nil {
+		return fmt.Errorf("feed not updated: %s", tx.Error)
+	}
+
+	return nil
+}
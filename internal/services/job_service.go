@@ -0,0 +1,132 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// JobStatus is the state of a run of a job.
+type JobStatus string
+
+const (
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	JobFailed    JobStatus = "failed"
+	JobSkipped   JobStatus = "skipped" // e.g. when the search is turned off
+)
+
+// What started a run of a job.
+const (
+	TriggerCron   = "cron"
+	TriggerManual = "manual"
+	TriggerFeeds  = "feeds" // Index of the articles crawled by the feeds job
+)
+
+// Job is a run of a job of the engine (crawl, index…), kept as its history.
+type Job struct {
+	ID         uint          `gorm:"primaryKey" json:"id"`
+	Name       string        `gorm:"index;not null" json:"name"`
+	Trigger    string        `gorm:"not null" json:"trigger"`
+	Status     JobStatus     `gorm:"index;not null" json:"status"`
+	StartedAt  time.Time     `gorm:"index" json:"startedAt"`
+	FinishedAt *time.Time    `json:"finishedAt"` // Use pointer so this value can be nil
+	Duration   time.Duration `json:"duration"`
+	Queued     int           `json:"queued"`
+	Fetched    int           `json:"fetched"`
+	Failed     int           `json:"failed"`
+	Added      int           `json:"added"`
+	Indexed    int           `json:"indexed"`
+	Error      string        `json:"error"`
+}
+
+// maxJobs is the number of runs kept in the history of the jobs.
+const maxJobs = 500
+
+type JobServices struct {
+	Job      Job
+	JobStore *gorm.DB
+}
+
+func NewJobServices(j Job, jStore *gorm.DB) JobServices {
+
+	return JobServices{
+		Job:      j,
+		JobStore: jStore,
+	}
+}
+
+// Start stores the run as running, removing
+// the oldest runs beyond `maxJobs`.
+func (js *JobServices) Start(ctx context.Context, input *Job) error {
+	input.Status = JobRunning
+
+	tx := js.JobStore.WithContext(ctx).Create(input)
+	if tx.Error != nil {
+		return fmt.Errorf("the job could not be saved: %s", tx.Error)
+	}
+
+	tx = js.JobStore.WithContext(ctx).
+		Where(
+			"id NOT IN (?)",
+			js.JobStore.Model(&Job{}).
+				Select("id").
+				Order("started_at DESC").
+				Limit(maxJobs),
+		).
+		Delete(&Job{})
+	if tx.Error != nil {
+		return fmt.Errorf("the history could not be pruned: %s", tx.Error)
+	}
+
+	return nil
+}
+
+// Finish stores the result of the run.
+func (js *JobServices) Finish(ctx context.Context, input Job) error {
+	tx := js.JobStore.WithContext(ctx).
+		Select(
+			"status", "finished_at", "duration", "queued", "fetched",
+			"failed", "added", "indexed", "error",
+		).
+		Updates(&input)
+	if tx.Error != nil {
+		return fmt.Errorf("the job could not be updated: %s", tx.Error)
+	}
+
+	return nil
+}
+
+// GetRecent returns the latest runs, the newest first.
+func (js *JobServices) GetRecent(ctx context.Context, limit int) ([]Job, error) {
+	var jobs []Job
+
+	tx := js.JobStore.WithContext(ctx).
+		Order("started_at DESC").
+		Limit(limit).
+		Find(&jobs)
+	if tx.Error != nil {
+		return []Job{}, fmt.Errorf("jobs not found: %s", tx.Error)
+	}
+
+	return jobs, nil
+}
+
+// AbortRunning marks as failed the runs left running
+// by a previous process, e.g. after a crash.
+func (js *JobServices) AbortRunning(ctx context.Context) error {
+	tx := js.JobStore.WithContext(ctx).
+		Model(&Job{}).
+		Where("status = ?", JobRunning).
+		Updates(map[string]any{
+			"status": JobFailed,
+			"error":  "interrupted by a restart of the server",
+		})
+	if tx.Error != nil {
+		return fmt.Errorf("the running jobs could not be aborted: %s", tx.Error)
+	}
+
+	return nil
+}
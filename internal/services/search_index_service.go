@@ -1,15 +1,19 @@
 package services
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"strings"
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type SearchIndex struct {
-	ID        string `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
-	Value     string
+	ID        string         `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()"`
+	Value     string         `gorm:"uniqueIndex"`
 	Urls      []CrawledUrl   `gorm:"many2many:token_urls;"`
 	CreatedAt time.Time      `gorm:"datetime:timestamp;default:CURRENT_TIMESTAMP"`
 	UpdatedAt time.Time      `gorm:"datetime:timestamp"`
@@ -37,14 +41,43 @@ func (si *SearchIndex) TableName() string {
 	return "search_index"
 }
 
+// Save stores the index in a single transaction, so that
+// an interrupted indexing does not leave a partial index.
+// The urls lose the terms of their previous index, if any.
 func (sis *SearchIndexServices) Save(
-	i map[string][]string, crUrls []CrawledUrl,
+	ctx context.Context, i map[string][]string, crUrls []CrawledUrl,
 ) error {
+
+	return sis.IndexStore.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return saveIndex(tx, i, crUrls)
+	})
+}
+
+func saveIndex(tx *gorm.DB, i map[string][]string, crUrls []CrawledUrl) error {
+	ids := make([]string, len(crUrls))
+	for n, url := range crUrls {
+		ids[n] = url.ID
+	}
+	if len(ids) > 0 {
+		if err := tx.
+			Exec("DELETE FROM token_urls WHERE crawled_url_id IN ?", ids).
+			Error; err != nil {
+			return err
+		}
+	}
+
 	for value, ids := range i {
+		// Each term is stored once: an existing one is returned
+		// (and restored, if deleted) instead of being inserted
 		newIndex := &SearchIndex{Value: value}
-		if err := sis.IndexStore.
-			Where(SearchIndex{Value: value}).
-			FirstOrCreate(newIndex).Error; err != nil {
+		if err := tx.
+			Clauses(clause.OnConflict{
+				Columns: []clause.Column{{Name: "value"}},
+				DoUpdates: clause.Assignments(map[string]any{
+					"deleted_at": nil, "updated_at": time.Now(),
+				}),
+			}).
+			Create(newIndex).Error; err != nil {
 			return err
 		}
 
@@ -59,7 +92,7 @@ func (sis *SearchIndexServices) Save(
 			}
 		}
 
-		if err := sis.IndexStore.
+		if err := tx.
 			Model(&newIndex).
 			Association("Urls").
 			Append(&urlsToAppend); err != nil {
@@ -71,14 +104,36 @@ func (sis *SearchIndexServices) Save(
 	return nil
 }
 
-func (sis *SearchIndexServices) SearchFullText(v string) ([]CrawledUrl, error) {
+// SearchOptions narrows down the results of a search.
+type SearchOptions struct {
+	// schema.org type declared by the page (e.g. "Recipe"), if any
+	SchemaType string
+}
+
+func (sis *SearchIndexServices) SearchFullText(
+	ctx context.Context, v string, opts SearchOptions,
+) ([]CrawledUrl, error) {
 	terms := strings.Fields(v)
 	var urls []CrawledUrl
 
+	// Near-duplicates and redirects are only shown through their
+	// canonical or final url, and the pages that asked not to be
+	// indexed are never shown
+	conditions := "COALESCE(duplicate_of, '') = '' AND COALESCE(redirect_to, '') = '' AND no_index = ?"
+	args := []any{false}
+	if opts.SchemaType != "" {
+		types, err := json.Marshal([]string{opts.SchemaType})
+		if err != nil {
+			return nil, err
+		}
+		conditions += " AND structured_data->'types' @> ?::jsonb"
+		args = append(args, string(types))
+	}
+
 	for _, term := range terms {
 		var searchIndexes []SearchIndex
-		if err := sis.IndexStore.
-			Preload("Urls").
+		if err := sis.IndexStore.WithContext(ctx).
+			Preload("Urls", append([]any{conditions}, args...)...).
 			Where("value LIKE ?", "%"+term+"%").
 			Find(&searchIndexes).
 			Error; err != nil {
@@ -93,7 +148,191 @@ func (sis *SearchIndexServices) SearchFullText(v string) ([]CrawledUrl, error) {
 	return urls, nil
 }
 
+// SearchResult is a url found by a search.
+type SearchResult struct {
+	ID              string
+	Url             string
+	PageTitle       string
+	PageDescription string
+	Content         string
+	NoArchive       bool // The page asked not to show its text
+	Score           int  // Number of terms of the search found in the url
+}
+
+// SearchPage is a page of the results of a search.
+type SearchPage struct {
+	Results  []SearchResult
+	Total    int64
+	Page     int
+	PageSize int
+}
+
+// Pages returns the number of pages of the results.
+func (sp SearchPage) Pages() int {
+	if sp.PageSize == 0 {
+		return 0
+	}
+
+	return int((sp.Total + int64(sp.PageSize) - 1) / int64(sp.PageSize))
+}
+
+// searchResultRow is a result of the search query,
+// along with the number of results of the search.
+type searchResultRow struct {
+	SearchResult
+	Total int64
+}
+
+// rankedQuery returns the query of the urls that contain any of the terms,
+// or a word starting with them, ranked by the number of terms they contain,
+// exactly first.
+func rankedQuery(tx *gorm.DB, terms []string, opts SearchOptions) (*gorm.DB, error) {
+	matches := make([]string, len(terms))
+	score := make([]string, len(terms))
+	exact := make([]string, len(terms))
+	matchArgs := []any{}
+	scoreArgs := []any{}
+	for i, term := range terms {
+		matches[i] = "search_index.value LIKE ?"
+		score[i] = "bool_or(search_index.value LIKE ?)::int"
+		exact[i] = "bool_or(search_index.value = ?)::int"
+		matchArgs = append(matchArgs, term+"%")
+		scoreArgs = append(scoreArgs, term+"%")
+	}
+	for _, term := range terms {
+		scoreArgs = append(scoreArgs, term)
+	}
+
+	// Near-duplicates and redirects are only shown through their
+	// canonical or final url, and the pages that asked not to be
+	// indexed are never shown
+	conditions := "COALESCE(crawled_urls.duplicate_of, '') = '' AND " +
+		"COALESCE(crawled_urls.redirect_to, '') = '' AND crawled_urls.no_index = ?"
+	args := []any{false}
+	if opts.SchemaType != "" {
+		types, err := json.Marshal([]string{opts.SchemaType})
+		if err != nil {
+			return nil, err
+		}
+		conditions += " AND crawled_urls.structured_data->'types' @> ?::jsonb"
+		args = append(args, string(types))
+	}
+
+	return tx.
+		Table("crawled_urls").
+		Select(
+			fmt.Sprintf(
+				`crawled_urls.id, crawled_urls.url, crawled_urls.page_title,
+				crawled_urls.page_description, crawled_urls.content, crawled_urls.no_archive,
+				(%s) AS score, (%s) AS exact, count(*) OVER () AS total`,
+				strings.Join(score, " + "), strings.Join(exact, " + "),
+			),
+			scoreArgs...,
+		).
+		Joins("JOIN token_urls ON token_urls.crawled_url_id = crawled_urls.id").
+		Joins("JOIN search_index ON search_index.id = token_urls.search_index_id").
+		Where("crawled_urls.deleted_at IS NULL AND search_index.deleted_at IS NULL").
+		Where(conditions, args...).
+		Where("("+strings.Join(matches, " OR ")+")", matchArgs...).
+		Group("crawled_urls.id").
+		Order("score DESC, exact DESC, crawled_urls.priority DESC, crawled_urls.url"), nil
+}
+
+// SearchRanked returns a page of the urls that contain any of the given
+// (analyzed) terms, the urls that contain more of them first.
+func (sis *SearchIndexServices) SearchRanked(
+	ctx context.Context, terms []string, opts SearchOptions, page, pageSize int,
+) (SearchPage, error) {
+	result := SearchPage{Results: []SearchResult{}, Page: max(page, 1), PageSize: pageSize}
+	if len(terms) == 0 {
+		return result, nil
+	}
+
+	query, err := rankedQuery(sis.IndexStore.WithContext(ctx), terms, opts)
+	if err != nil {
+		return result, fmt.Errorf("search failed: %s", err)
+	}
+
+	var rows []searchResultRow
+	tx := query.
+		Offset((result.Page - 1) * result.PageSize).
+		Limit(result.PageSize).
+		Scan(&rows)
+	if tx.Error != nil {
+		return result, fmt.Errorf("search failed: %s", tx.Error)
+	}
+
+	for _, r := range rows {
+		result.Results = append(result.Results, r.SearchResult)
+		result.Total = r.Total
+	}
+
+	return result, nil
+}
+
+// GetTermCandidates returns the terms of the index that could be
+// a misspelling of the given one: those with the same initial
+// and a similar length, to suggest them when nothing is found.
+func (sis *SearchIndexServices) GetTermCandidates(
+	ctx context.Context, term string, limit int,
+) ([]string, error) {
+	initial := []rune(term)
+	if len(initial) == 0 {
+		return []string{}, nil
+	}
+
+	terms := []string{}
+	tx := sis.IndexStore.WithContext(ctx).
+		Model(&SearchIndex{}).
+		Where("value LIKE ?", string(initial[0])+"%").
+		Where("length(value) BETWEEN ? AND ?", len(initial)-2, len(initial)+2).
+		Order("value").
+		Limit(limit).
+		Pluck("value", &terms)
+	if tx.Error != nil {
+		return []string{}, fmt.Errorf("terms not found: %s", tx.Error)
+	}
+
+	return terms, nil
+}
+
+// IndexStats are the size of the index.
+type IndexStats struct {
+	Documents int64 `json:"documents"` // Urls in the index
+	Terms     int64 `json:"terms"`     // Vocabulary size
+}
+
+// GetStats returns the size of the index.
+func (sis *SearchIndexServices) GetStats(ctx context.Context) (IndexStats, error) {
+	stats := IndexStats{}
+
+	tx := sis.IndexStore.WithContext(ctx).
+		Model(&SearchIndex{}).
+		Count(&stats.Terms)
+	if tx.Error != nil {
+		return IndexStats{}, fmt.Errorf("terms not counted: %s", tx.Error)
+	}
+
+	tx = sis.IndexStore.WithContext(ctx).
+		Table("token_urls").
+		Joins("JOIN search_index ON search_index.id = token_urls.search_index_id").
+		Where("search_index.deleted_at IS NULL").
+		Distinct("token_urls.crawled_url_id").
+		Count(&stats.Documents)
+	if tx.Error != nil {
+		return IndexStats{}, fmt.Errorf("documents not counted: %s", tx.Error)
+	}
+
+	return stats, nil
+}
+
 /* OVERRIDE TABLE NAME IN GORM:
 https://gorm.io/docs/conventions.html#TableName
 https://stackoverflow.com/questions/44589060/how-to-set-singular-name-for-a-table-in-gorm
+
+TRANSACTIONS IN GORM:
+https://gorm.io/docs/transactions.html
+
+JSONB CONTAINMENT (`@>`):
+https://www.postgresql.org/docs/current/datatype-json.html#JSON-CONTAINMENT
 */
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestRankedQuery(t *testing.T) {
+	query, err := rankedQuery(
+		dryRunDB(t), []string{"golang", "crawler"}, SearchOptions{SchemaType: "Article"},
+	)
+	assert.NoError(t, err)
+
+	stmt := query.Limit(10).Scan(&[]searchResultRow{}).Statement
+	sql := stmt.SQL.String()
+
+	assert.Contains(t, sql,
+		"(bool_or(search_index.value LIKE $1)::int + bool_or(search_index.value LIKE $2)::int) AS score")
+	assert.Contains(t, sql,
+		"(bool_or(search_index.value = $3)::int + bool_or(search_index.value = $4)::int) AS exact")
+	assert.Contains(t, sql, "(search_index.value LIKE $7 OR search_index.value LIKE $8)")
+	assert.Contains(t, sql, "COALESCE(crawled_urls.redirect_to, '') = ''")
+	assert.Contains(t, sql, `GROUP BY "crawled_urls"."id"`)
+	assert.True(t, strings.HasSuffix(sql,
+		"ORDER BY score DESC, exact DESC, crawled_urls.priority DESC, crawled_urls.url LIMIT $9"))
+	assert.Equal(t, []any{
+		"golang%", "crawler%", "golang", "crawler",
+		false, `["Article"]`,
+		"golang%", "crawler%", 10,
+	}, stmt.Vars)
+}
+
+func TestSaveIndex(t *testing.T) {
+	db := dryRunDB(t).Session(&gorm.Session{SkipDefaultTransaction: true})
+
+	var sql []string
+	db.Callback().Raw().After("gorm:raw").Register("test:sql", func(tx *gorm.DB) {
+		sql = append(sql, tx.Statement.SQL.String())
+	})
+
+	// The urls indexed again lose their previous terms
+	err := saveIndex(db, map[string][]string{}, []CrawledUrl{{ID: "a"}, {ID: "b"}})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"DELETE FROM token_urls WHERE crawled_url_id IN ($1,$2)"}, sql)
+
+	// and the terms are upserted, so that each one is stored once
+	var created string
+	db.Callback().Create().After("gorm:create").Register("test:create", func(tx *gorm.DB) {
+		if term, ok := tx.Statement.Dest.(*SearchIndex); ok {
+			created = tx.Statement.SQL.String()
+			term.ID = "id" // As returned by the database
+		}
+	})
+	err = saveIndex(db, map[string][]string{"gopher": {}}, []CrawledUrl{})
+	assert.NoError(t, err)
+	assert.Contains(t, created, `INSERT INTO "search_index"`)
+	assert.Contains(t, created,
+		`ON CONFLICT ("value") DO UPDATE SET "deleted_at"=$`)
+	assert.Contains(t, created, `RETURNING "id"`)
+}
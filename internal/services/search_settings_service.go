@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -8,13 +9,30 @@ import (
 )
 
 type SearchSettings struct {
-	ID        uint      `gorm:"primaryKey" json:"id"`
-	SearchOn  bool      `json:"searchOn"`
-	AddNew    bool      `json:"addNew"`
-	Amount    uint      `json:"amount"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	SearchOn       bool      `json:"searchOn"`
+	AddNew         bool      `json:"addNew"`
+	Amount         uint      `json:"amount"`
+	CrawlTimeout   uint      `gorm:"default:50" json:"crawlTimeout"`   // Deadline of each crawl run, in minutes
+	RequestTimeout uint      `gorm:"default:10" json:"requestTimeout"` // Time limit of each request, in seconds
+	MaxBodySize    uint      `gorm:"default:10" json:"maxBodySize"`    // Size of the body read from each url, in MB
+	MaxDomDepth    uint      `gorm:"default:512" json:"maxDomDepth"`
+	MaxLinks       uint      `gorm:"default:1000" json:"maxLinks"`             // Links kept from each page
+	ScopeOn        bool      `gorm:"default:false" json:"scopeOn"`             // Only crawl the urls allowed by the rules
+	CrawlSchedule  string    `gorm:"default:'0 * * * *'" json:"crawlSchedule"` // Cron expression of the crawl runs
+	IndexSchedule  string    `gorm:"default:'5 * * * *'" json:"indexSchedule"` // Cron expression of the index runs
+	AnonymousOn    bool      `gorm:"default:true" json:"anonymousOn"`          // The search API can be used without a key
+	AnonymousRate  uint      `gorm:"default:10" json:"anonymousRate"`          // Requests per minute of each client without a key
+	UpdatedAt      time.Time `json:"updatedAt"`
 }
 
+// The schedules of the jobs that are not set in the settings:
+// crawl every hour and index 5 minutes after each crawl.
+const (
+	DefaultCrawlSchedule = "0 * * * *"
+	DefaultIndexSchedule = "5 * * * *"
+)
+
 type SearchSettingsServices struct {
 	SearchSettings      SearchSettings
 	SearchSettingsStore *gorm.DB
@@ -30,29 +48,59 @@ func NewSearchSettingsServices(
 	}
 }
 
-func (sss *SearchSettingsServices) Get() (SearchSettings, error) {
+// Get returns the settings as stored. The services are shared by the
+// handlers and the jobs, so no state is kept between the calls.
+func (sss *SearchSettingsServices) Get(ctx context.Context) (SearchSettings, error) {
+	settings := SearchSettings{}
 
-	if err := sss.SearchSettingsStore.
+	if err := sss.SearchSettingsStore.WithContext(ctx).
 		Where("id = 1").
-		First(&sss.SearchSettings).
+		First(&settings).
 		Error; err != nil {
 		return SearchSettings{}, err
 	}
 
-	return sss.SearchSettings, nil
+	return settings, nil
 }
 
 func (sss *SearchSettingsServices) Upadate(
-	amount uint, searchOn, addNew bool,
+	ctx context.Context, input SearchSettings,
 ) error {
-	sss.SearchSettings.Amount = amount
-	sss.SearchSettings.SearchOn = searchOn
-	sss.SearchSettings.AddNew = addNew
+	settings := SearchSettings{
+		Amount:         input.Amount,
+		SearchOn:       input.SearchOn,
+		AddNew:         input.AddNew,
+		CrawlTimeout:   input.CrawlTimeout,
+		RequestTimeout: input.RequestTimeout,
+		MaxBodySize:    input.MaxBodySize,
+		MaxDomDepth:    input.MaxDomDepth,
+		MaxLinks:       input.MaxLinks,
+		ScopeOn:        input.ScopeOn,
+		CrawlSchedule:  input.CrawlSchedule,
+		IndexSchedule:  input.IndexSchedule,
+		AnonymousOn:    input.AnonymousOn,
+		AnonymousRate:  input.AnonymousRate,
+	}
 
-	tx := sss.SearchSettingsStore.
-		Select("search_on", "add_new", "amount", "updated_at").
+	tx := sss.SearchSettingsStore.WithContext(ctx).
+		Select(
+			"search_on",
+			"add_new",
+			"amount",
+			"crawl_timeout",
+			"request_timeout",
+			"max_body_size",
+			"max_dom_depth",
+			"max_links",
+			"scope_on",
+			"crawl_schedule",
+			"index_schedule",
+			"anonymous_on",
+			"anonymous_rate",
+			"updated_at",
+		).
 		Where("id = 1").
-		Updates(&sss.SearchSettings)
+		Updates(&settings)
 	if tx.Error != nil {
 		return fmt.Errorf("search not updated: %s", tx.Error)
 	}
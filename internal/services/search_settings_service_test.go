@@ -0,0 +1,34 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestSearchSettingsUpadate(t *testing.T) {
+	db := dryRunDB(t).Session(&gorm.Session{SkipDefaultTransaction: true})
+	sss := NewSearchSettingsServices(SearchSettings{}, db)
+
+	var sql string
+	var vars []any
+	db.Callback().Update().After("gorm:update").Register("test:sql", func(tx *gorm.DB) {
+		sql = tx.Statement.SQL.String()
+		vars = tx.Statement.Vars
+	})
+
+	err := sss.Upadate(context.Background(), SearchSettings{
+		ID: 7, Amount: 5, SearchOn: true, CrawlSchedule: "@hourly",
+	})
+	assert.NoError(t, err)
+
+	// The single row of the settings is written, even the zero values
+	assert.Contains(t, sql, `"search_on"=$1,"add_new"=$2,"amount"=$3`)
+	assert.Contains(t, sql, `WHERE id = 1`)
+	assert.Equal(t, []any{true, false, uint(5)}, vars[:3])
+
+	// and nothing is kept by the services, which are shared
+	assert.Equal(t, SearchSettings{}, sss.SearchSettings)
+}
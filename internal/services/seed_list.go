@@ -0,0 +1,65 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// MaxSeedList is the maximum number of urls imported at once.
+const MaxSeedList = 10000
+
+// ParseSeedList reads the urls of a list of seeds, pasted or uploaded
+// as a text file (one url per line) or as a CSV file (whose first field
+// that looks like a url is taken, so that headers are skipped). Blank
+// lines and lines starting with `#` are ignored.
+func ParseSeedList(r io.Reader) ([]string, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	urls := []string{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return urls, fmt.Errorf("invalid list of urls: %s", err)
+		}
+
+		for _, field := range record {
+			field = strings.TrimSpace(field)
+			if looksLikeUrl(field) {
+				urls = append(urls, field)
+				break
+			}
+		}
+
+		if len(urls) > MaxSeedList {
+			return urls[:MaxSeedList], fmt.Errorf(
+				"only the first %d urls can be imported at once", MaxSeedList,
+			)
+		}
+	}
+
+	return urls, nil
+}
+
+// looksLikeUrl reports whether the field is a url, even an invalid one
+// (so that it is reported), rather than a header or another column.
+func looksLikeUrl(field string) bool {
+	lower := strings.ToLower(field)
+
+	return strings.Contains(lower, "://") ||
+		strings.HasPrefix(lower, "www.")
+}
+
+/* CSV FILES:
+https://pkg.go.dev/encoding/csv#Reader
+https://datatracker.ietf.org/doc/html/rfc4180
+*/
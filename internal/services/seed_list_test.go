@@ -0,0 +1,51 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSeedList(t *testing.T) {
+	testCases := []struct {
+		name     string
+		list     string
+		expected []string
+	}{
+		{
+			name:     "text",
+			list:     "https://a.com/\n\n  https://b.com/page  \r\n# comment\nhttps://c.com",
+			expected: []string{"https://a.com/", "https://b.com/page", "https://c.com"},
+		},
+		{
+			name:     "csv",
+			list:     "title,url,notes\nHome,https://a.com/,\"first, seed\"\n\"Blog\",\"https://b.com/?a=1,2\",",
+			expected: []string{"https://a.com/", "https://b.com/?a=1,2"},
+		},
+		{
+			name:     "invalid urls are kept to be reported",
+			list:     "ftp://files.com/\nwww.example.com\nnot a url",
+			expected: []string{"ftp://files.com/", "www.example.com"},
+		},
+		{
+			name:     "empty",
+			list:     "",
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			urls, err := ParseSeedList(strings.NewReader(tc.list))
+			assert.NoError(st, err)
+			assert.Equal(st, tc.expected, urls)
+		})
+	}
+
+	urls, err := ParseSeedList(strings.NewReader(
+		strings.Repeat("https://a.com/\n", MaxSeedList+1),
+	))
+	assert.Error(t, err)
+	assert.Len(t, urls, MaxSeedList)
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Sitemap struct {
+	ID          string     `gorm:"primaryKey;type:uuid;default:uuid_generate_v4()" json:"id"`
+	Url         string     `gorm:"unique;not null" json:"url"`
+	Host        string     `gorm:"index;not null" json:"host"`
+	UrlCount    int        `json:"urlCount"`
+	LastError   string     `json:"lastError"`
+	LastFetched *time.Time `json:"lastFetched"` // Use pointer so this value can be nil
+	CreatedAt   time.Time  `gorm:"datetime:timestamp;default:CURRENT_TIMESTAMP" json:"createdAt"`
+	UpdatedAt   time.Time  `gorm:"datetime:timestamp" json:"updatedAt"`
+}
+
+type SitemapServices struct {
+	Sitemap      Sitemap
+	SitemapStore *gorm.DB
+}
+
+func NewSitemapServices(s Sitemap, sStore *gorm.DB) SitemapServices {
+
+	return SitemapServices{
+		Sitemap:      s,
+		SitemapStore: sStore,
+	}
+}
+
+// HasHost reports whether the sitemaps of the host were already discovered.
+func (ss *SitemapServices) HasHost(ctx context.Context, host string) (bool, error) {
+	var count int64
+
+	tx := ss.SitemapStore.WithContext(ctx).
+		Model(&Sitemap{}).
+		Where("host = ?", host).
+		Count(&count)
+	if tx.Error != nil {
+		return false, fmt.Errorf("sitemaps not found: %s", tx.Error)
+	}
+
+	return count > 0, nil
+}
+
+// Save stores the sitemap if it is not stored yet.
+func (ss *SitemapServices) Save(ctx context.Context, input *Sitemap) error {
+	normalized, err := NormalizeUrl(input.Url)
+	if err != nil {
+		return fmt.Errorf("the sitemap could not be saved: %s", err)
+	}
+	input.Url = normalized
+
+	tx := ss.SitemapStore.WithContext(ctx).
+		Where(Sitemap{Url: input.Url}).
+		Attrs(Sitemap{Host: input.Host}).
+		FirstOrCreate(input)
+	if tx.Error != nil {
+		return fmt.Errorf("the sitemap could not be saved: %s", tx.Error)
+	}
+
+	return nil
+}
+
+// GetDue returns the sitemaps never fetched or
+// fetched before the given time, oldest first.
+func (ss *SitemapServices) GetDue(
+	ctx context.Context, before time.Time, limit uint) ([]Sitemap, error,
+) {
+	var sitemaps []Sitemap
+
+	tx := ss.SitemapStore.WithContext(ctx).
+		Where("last_fetched IS NULL OR last_fetched < ?", before).
+		Order("last_fetched ASC NULLS FIRST").
+		Limit(int(limit)).
+		Find(&sitemaps)
+	if tx.Error != nil {
+		return []Sitemap{}, fmt.Errorf("sitemaps not found: %s", tx.Error)
+	}
+
+	return sitemaps, nil
+}
+
+// UpdateFetched stores the result of fetching the sitemap.
+func (ss *SitemapServices) UpdateFetched(ctx context.Context, input Sitemap) error {
+	tx := ss.SitemapStore.WithContext(ctx).
+		Select("url_count", "last_error", "last_fetched", "updated_at").
+		Omit("created_at").
+		Save(&input)
+	if tx.Error != nil {
+		return fmt.Errorf("sitemap not updated: %s", tx.Error)
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package services
+
+import "time"
+
+// StructuredData is the metadata that a page declares for rich results
+// with OpenGraph/Twitter card tags, JSON-LD blocks and microdata.
+// It is stored as a JSON column of the crawled url.
+type StructuredData struct {
+	// schema.org types of the items of the page, e.g. "Article", "Recipe"
+	Types       []string   `json:"types,omitempty"`
+	Title       string     `json:"title,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Image       string     `json:"image,omitempty"`
+	Author      string     `json:"author,omitempty"`
+	SiteName    string     `json:"siteName,omitempty"`
+	PublishedAt *time.Time `json:"publishedAt,omitempty"`
+	// `og:*`, `article:*` and `twitter:*` meta tags, e.g. "og:image"
+	OpenGraph map[string]string `json:"openGraph,omitempty"`
+	JsonLd    []map[string]any  `json:"jsonLd,omitempty"`
+	Microdata []MicrodataItem   `json:"microdata,omitempty"`
+}
+
+// MicrodataItem is each of the top-level `itemscope` elements of a page.
+// The values of its properties are strings or nested items.
+type MicrodataItem struct {
+	Type       string           `json:"type,omitempty"`
+	Properties map[string][]any `json:"properties,omitempty"`
+}
+
+/* STRUCTURED DATA:
+https://ogp.me/
+https://developer.x.com/en/docs/twitter-for-websites/cards/overview/markup
+https://json-ld.org/spec/latest/json-ld/
+https://html.spec.whatwg.org/multipage/microdata.html
+https://gorm.io/docs/serializer.html
+*/
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Statuses of a url, to filter the list of urls.
+const (
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+	StatusPending = "pending" // Not crawled yet
+	StatusPaused  = "paused"
+)
+
+// urlSortColumns are the columns by which the list of urls can be sorted.
+var urlSortColumns = map[string]string{
+	"url":          "url",
+	"lastTested":   "last_tested",
+	"responseCode": "response_code",
+	"duration":     "crawl_duration",
+	"createdAt":    "created_at",
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// UrlFilter selects, sorts and paginates the list of urls.
+// The zero value lists all the urls, the newest first.
+type UrlFilter struct {
+	Status       string
+	Host         string
+	Indexed      *bool
+	ResponseCode int
+	Sort         string // A key of `urlSortColumns`
+	Desc         bool
+	Page         int // From 1
+	PageSize     int
+}
+
+// UrlPage is a page of the list of urls.
+type UrlPage struct {
+	Urls     []CrawledUrl `json:"urls"`
+	Total    int64        `json:"total"`
+	Page     int          `json:"page"`
+	PageSize int          `json:"pageSize"`
+}
+
+// Pages returns the number of pages of the list.
+func (up UrlPage) Pages() int {
+	if up.PageSize == 0 {
+		return 0
+	}
+
+	return int((up.Total + int64(up.PageSize) - 1) / int64(up.PageSize))
+}
+
+// where filters the query of urls.
+func (f UrlFilter) where(tx *gorm.DB) *gorm.DB {
+	switch f.Status {
+	case StatusSuccess:
+		tx = tx.Where("success = ?", true)
+	case StatusFailed:
+		tx = tx.Where("success = ? AND last_tested IS NOT NULL", false)
+	case StatusPending:
+		tx = tx.Where("last_tested IS NULL")
+	case StatusPaused:
+		tx = tx.Where("paused = ?", true)
+	}
+
+	if host := strings.ToLower(strings.TrimSpace(f.Host)); host != "" {
+		cond, args := CrawlRule{Kind: RuleHost, Pattern: host}.condition()
+		tx = tx.Where(cond, args...)
+	}
+	if f.Indexed != nil {
+		tx = tx.Where("indexed = ?", *f.Indexed)
+	}
+	if f.ResponseCode != 0 {
+		tx = tx.Where("response_code = ?", f.ResponseCode)
+	}
+
+	return tx
+}
+
+// List returns a page of the urls that match the filter,
+// without their content, outlinks and structured data.
+func (u *UrlServices) List(ctx context.Context, f UrlFilter) (UrlPage, error) {
+	page := UrlPage{Urls: []CrawledUrl{}, Page: max(f.Page, 1), PageSize: f.PageSize}
+	if page.PageSize <= 0 {
+		page.PageSize = defaultPageSize
+	}
+	page.PageSize = min(page.PageSize, maxPageSize)
+
+	tx := f.where(u.UrlStore.WithContext(ctx).Model(&CrawledUrl{})).
+		Count(&page.Total)
+	if tx.Error != nil {
+		return page, fmt.Errorf("urls not counted: %s", tx.Error)
+	}
+
+	order := "created_at"
+	if column, ok := urlSortColumns[f.Sort]; ok {
+		order = column
+	}
+	direction := "ASC"
+	if f.Desc || f.Sort == "" {
+		direction = "DESC"
+	}
+
+	tx = f.where(u.UrlStore.WithContext(ctx)).
+		Omit("content", "outlinks", "structured_data").
+		Order(fmt.Sprintf("%s %s NULLS LAST", order, direction)).
+		Order("id").
+		Offset((page.Page - 1) * page.PageSize).
+		Limit(page.PageSize).
+		Find(&page.Urls)
+	if tx.Error != nil {
+		return page, fmt.Errorf("urls not found: %s", tx.Error)
+	}
+
+	return page, nil
+}
+
+// GetById returns the url with the given ID.
+func (u *UrlServices) GetById(ctx context.Context, id string) (CrawledUrl, error) {
+	url := CrawledUrl{}
+
+	tx := u.UrlStore.WithContext(ctx).Where("id = ?", id).First(&url)
+	if tx.Error != nil {
+		return CrawledUrl{}, fmt.Errorf("url not found: %s", tx.Error)
+	}
+
+	return url, nil
+}
+
+// GetTerms returns the index terms that point to the url.
+func (u *UrlServices) GetTerms(ctx context.Context, id string) ([]string, error) {
+	terms := []string{}
+
+	tx := u.UrlStore.WithContext(ctx).
+		Table("search_index").
+		Joins("JOIN token_urls ON token_urls.search_index_id = search_index.id").
+		Where("token_urls.crawled_url_id = ? AND search_index.deleted_at IS NULL", id).
+		Order("search_index.value").
+		Pluck("search_index.value", &terms)
+	if tx.Error != nil {
+		return []string{}, fmt.Errorf("terms not found: %s", tx.Error)
+	}
+
+	return terms, nil
+}
+
+// MarkForReindex removes the url from the index, so that it is
+// indexed again, with its current content, in the next index run.
+func (u *UrlServices) MarkForReindex(ctx context.Context, id string) error {
+
+	return u.UrlStore.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.
+			Exec("DELETE FROM token_urls WHERE crawled_url_id = ?", id).
+			Error; err != nil {
+			return fmt.Errorf("url not removed from the index: %s", err)
+		}
+
+		if err := tx.
+			Model(&CrawledUrl{}).
+			Where("id = ?", id).
+			Update("indexed", false).
+			Error; err != nil {
+			return fmt.Errorf("url not updated: %s", err)
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUrlFilterWhere(t *testing.T) {
+	indexed := false
+	testCases := []struct {
+		name     string
+		filter   UrlFilter
+		expected string
+		vars     []any
+	}{
+		{"all", UrlFilter{}, `SELECT * FROM "crawled_urls" WHERE "crawled_urls"."deleted_at" IS NULL`, nil},
+		{"failed", UrlFilter{Status: StatusFailed}, "success = $1 AND last_tested IS NOT NULL", []any{false}},
+		{"pending", UrlFilter{Status: StatusPending}, "last_tested IS NULL", nil},
+		{
+			"host, indexed and code",
+			UrlFilter{Host: " Example.com", Indexed: &indexed, ResponseCode: 404},
+			"coalesce(lower(substring(url from $1)), '') = $2 AND indexed = $3 AND response_code = $4",
+			[]any{hostPattern, "example.com", false, 404},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(st *testing.T) {
+			stmt := tc.filter.where(dryRunDB(st)).Find(&[]CrawledUrl{}).Statement
+			assert.Contains(st, stmt.SQL.String(), tc.expected)
+			assert.ElementsMatch(st, tc.vars, stmt.Vars)
+		})
+	}
+}
+
+func TestUrlPagePages(t *testing.T) {
+	assert.Equal(t, 0, UrlPage{Total: 0, PageSize: 50}.Pages())
+	assert.Equal(t, 1, UrlPage{Total: 50, PageSize: 50}.Pages())
+	assert.Equal(t, 2, UrlPage{Total: 51, PageSize: 50}.Pages())
+	assert.Equal(t, 0, UrlPage{Total: 51}.Pages())
+}
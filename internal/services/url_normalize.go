@@ -0,0 +1,124 @@
+package services
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// trackingParams are query parameters that only carry campaign or
+// click tracking information and never change the content of a page.
+var trackingParams = map[string]struct{}{
+	"gclid": {}, "fbclid": {}, "msclkid": {}, "yclid": {}, "dclid": {},
+	"mc_cid": {}, "mc_eid": {}, "_ga": {}, "_gl": {}, "igshid": {},
+	"ref_src": {}, "spm": {},
+}
+
+// defaultPorts maps each supported scheme to the port
+// that can be omitted from the url.
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// NormalizeUrl returns the canonical form of the given url so that
+// equivalent addresses are stored only once. It lowercases the scheme
+// and host, removes the default port, the fragment and the tracking
+// parameters, resolves the dot-segments of the path and sorts the query.
+// Only absolute http(s) urls are accepted.
+func NormalizeUrl(rawUrl string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(rawUrl))
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %s", err)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if _, ok := defaultPorts[u.Scheme]; !ok {
+		return "", fmt.Errorf("unsupported url scheme: %q", u.Scheme)
+	}
+
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if host == "" {
+		return "", fmt.Errorf("url without host: %q", rawUrl)
+	}
+	if strings.Contains(host, ":") {
+		// IPv6 literal
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && port != defaultPorts[u.Scheme] {
+		host = host + ":" + port
+	}
+	u.Host = host
+
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+	u.Path = removeDotSegments(u.Path)
+	u.RawPath = ""
+	u.RawQuery = normalizeQuery(u.Query())
+	u.ForceQuery = false
+
+	return u.String(), nil
+}
+
+// normalizeQuery removes the tracking parameters
+// and encodes the rest sorted by key and value.
+func normalizeQuery(q url.Values) string {
+	for key := range q {
+		k := strings.ToLower(key)
+		if _, ok := trackingParams[k]; ok || strings.HasPrefix(k, "utm_") {
+			q.Del(key)
+		}
+	}
+
+	for _, values := range q {
+		sort.Strings(values)
+	}
+
+	// `Encode` already sorts by key
+	return q.Encode()
+}
+
+// removeDotSegments resolves the "." and ".." segments of
+// the path as described in RFC 3986, section 5.2.4.
+// An empty path becomes the root path.
+func removeDotSegments(p string) string {
+	if p == "" {
+		return "/"
+	}
+
+	segments := strings.Split(p, "/")
+	out := make([]string, 0, len(segments))
+	for i, s := range segments {
+		last := i == len(segments)-1
+		switch s {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, s)
+		}
+	}
+
+	result := strings.Join(out, "/")
+	if !strings.HasPrefix(result, "/") {
+		result = "/" + result
+	}
+
+	return result
+}
+
+/* URL NORMALIZATION:
+https://en.wikipedia.org/wiki/URI_normalization
+https://datatracker.ietf.org/doc/html/rfc3986#section-5.2.4
+https://datatracker.ietf.org/doc/html/rfc3986#section-6
+*/
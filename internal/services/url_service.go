@@ -1,6 +1,7 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -16,13 +17,81 @@ type CrawledUrl struct {
 	PageTitle       string         `json:"pageTitle"`
 	PageDescription string         `json:"pageDescription"`
 	Headings        string         `json:"headings"`
-	LastTested      *time.Time     `json:"lastTested"` // Use pointer so this value can be nil
+	ContentType     string         `json:"contentType"`
+	Content         string         `json:"-"`            // Text of the document, none if `NoArchive`
+	CanonicalUrl    string         `json:"canonicalUrl"` // Value of `<link rel="canonical">`, if it points elsewhere
+	ContentHash     string         `gorm:"index" json:"contentHash"`
+	SimHash         int64          `json:"simHash"`
+	DuplicateOf     string         `gorm:"index" json:"duplicateOf"`       // ID of the canonical member of its cluster of duplicates
+	NoIndex         bool           `json:"noIndex" gorm:"default:false"`   // The page asked not to be indexed
+	NoArchive       bool           `json:"noArchive" gorm:"default:false"` // The page asked not to keep a cached copy
+	RedirectTo      string         `gorm:"index" json:"redirectTo"`        // Final url, if this one is a redirect alias
+	RedirectChain   string         `json:"redirectChain"`
+	StructuredData  StructuredData `gorm:"serializer:json;type:jsonb" json:"structuredData"`
+	Outlinks        []string       `gorm:"serializer:json;type:jsonb" json:"outlinks"` // Links found in the page
+	RetryCount      int            `json:"retryCount" gorm:"default:0"`                // Retries after transient failures
+	FailureKind     FailureKind    `gorm:"index" json:"failureKind"`                   // Why the last crawl failed, if it did
+	FailureReason   string         `json:"failureReason"`
+	Truncated       bool           `json:"truncated" gorm:"default:false"`    // The document exceeded the crawl limits
+	LastTested      *time.Time     `json:"lastTested"`                        // Use pointer so this value can be nil
+	IsSeed          bool           `gorm:"index;default:false" json:"isSeed"` // Added by the admin as a starting url
+	Paused          bool           `gorm:"default:false" json:"paused"`       // Not crawled until it is resumed
+	Priority        float64        `gorm:"default:0.5" json:"priority"`       // Crawl priority (0.0-1.0), as in sitemaps
+	ChangeFreq      string         `json:"changeFreq"`
+	LastModified    *time.Time     `json:"lastModified"`
+	NextCrawlAt     *time.Time     `gorm:"index" json:"nextCrawlAt"` // When the url must be crawled again, if ever
 	Indexed         bool           `json:"indexed" gorm:"default:false"`
 	CreatedAt       time.Time      `gorm:"datetime:timestamp;default:CURRENT_TIMESTAMP" json:"createdAt"`
 	UpdatedAt       time.Time      `gorm:"datetime:timestamp" json:"updatedAt"`
 	DeletedAt       gorm.DeletedAt `gorm:"index"`
 }
 
+// FailureKind is the cause of a failed crawl.
+type FailureKind string
+
+const (
+	FailureDns         FailureKind = "dns"
+	FailureTls         FailureKind = "tls"
+	FailureTimeout     FailureKind = "timeout"
+	FailureConnection  FailureKind = "connection"
+	FailureRedirect    FailureKind = "redirect"
+	FailureHttpStatus  FailureKind = "http_status"
+	FailureContentType FailureKind = "content_type"
+	FailureTooLarge    FailureKind = "too_large"
+	FailureParse       FailureKind = "parse"
+	FailureRobots      FailureKind = "robots"
+	FailureOther       FailureKind = "other"
+)
+
+var failureLabels = map[FailureKind]string{
+	FailureDns:         "DNS failure",
+	FailureTls:         "TLS error",
+	FailureTimeout:     "Timeout",
+	FailureConnection:  "Connection error",
+	FailureRedirect:    "Redirect loop",
+	FailureHttpStatus:  "HTTP status",
+	FailureContentType: "Unsupported content type",
+	FailureTooLarge:    "Too large",
+	FailureParse:       "Parse error",
+	FailureRobots:      "Blocked by robots.txt",
+	FailureOther:       "Other",
+}
+
+// Label returns the name of the kind of failure shown in the dashboard.
+func (k FailureKind) Label() string {
+	if label, ok := failureLabels[k]; ok {
+		return label
+	}
+
+	return string(k)
+}
+
+// FailureCount is the number of urls whose last crawl failed for a cause.
+type FailureCount struct {
+	Kind  FailureKind
+	Count int64
+}
+
 type UrlServices struct {
 	Url      CrawledUrl
 	UrlStore *gorm.DB
@@ -36,8 +105,9 @@ func NewUrlServices(u CrawledUrl, uStore *gorm.DB) UrlServices {
 	}
 }
 
-func (u *UrlServices) UpdateUrl(input CrawledUrl) error {
-	tx := u.UrlStore.Select(
+// UpdateUrl stores a crawl of the url, which must be indexed again.
+func (u *UrlServices) UpdateUrl(ctx context.Context, input CrawledUrl) error {
+	tx := u.UrlStore.WithContext(ctx).Select(
 		"url",
 		"success",
 		"crawl_duration",
@@ -45,7 +115,24 @@ func (u *UrlServices) UpdateUrl(input CrawledUrl) error {
 		"page_title",
 		"page_description",
 		"headings",
+		"content_type",
+		"content",
+		"canonical_url",
+		"content_hash",
+		"sim_hash",
+		"no_index",
+		"no_archive",
+		"redirect_to",
+		"redirect_chain",
+		"structured_data",
+		"outlinks",
+		"truncated",
+		"retry_count",
+		"failure_kind",
+		"failure_reason",
 		"last_tested",
+		"next_crawl_at",
+		"indexed",
 		"updated_at",
 	).Omit("created_at").Save(&input)
 	if tx.Error != nil {
@@ -55,10 +142,41 @@ func (u *UrlServices) UpdateUrl(input CrawledUrl) error {
 	return nil
 }
 
-func (u *UrlServices) GetNextCrawlUrls(limit uint) ([]CrawledUrl, error) {
+// UpdateFailedCrawl stores the status of a failed crawl of the url, which
+// keeps the document of its last successful crawl, if any, and its index.
+func (u *UrlServices) UpdateFailedCrawl(ctx context.Context, input CrawledUrl) error {
+	tx := u.UrlStore.WithContext(ctx).Model(&input).Select(
+		"success",
+		"response_code",
+		"retry_count",
+		"failure_kind",
+		"failure_reason",
+		"redirect_chain",
+		"last_tested",
+		"next_crawl_at",
+		"updated_at",
+	).Updates(&input)
+	if tx.Error != nil {
+		return fmt.Errorf("url not updated: %s", tx.Error)
+	}
+
+	return nil
+}
+
+// GetNextCrawlUrls returns the urls that are due to be crawled, not
+// paused and allowed by the rules of the scope, highest priority first.
+func (u *UrlServices) GetNextCrawlUrls(
+	ctx context.Context, limit uint, scope CrawlScope) ([]CrawledUrl, error,
+) {
 	var urls []CrawledUrl
 
-	tx := u.UrlStore.Where("last_tested IS NULL").Limit(int(limit)).Find(&urls)
+	tx := scope.apply(u.UrlStore.WithContext(ctx)).
+		Where("last_tested IS NULL OR next_crawl_at <= ?", time.Now()).
+		Where("paused = ?", false).
+		Order("priority DESC").
+		Order("created_at ASC").
+		Limit(int(limit)).
+		Find(&urls)
 	if tx.Error != nil {
 		return []CrawledUrl{}, fmt.Errorf("urls not found: %s", tx.Error)
 	}
@@ -66,7 +184,108 @@ func (u *UrlServices) GetNextCrawlUrls(limit uint) ([]CrawledUrl, error) {
 	return urls, nil
 }
 
-/* func (u *UrlServices) SaveBatch(input *[]CrawledUrl) error {
+// SaveSeed stores the url as a seed, which is crawled in the next run.
+// A url that is already stored (even if deleted) becomes a seed.
+// Returns whether the url was added.
+func (u *UrlServices) SaveSeed(ctx context.Context, rawUrl string) (bool, error) {
+	normalized, err := NormalizeUrl(rawUrl)
+	if err != nil {
+		return false, fmt.Errorf("the seed could not be saved: %s", err)
+	}
+
+	url := CrawledUrl{}
+	tx := u.UrlStore.WithContext(ctx).
+		Unscoped().
+		Where(CrawledUrl{Url: normalized}).
+		Attrs(CrawledUrl{IsSeed: true}).
+		FirstOrCreate(&url)
+	if tx.Error != nil {
+		return false, fmt.Errorf("the seed could not be saved: %s", tx.Error)
+	}
+	if tx.RowsAffected > 0 {
+		return true, nil
+	}
+
+	// The url must be crawled again if it was deleted
+	restored := url.DeletedAt.Valid
+	updates := map[string]any{"is_seed": true, "deleted_at": nil}
+	if restored {
+		updates["last_tested"] = nil
+	}
+	tx = u.UrlStore.WithContext(ctx).
+		Unscoped().
+		Model(&url).
+		Updates(updates)
+	if tx.Error != nil {
+		return false, fmt.Errorf("the seed could not be updated: %s", tx.Error)
+	}
+
+	return restored, nil
+}
+
+// GetSeeds returns the seeds, the newest first.
+func (u *UrlServices) GetSeeds(ctx context.Context) ([]CrawledUrl, error) {
+	var urls []CrawledUrl
+
+	tx := u.UrlStore.WithContext(ctx).
+		Omit("content").
+		Where("is_seed = ?", true).
+		Order("created_at DESC").
+		Find(&urls)
+	if tx.Error != nil {
+		return []CrawledUrl{}, fmt.Errorf("seeds not found: %s", tx.Error)
+	}
+
+	return urls, nil
+}
+
+// SetPaused pauses or resumes the crawl of the url.
+func (u *UrlServices) SetPaused(ctx context.Context, id string, paused bool) error {
+	tx := u.UrlStore.WithContext(ctx).
+		Model(&CrawledUrl{}).
+		Where("id = ?", id).
+		Update("paused", paused)
+	if tx.Error != nil {
+		return fmt.Errorf("url not updated: %s", tx.Error)
+	}
+	if tx.RowsAffected == 0 {
+		return fmt.Errorf("url not found: %s", id)
+	}
+
+	return nil
+}
+
+// Delete removes the url, which is not crawled nor found in searches
+// anymore, although it is kept (soft-deleted) in the database.
+func (u *UrlServices) Delete(ctx context.Context, id string) error {
+	tx := u.UrlStore.WithContext(ctx).Delete(&CrawledUrl{}, "id = ?", id)
+	if tx.Error != nil {
+		return fmt.Errorf("url not deleted: %s", tx.Error)
+	}
+
+	return nil
+}
+
+// CountFailures returns the number of urls whose last
+// crawl failed for each kind of failure, most frequent first.
+func (u *UrlServices) CountFailures(ctx context.Context) ([]FailureCount, error) {
+	var counts []FailureCount
+
+	tx := u.UrlStore.WithContext(ctx).
+		Model(&CrawledUrl{}).
+		Select("failure_kind AS kind, count(*) AS count").
+		Where("success = ? AND failure_kind <> ''", false).
+		Group("failure_kind").
+		Order("count DESC").
+		Scan(&counts)
+	if tx.Error != nil {
+		return []FailureCount{}, fmt.Errorf("failures not counted: %s", tx.Error)
+	}
+
+	return counts, nil
+}
+
+/* func (u *UrlServices) SaveBatch(ctx context.Context, input *[]CrawledUrl) error {
 	tx := u.UrlStore.Create(input)
 	if tx.Error != nil {
 		return fmt.Errorf("some urls could not be saved: %s", tx.Error)
@@ -75,8 +294,16 @@ func (u *UrlServices) GetNextCrawlUrls(limit uint) ([]CrawledUrl, error) {
 	return nil
 } */
 
-func (u *UrlServices) Save(input *CrawledUrl) error {
-	tx := u.UrlStore.Save(input)
+// Save normalizes the url before storing it, so that the `unique`
+// constraint also rejects the equivalent forms of an existing url.
+func (u *UrlServices) Save(ctx context.Context, input *CrawledUrl) error {
+	normalized, err := NormalizeUrl(input.Url)
+	if err != nil {
+		return fmt.Errorf("the url could not be saved: %s", err)
+	}
+	input.Url = normalized
+
+	tx := u.UrlStore.WithContext(ctx).Save(input)
 	if tx.Error != nil {
 		return fmt.Errorf("the url could not be saved: %s", tx.Error)
 	}
@@ -84,10 +311,99 @@ func (u *UrlServices) Save(input *CrawledUrl) error {
 	return nil
 }
 
-func (u *UrlServices) GetNotIndexed() ([]CrawledUrl, error) {
+// SaveWithHints stores a url listed in a sitemap or a feed with its crawl
+// hints. If the url is already stored its hints are updated, and it is
+// scheduled to be crawled again when it was modified after the last crawl.
+// Returns the stored url and whether it was created.
+func (u *UrlServices) SaveWithHints(
+	ctx context.Context, input CrawledUrl) (CrawledUrl, bool, error,
+) {
+	normalized, err := NormalizeUrl(input.Url)
+	if err != nil {
+		return CrawledUrl{}, false, fmt.Errorf("the url could not be saved: %s", err)
+	}
+
+	url := CrawledUrl{}
+	tx := u.UrlStore.WithContext(ctx).
+		Where(CrawledUrl{Url: normalized}).
+		Attrs(CrawledUrl{
+			Priority:     input.Priority,
+			ChangeFreq:   input.ChangeFreq,
+			LastModified: input.LastModified,
+		}).
+		FirstOrCreate(&url)
+	if tx.Error != nil {
+		return CrawledUrl{}, false, fmt.Errorf("the url could not be saved: %s", tx.Error)
+	}
+	if tx.RowsAffected > 0 {
+		return url, true, nil
+	}
+
+	url.Priority = input.Priority
+	if input.ChangeFreq != "" {
+		url.ChangeFreq = input.ChangeFreq
+	}
+	if input.LastModified != nil {
+		url.LastModified = input.LastModified
+	}
+	if input.LastModified != nil && url.LastTested != nil &&
+		input.LastModified.After(*url.LastTested) {
+		now := time.Now()
+		url.NextCrawlAt = &now
+	}
+
+	tx = u.UrlStore.WithContext(ctx).
+		Select("priority", "change_freq", "last_modified", "next_crawl_at").
+		Save(&url)
+	if tx.Error != nil {
+		return CrawledUrl{}, false, fmt.Errorf("the url could not be updated: %s", tx.Error)
+	}
+
+	return url, false, nil
+}
+
+// FindOrCreate returns the stored url equivalent to the given one,
+// creating it if it does not exist yet, or restoring it if deleted.
+func (u *UrlServices) FindOrCreate(
+	ctx context.Context, rawUrl string) (CrawledUrl, error,
+) {
+	normalized, err := NormalizeUrl(rawUrl)
+	if err != nil {
+		return CrawledUrl{}, fmt.Errorf("the url could not be saved: %s", err)
+	}
+
+	url := CrawledUrl{}
+	tx := u.UrlStore.WithContext(ctx).
+		Unscoped().
+		Where(CrawledUrl{Url: normalized}).
+		FirstOrCreate(&url)
+	if tx.Error != nil {
+		return CrawledUrl{}, fmt.Errorf(
+			"the url could not be saved: %s", tx.Error,
+		)
+	}
+	if !url.DeletedAt.Valid {
+		return url, nil
+	}
+
+	tx = u.UrlStore.WithContext(ctx).
+		Unscoped().
+		Model(&url).
+		Update("deleted_at", nil)
+	if tx.Error != nil {
+		return CrawledUrl{}, fmt.Errorf(
+			"the url could not be restored: %s", tx.Error,
+		)
+	}
+	url.DeletedAt = gorm.DeletedAt{}
+
+	return url, nil
+}
+
+func (u *UrlServices) GetNotIndexed(ctx context.Context) ([]CrawledUrl, error) {
 	var urls []CrawledUrl
 
-	tx := u.UrlStore.
+	tx := u.UrlStore.WithContext(ctx).
 		Where("indexed = ? AND last_tested IS NOT NULL", false).
 		Find(&urls)
 	if tx.Error != nil {
@@ -99,13 +415,72 @@ func (u *UrlServices) GetNotIndexed() ([]CrawledUrl, error) {
 	return urls, nil
 }
 
-func (u *UrlServices) SetIndexedTrue(urls []CrawledUrl) error {
+// SetIndexedTrue marks the urls as indexed, without writing back the
+// other columns. The urls changed since they were read for the index
+// (e.g. crawled again meanwhile) are left to be indexed again.
+func (u *UrlServices) SetIndexedTrue(
+	ctx context.Context, urls []CrawledUrl, readAt time.Time,
+) error {
+	if len(urls) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(urls))
+	for i, url := range urls {
+		ids[i] = url.ID
+	}
+
+	tx := u.UrlStore.WithContext(ctx).
+		Model(&CrawledUrl{}).
+		Where("id IN ? AND updated_at <= ?", ids, readAt).
+		Update("indexed", true)
+	if tx.Error != nil {
+		return fmt.Errorf(
+			"something went wrong when saving indexed urls: %s",
+			tx.Error,
+		)
+	}
+
+	return nil
+}
+
+// GetFingerprints returns the successfully crawled urls that have
+// a content fingerprint, with only the fields needed to cluster them.
+func (u *UrlServices) GetFingerprints(ctx context.Context) ([]CrawledUrl, error) {
+	var urls []CrawledUrl
+
+	tx := u.UrlStore.WithContext(ctx).
+		Select("id", "url", "content_hash", "sim_hash", "duplicate_of", "created_at").
+		Where("success = ? AND content_hash <> ''", true).
+		Find(&urls)
+	if tx.Error != nil {
+		return []CrawledUrl{}, fmt.Errorf(
+			"something went wrong when getting the fingerprints: %s", tx.Error,
+		)
+	}
+
+	return urls, nil
+}
+
+// SetDuplicateOf stores the `DuplicateOf` field of the given urls.
+// The urls that are no longer duplicates were left out of the index,
+// so they are marked to be indexed in the next index run. It is part
+// of the index run, so it does not count as a change of the urls.
+func (u *UrlServices) SetDuplicateOf(ctx context.Context, urls []CrawledUrl) error {
 	for _, url := range urls {
-		url.Indexed = true
-		tx := u.UrlStore.Save(&url)
+		tx := u.UrlStore.WithContext(ctx).
+			Model(&CrawledUrl{}).
+			Where("id = ?", url.ID).
+			UpdateColumns(map[string]any{
+				"duplicate_of": url.DuplicateOf,
+				"indexed": gorm.Expr(
+					"CASE WHEN ? = '' AND COALESCE(duplicate_of, '') <> '' THEN false ELSE indexed END",
+					url.DuplicateOf,
+				),
+			})
 		if tx.Error != nil {
 			return fmt.Errorf(
-				"something went wrong when saving indexed urls: %s",
+				"something went wrong when saving duplicated urls: %s",
 				tx.Error,
 			)
 		}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func TestUpdateFailedCrawl(t *testing.T) {
+	db := dryRunDB(t).Session(&gorm.Session{SkipDefaultTransaction: true})
+	us := NewUrlServices(CrawledUrl{}, db)
+
+	var sql string
+	db.Callback().Update().After("gorm:update").Register("test:sql", func(tx *gorm.DB) {
+		sql = tx.Statement.SQL.String()
+	})
+
+	tested := time.Now()
+	err := us.UpdateFailedCrawl(context.Background(), CrawledUrl{
+		ID: "id", ResponseCode: 503, FailureKind: FailureHttpStatus, LastTested: &tested,
+	})
+	assert.NoError(t, err)
+
+	// Only the status of the crawl is written, even the zero values
+	assert.Equal(t, `UPDATE "crawled_urls" SET "success"=$1,"response_code"=$2,`+
+		`"redirect_chain"=$3,"retry_count"=$4,"failure_kind"=$5,"failure_reason"=$6,`+
+		`"last_tested"=$7,"next_crawl_at"=$8,"updated_at"=$9 `+
+		`WHERE "crawled_urls"."deleted_at" IS NULL AND "id" = $10`, sql)
+}
+
+func TestSetIndexedTrue(t *testing.T) {
+	db := dryRunDB(t).Session(&gorm.Session{SkipDefaultTransaction: true})
+	us := NewUrlServices(CrawledUrl{}, db)
+
+	var sql string
+	var vars []any
+	db.Callback().Update().After("gorm:update").Register("test:sql", func(tx *gorm.DB) {
+		sql = tx.Statement.SQL.String()
+		vars = tx.Statement.Vars
+	})
+
+	readAt := time.Now()
+	err := us.SetIndexedTrue(context.Background(), []CrawledUrl{
+		{ID: "a", Content: "stale"}, {ID: "b"},
+	}, readAt)
+	assert.NoError(t, err)
+
+	// Only the flag is written, with a single query,
+	// and not on the urls changed since they were read
+	assert.Equal(t, `UPDATE "crawled_urls" SET "indexed"=$1,"updated_at"=$2 `+
+		`WHERE (id IN ($3,$4) AND updated_at <= $5) AND "crawled_urls"."deleted_at" IS NULL`, sql)
+	assert.Equal(t, true, vars[0])
+	assert.Equal(t, []any{"a", "b", readAt}, vars[2:])
+}
+
+func TestSetDuplicateOf(t *testing.T) {
+	db := dryRunDB(t).Session(&gorm.Session{SkipDefaultTransaction: true})
+	us := NewUrlServices(CrawledUrl{}, db)
+
+	var sql string
+	var vars []any
+	db.Callback().Update().After("gorm:update").Register("test:sql", func(tx *gorm.DB) {
+		sql = tx.Statement.SQL.String()
+		vars = tx.Statement.Vars
+	})
+
+	err := us.SetDuplicateOf(context.Background(), []CrawledUrl{{ID: "a"}})
+	assert.NoError(t, err)
+
+	// A url that is no longer a duplicate is indexed again
+	assert.Equal(t, `UPDATE "crawled_urls" SET "duplicate_of"=$1,`+
+		`"indexed"=CASE WHEN $2 = '' AND COALESCE(duplicate_of, '') <> '' THEN false ELSE indexed END `+
+		`WHERE id = $3 AND "crawled_urls"."deleted_at" IS NULL`, sql)
+	assert.Equal(t, []any{"", ""}, vars[:2])
+}
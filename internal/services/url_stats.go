@@ -0,0 +1,169 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// UrlStats are the totals of the stored urls.
+type UrlStats struct {
+	Total       int64         `json:"total"`
+	Crawled     int64         `json:"crawled"` // Crawled successfully
+	Pending     int64         `json:"pending"` // Not crawled yet
+	Failed      int64         `json:"failed"`
+	Indexed     int64         `json:"indexed"`
+	AvgDuration time.Duration `json:"avgDuration"` // Of the crawled urls, successfully or not
+}
+
+// DomainCount is the number of urls of a host.
+type DomainCount struct {
+	Host  string `json:"host"`
+	Count int64  `json:"count"`
+}
+
+// CodeCount is the number of urls with a response code.
+type CodeCount struct {
+	Code  int   `json:"code"`
+	Count int64 `json:"count"`
+}
+
+// GrowthPoint is the number of urls added on a day,
+// and the total number of urls at the end of that day.
+type GrowthPoint struct {
+	Day   time.Time `json:"day"`
+	Added int64     `json:"added"`
+	Total int64     `json:"total"`
+}
+
+// urlStatsRow is the result of the query of the totals,
+// where the average duration is not a whole number.
+type urlStatsRow struct {
+	UrlStats
+	AvgNanos float64
+}
+
+// GetStats returns the totals of the urls.
+func (u *UrlServices) GetStats(ctx context.Context) (UrlStats, error) {
+	row := urlStatsRow{}
+
+	tx := u.UrlStore.WithContext(ctx).
+		Model(&CrawledUrl{}).
+		Select(
+			`count(*) AS total,
+			count(*) FILTER (WHERE success) AS crawled,
+			count(*) FILTER (WHERE last_tested IS NULL) AS pending,
+			count(*) FILTER (WHERE NOT success AND last_tested IS NOT NULL) AS failed,
+			count(*) FILTER (WHERE indexed) AS indexed,
+			coalesce(avg(crawl_duration) FILTER (WHERE last_tested IS NOT NULL), 0) AS avg_nanos`,
+		).
+		Scan(&row)
+	if tx.Error != nil {
+		return UrlStats{}, fmt.Errorf("urls not counted: %s", tx.Error)
+	}
+	row.UrlStats.AvgDuration = time.Duration(row.AvgNanos)
+
+	return row.UrlStats, nil
+}
+
+// GetTopDomains returns the hosts with more urls, the biggest first.
+func (u *UrlServices) GetTopDomains(ctx context.Context, limit int) ([]DomainCount, error) {
+	var counts []DomainCount
+
+	tx := u.UrlStore.WithContext(ctx).
+		Model(&CrawledUrl{}).
+		Select("lower(substring(url from ?)) AS host, count(*) AS count", hostPattern).
+		Group("host").
+		Order("count DESC").
+		Order("host").
+		Limit(limit).
+		Scan(&counts)
+	if tx.Error != nil {
+		return []DomainCount{}, fmt.Errorf("domains not counted: %s", tx.Error)
+	}
+
+	return counts, nil
+}
+
+// GetResponseCodes returns the number of crawled urls by response code.
+func (u *UrlServices) GetResponseCodes(ctx context.Context) ([]CodeCount, error) {
+	var counts []CodeCount
+
+	tx := u.UrlStore.WithContext(ctx).
+		Model(&CrawledUrl{}).
+		Select("response_code AS code, count(*) AS count").
+		Where("last_tested IS NOT NULL").
+		Group("response_code").
+		Order("response_code").
+		Scan(&counts)
+	if tx.Error != nil {
+		return []CodeCount{}, fmt.Errorf("response codes not counted: %s", tx.Error)
+	}
+
+	return counts, nil
+}
+
+// quarterCount is the number of urls added in a quarter of an hour,
+// the unit of the offsets of all the time zones, so that they can be
+// grouped by the days of the time zone of the server.
+type quarterCount struct {
+	Start time.Time
+	Added int64
+}
+
+// GetGrowth returns the urls added on each of the last `days` days,
+// today included, in the time zone of the server (not of the database).
+func (u *UrlServices) GetGrowth(ctx context.Context, days int) ([]GrowthPoint, error) {
+	now := time.Now()
+	from := time.Date(now.Year(), now.Month(), now.Day()-days+1, 0, 0, 0, 0, now.Location())
+
+	var before int64
+	tx := u.UrlStore.WithContext(ctx).
+		Model(&CrawledUrl{}).
+		Where("created_at < ?", from).
+		Count(&before)
+	if tx.Error != nil {
+		return []GrowthPoint{}, fmt.Errorf("urls not counted: %s", tx.Error)
+	}
+
+	var added []quarterCount
+	tx = u.UrlStore.WithContext(ctx).
+		Model(&CrawledUrl{}).
+		Select(
+			"to_timestamp(floor(extract(epoch FROM created_at) / 900) * 900) AS start, count(*) AS added",
+		).
+		Where("created_at >= ?", from).
+		Group("start").
+		Scan(&added)
+	if tx.Error != nil {
+		return []GrowthPoint{}, fmt.Errorf("urls not counted: %s", tx.Error)
+	}
+
+	return growthSeries(before, added, from, days), nil
+}
+
+// growthSeries returns a point for each of the days from the given one
+// (in its time zone), even if no url was added that day, with the
+// running total of urls.
+func growthSeries(before int64, added []quarterCount, from time.Time, days int) []GrowthPoint {
+	byDay := map[string]int64{}
+	for _, a := range added {
+		byDay[a.Start.In(from.Location()).Format(time.DateOnly)] += a.Added
+	}
+
+	series := make([]GrowthPoint, days)
+	total := before
+	for i := range series {
+		day := from.AddDate(0, 0, i)
+		count := byDay[day.Format(time.DateOnly)]
+		total += count
+		series[i] = GrowthPoint{Day: day, Added: count, Total: total}
+	}
+
+	return series
+}
+
+/* POSTGRES AGGREGATE FUNCTIONS:
+https://www.postgresql.org/docs/current/sql-expressions.html#SYNTAX-AGGREGATES
+https://www.postgresql.org/docs/current/functions-aggregate.html
+*/
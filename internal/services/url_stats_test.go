@@ -0,0 +1,37 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGrowthSeries(t *testing.T) {
+	from := time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC)
+	added := []quarterCount{
+		{Start: time.Date(2024, 2, 28, 0, 0, 0, 0, time.UTC), Added: 1},
+		{Start: time.Date(2024, 2, 28, 23, 45, 0, 0, time.UTC), Added: 2},
+		{Start: time.Date(2024, 3, 1, 12, 15, 0, 0, time.UTC), Added: 2},
+	}
+
+	assert.Equal(t, []GrowthPoint{
+		{Day: from, Added: 3, Total: 13},
+		{Day: from.AddDate(0, 0, 1), Added: 0, Total: 13}, // Leap day
+		{Day: from.AddDate(0, 0, 2), Added: 2, Total: 15},
+	}, growthSeries(10, added, from, 3))
+
+	assert.Empty(t, growthSeries(10, added, from, 0))
+
+	// The days are those of the time zone of the server,
+	// whatever the time zone of the database
+	kolkata := time.FixedZone("IST", 5*60*60+30*60)
+	from = time.Date(2024, 2, 28, 0, 0, 0, 0, kolkata)
+	added = []quarterCount{
+		{Start: time.Date(2024, 2, 27, 18, 15, 0, 0, time.UTC), Added: 1}, // 23:45 IST
+		{Start: time.Date(2024, 2, 27, 18, 30, 0, 0, time.UTC), Added: 4}, // 00:00 IST
+	}
+	assert.Equal(t, []GrowthPoint{
+		{Day: from, Added: 4, Total: 14},
+	}, growthSeries(10, added, from, 1))
+}
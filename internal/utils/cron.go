@@ -1,32 +1,178 @@
 package utils
 
 import (
+	"context"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/emarifer/search-engine/internal/search"
 	"github.com/emarifer/search-engine/internal/services"
 	"github.com/robfig/cron/v3"
 )
 
+// feedsSchedule polls the feeds every 10 minutes.
+const feedsSchedule = "*/10 * * * *"
+
+// ParseSchedule validates a cron expression with five fields
+// (minute, hour, day of month, month and day of week) or
+// a descriptor such as "@hourly" or "@every 2h".
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("the schedule cannot be empty")
+	}
+
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: %s", spec, err)
+	}
+
+	return schedule, nil
+}
+
+// NextRuns returns the next `n` times of the schedule after `from`.
+func NextRuns(spec string, from time.Time, n int) ([]time.Time, error) {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]time.Time, 0, n)
+	for next := from; len(runs) < n; {
+		next = schedule.Next(next)
+		if next.IsZero() {
+			break
+		}
+		runs = append(runs, next)
+	}
+
+	return runs, nil
+}
+
+// Scheduler runs the jobs of the engine on the schedules of the
+// settings, which can be changed while it is running. The runs are
+// skipped while a previous run of the same job (e.g. triggered by the
+// admin) is running.
+type Scheduler struct {
+	cron    *cron.Cron
+	jobs    *search.Jobs
+	mu      sync.Mutex
+	entries map[string]cron.EntryID // By job name
+	specs   map[string]string
+}
+
+// StartCronJobs schedules the jobs of the engine with the
+// schedules of the settings. Stop the returned scheduler
+// to wait for the running jobs to finish.
 func StartCronJobs(
-	sss *services.SearchSettingsServices,
-	us *services.UrlServices,
-	sis *services.SearchIndexServices,
-) {
-	c := cron.New()
-	// "0 * * * *" Run every hour
-	c.AddFunc("0 * * * *", func() { search.RunEngine(sss, us) }) // @every 120s
-	// "05 * * * *" Run every hour at 5 minutes past
-	c.AddFunc("05 * * * *", func() { search.RunIndex(us, sis) }) // @every 30s
-
-	// c.AddFunc("29 * * * *", func() { search.RunEngine(sss, us) })
-	// c.AddFunc("30 * * * *", func() { search.RunIndex(us, sis) })
-	// c.AddFunc("31 * * * *", func() { search.RunEngine(sss, us) })
-	// c.AddFunc("32 * * * *", func() { search.RunIndex(us, sis) })
-	// c.AddFunc("33 * * * *", func() { search.RunEngine(sss, us) })
-	// c.AddFunc("34 * * * *", func() { search.RunIndex(us, sis) })
-
-	c.Start()
-	cronCount := len(c.Entries())
+	jobs *search.Jobs, settings services.SearchSettings,
+) (*Scheduler, error) {
+	s := &Scheduler{
+		cron:    cron.New(),
+		jobs:    jobs,
+		entries: map[string]cron.EntryID{},
+		specs:   map[string]string{},
+	}
+
+	if err := s.schedule(search.JobFeeds, feedsSchedule); err != nil {
+		return nil, err
+	}
+	if err := s.Reload(settings); err != nil {
+		fmt.Printf("%s, using the default schedules\n", err)
+		if err := s.Reload(services.SearchSettings{}); err != nil {
+			return nil, err
+		}
+	}
+
+	s.cron.Start()
+	cronCount := len(s.cron.Entries())
 	fmt.Printf("setup %d cron jobs\n", cronCount)
+
+	return s, nil
+}
+
+// Reload schedules the crawl and index jobs with the schedules
+// of the settings, e.g. when the admin changes them. Nothing is
+// changed if any of the schedules is invalid.
+func (s *Scheduler) Reload(settings services.SearchSettings) error {
+	specs := map[string]string{
+		search.JobCrawl: settings.CrawlSchedule,
+		search.JobIndex: settings.IndexSchedule,
+	}
+	if specs[search.JobCrawl] == "" {
+		specs[search.JobCrawl] = services.DefaultCrawlSchedule
+	}
+	if specs[search.JobIndex] == "" {
+		specs[search.JobIndex] = services.DefaultIndexSchedule
+	}
+	for name, spec := range specs {
+		if _, err := ParseSchedule(spec); err != nil {
+			return fmt.Errorf("the %s job was not scheduled: %s", name, err)
+		}
+	}
+
+	for name, spec := range specs {
+		if err := s.schedule(name, spec); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// schedule (re)schedules the job, unless its schedule is the same.
+func (s *Scheduler) schedule(name, spec string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	spec = strings.TrimSpace(spec)
+	if id, ok := s.entries[name]; ok {
+		if s.specs[name] == spec {
+			return nil
+		}
+		s.cron.Remove(id)
+	}
+
+	id, err := s.cron.AddFunc(spec, func() {
+		if _, err := s.jobs.Run(name, services.TriggerCron); err != nil {
+			fmt.Printf("the %s job was not run: %s\n", name, err)
+		}
+	})
+	if err != nil {
+		delete(s.entries, name)
+
+		return fmt.Errorf("the %s job was not scheduled: %s", name, err)
+	}
+	s.entries[name] = id
+	s.specs[name] = spec
+	fmt.Printf("scheduled the %s job at %q\n", name, spec)
+
+	return nil
 }
+
+// Next returns the time of the next scheduled run of the job.
+func (s *Scheduler) Next(name string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.entries[name]
+	if !ok {
+		return time.Time{}
+	}
+
+	return s.cron.Entry(id).Next
+}
+
+// Stop stops scheduling the jobs. The returned context is
+// done when the running jobs have finished.
+func (s *Scheduler) Stop() context.Context {
+
+	return s.cron.Stop()
+}
+
+/* CRON EXPRESSIONS:
+https://pkg.go.dev/github.com/robfig/cron/v3#hdr-CRON_Expression_Format
+https://crontab.guru/
+*/
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emarifer/search-engine/internal/search"
+	"github.com/emarifer/search-engine/internal/services"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec  string
+		valid bool
+	}{
+		{"0 * * * *", true},
+		{" */15 8-20 * * 1-5 ", true},
+		{"@daily", true},
+		{"@every 2h", true},
+		{"", false},
+		{"0 * * *", false},
+		{"0 0 * * * *", false}, // Seconds are not supported
+		{"61 * * * *", false},
+		{"every hour", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := ParseSchedule(tt.spec)
+			assert.Equal(t, tt.valid, err == nil, err)
+		})
+	}
+}
+
+func TestNextRuns(t *testing.T) {
+	from := time.Date(2024, 7, 1, 10, 30, 0, 0, time.UTC)
+
+	runs, err := NextRuns("5 * * * *", from, 3)
+	require.NoError(t, err)
+	assert.Equal(t, []time.Time{
+		time.Date(2024, 7, 1, 11, 5, 0, 0, time.UTC),
+		time.Date(2024, 7, 1, 12, 5, 0, 0, time.UTC),
+		time.Date(2024, 7, 1, 13, 5, 0, 0, time.UTC),
+	}, runs)
+
+	// February 30th never comes
+	runs, err = NextRuns("0 0 30 2 *", from, 3)
+	require.NoError(t, err)
+	assert.Empty(t, runs)
+
+	_, err = NextRuns("nope", from, 3)
+	assert.Error(t, err)
+}
+
+func TestSchedulerReload(t *testing.T) {
+	jobs := search.NewJobs(context.Background(), &search.Engine{}, nil)
+	s, err := StartCronJobs(jobs, services.SearchSettings{})
+	require.NoError(t, err)
+	defer s.Stop()
+
+	// The jobs without a schedule use the default one
+	assert.Equal(t, services.DefaultCrawlSchedule, s.specs[search.JobCrawl])
+	assert.Equal(t, services.DefaultIndexSchedule, s.specs[search.JobIndex])
+	assert.Len(t, s.cron.Entries(), 3)
+	feedsEntry := s.entries[search.JobFeeds]
+
+	err = s.Reload(services.SearchSettings{
+		CrawlSchedule: "@every 1h", IndexSchedule: "30 2 * * *",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "@every 1h", s.specs[search.JobCrawl])
+	assert.Equal(t, "30 2 * * *", s.specs[search.JobIndex])
+	assert.Len(t, s.cron.Entries(), 3)
+	assert.Equal(t, feedsEntry, s.entries[search.JobFeeds])
+	assert.WithinDuration(t, time.Now().Add(time.Hour), s.Next(search.JobCrawl), time.Minute)
+
+	// Nothing changes with an invalid schedule
+	err = s.Reload(services.SearchSettings{
+		CrawlSchedule: "0 * * * *", IndexSchedule: "nope",
+	})
+	assert.Error(t, err)
+	assert.Equal(t, "@every 1h", s.specs[search.JobCrawl])
+	assert.Len(t, s.cron.Entries(), 3)
+}
@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// bucket holds the tokens of a client, as of the last time it was used.
+type bucket struct {
+	tokens    float64
+	last      time.Time
+	perMinute int
+	burst     int
+}
+
+// RateLimiter limits the requests of each client with a token bucket,
+// which holds up to `burst` tokens and is refilled at `perMinute`.
+type RateLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// sweepInterval is how often the idle buckets are removed.
+const sweepInterval = time.Minute
+
+func NewRateLimiter() *RateLimiter {
+
+	return &RateLimiter{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+// refill returns the tokens of the bucket at the given time.
+func (b *bucket) refill(now time.Time) float64 {
+	elapsed := now.Sub(b.last).Minutes()
+
+	return min(float64(b.burst), b.tokens+elapsed*float64(b.perMinute))
+}
+
+// Allow takes a token from the bucket of the client, with the given
+// limits, which may change between calls. If there is none left, it
+// returns how long it takes to have one.
+func (rl *RateLimiter) Allow(client string, perMinute, burst int) (bool, time.Duration) {
+	if perMinute <= 0 || burst <= 0 {
+		return false, time.Minute
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := rl.now()
+	rl.sweep(now)
+
+	b, ok := rl.buckets[client]
+	if !ok {
+		b = &bucket{tokens: float64(burst), last: now}
+		rl.buckets[client] = b
+	}
+	b.perMinute, b.burst = perMinute, burst
+	b.tokens = b.refill(now)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / float64(perMinute) * float64(time.Minute)
+
+		return false, time.Duration(math.Ceil(wait))
+	}
+	b.tokens--
+
+	return true, 0
+}
+
+// sweep removes the buckets that are full again, which are the
+// same as new ones, so that those of past clients do not pile up.
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < sweepInterval {
+		return
+	}
+	rl.lastSweep = now
+
+	for client, b := range rl.buckets {
+		if b.refill(now) >= float64(b.burst) {
+			delete(rl.buckets, client)
+		}
+	}
+}
+
+/* TOKEN BUCKET:
+https://en.wikipedia.org/wiki/Token_bucket
+https://pkg.go.dev/golang.org/x/time/rate
+*/
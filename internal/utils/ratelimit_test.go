@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	rl := NewRateLimiter()
+	rl.now = func() time.Time { return now }
+
+	// The burst is allowed at once, then one request every 2s
+	for range 3 {
+		ok, _ := rl.Allow("a", 30, 3)
+		assert.True(t, ok)
+	}
+	ok, wait := rl.Allow("a", 30, 3)
+	assert.False(t, ok)
+	assert.Equal(t, 2*time.Second, wait)
+
+	// Each client has its own bucket
+	ok, _ = rl.Allow("b", 30, 3)
+	assert.True(t, ok)
+
+	now = now.Add(time.Second)
+	ok, wait = rl.Allow("a", 30, 3)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+
+	now = now.Add(time.Second)
+	ok, _ = rl.Allow("a", 30, 3)
+	assert.True(t, ok)
+	ok, _ = rl.Allow("a", 30, 3)
+	assert.False(t, ok)
+
+	// The bucket is not refilled beyond the burst, which may change
+	now = now.Add(time.Hour)
+	for range 2 {
+		ok, _ = rl.Allow("a", 30, 2)
+		assert.True(t, ok)
+	}
+	ok, _ = rl.Allow("a", 30, 2)
+	assert.False(t, ok)
+
+	ok, wait = rl.Allow("c", 0, 3)
+	assert.False(t, ok)
+	assert.Equal(t, time.Minute, wait)
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	now := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	rl := NewRateLimiter()
+	rl.now = func() time.Time { return now }
+
+	rl.Allow("idle", 60, 10)
+	for range 10 {
+		rl.Allow("busy", 1, 10)
+	}
+	assert.Len(t, rl.buckets, 2)
+
+	// Only the full buckets are removed
+	now = now.Add(sweepInterval)
+	rl.Allow("new", 60, 10)
+	assert.Len(t, rl.buckets, 2)
+	assert.Contains(t, rl.buckets, "busy")
+	assert.Contains(t, rl.buckets, "new")
+}
@@ -0,0 +1,72 @@
+package views
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func renderSearch(t *testing.T, results SearchResults) *goquery.Document {
+	r, w := io.Pipe()
+	go func() {
+		_ = Search(results).Render(context.Background(), w)
+		_ = w.Close()
+	}()
+	doc, err := goquery.NewDocumentFromReader(r)
+	if err != nil {
+		t.Fatalf("failed to read template: %v", err)
+	}
+
+	return doc
+}
+
+func TestSearchView(t *testing.T) {
+	doc := renderSearch(t, SearchResults{
+		Query: "golang",
+		Total: "12",
+		Rows: []SearchResultRow{{
+			Url:   "https://go.dev/",
+			Title: "The Go Programming Language",
+			Snippet: []SnippetPart{
+				{Text: "Build simple systems with "},
+				{Text: "Go", Match: true},
+			},
+		}},
+		Pages: []PageLink{
+			{Label: "1", Href: "/search?q=golang", Current: true},
+			{Label: "2", Href: "/search?page=2&q=golang"},
+		},
+		NextHref: "/search?page=2&q=golang",
+	})
+
+	// The form works without JavaScript
+	if doc.Find(`form[action="/search"][method="get"] input[name="q"]`).AttrOr("value", "") != "golang" {
+		t.Error("expected the query in the search form")
+	}
+	if doc.Find(`ol li a[href="https://go.dev/"]`).Text() == "" {
+		t.Error("expected the result to link to its url")
+	}
+	if doc.Find(`ol li strong`).Text() != "Go" {
+		t.Error("expected the matching words of the snippet to be highlighted")
+	}
+	if doc.Find(`nav a[rel="next"]`).AttrOr("href", "") != "/search?page=2&q=golang" {
+		t.Error("expected a link to the next page")
+	}
+}
+
+func TestSearchViewNoResults(t *testing.T) {
+	doc := renderSearch(t, SearchResults{
+		Query:      "golnag",
+		Suggestion: "golang",
+		SuggestUrl: "/search?q=golang",
+	})
+
+	if doc.Find(`ol`).Length() != 0 {
+		t.Error("expected no results")
+	}
+	if doc.Find(`a[href="/search?q=golang"]`).Text() != "golang" {
+		t.Error("expected a link to the suggested query")
+	}
+}